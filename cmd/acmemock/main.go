@@ -0,0 +1,90 @@
+// acmemock 本地 ACME 证书颁发机构，与 Pebble 类似，用于离线测试网关的自动证书。
+//
+// 以自签名证书提供 HTTPS 目录 https://<addr>/dir，启动时写出两个 PEM 文件：
+// -tls-ca 为目录服务的证书，网关 ACME_CA_FILE 指向它；-root 为签发证书的根证书，
+// 访问网关的客户端需信任它。所有域名的挑战都连接本机的 -http-port/-tls-port。
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"cloudreve-afdianpay/internal/acmemock"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:14000", "监听地址")
+	httpPort := flag.String("http-port", "80", "http-01 验证连接的本机端口，对应网关 HTTP_REDIRECT_PORT")
+	tlsPort := flag.String("tls-port", "443", "tls-alpn-01 验证连接的本机端口，对应网关 PORT")
+	alwaysValid := flag.Bool("always-valid", false, "不验证挑战，直接签发")
+	tlsCA := flag.String("tls-ca", "acmemock-tls.pem", "写出目录服务 HTTPS 证书的文件")
+	rootOut := flag.String("root", "acmemock-root.pem", "写出签发根证书的文件")
+	flag.Parse()
+
+	ca, err := acmemock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ca.AlwaysValid = *alwaysValid
+	ca.HTTPAddr = net.JoinHostPort("127.0.0.1", *httpPort)
+	ca.TLSAddr = net.JoinHostPort("127.0.0.1", *tlsPort)
+	if err := os.WriteFile(*rootOut, ca.RootPEM(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+
+	cert, err := selfSigned(*tlsCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           ca.Handler(),
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("[AcmeMock] directory https://%s/dir always_valid=%v http-01=%s tls-alpn-01=%s", *addr, ca.AlwaysValid, ca.HTTPAddr, ca.TLSAddr)
+	log.Printf("[AcmeMock] 网关 ACME_CA_FILE=%s，客户端信任根证书 %s", *tlsCA, *rootOut)
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// selfSigned 生成 localhost/127.0.0.1 的自签名证书，并将证书写入 certFile
+func selfSigned(certFile string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "acmemock"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
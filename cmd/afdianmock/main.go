@@ -0,0 +1,46 @@
+// afdianmock 本地爱发电模拟服务，用于离线开发与联调。
+//
+// 默认读取当前目录 .env 中的 USER_ID/TOKEN/PORT，回调到本机网关的 /afdian。
+// 网关 .env 设置 AFDIAN_BASE_URL=http://127.0.0.1:9801 后，支付页会跳转到模拟下单页，
+// 查询接口也会请求模拟服务。
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"cloudreve-afdianpay/internal/afdianmock"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load(".env")
+
+	webhook := ""
+	if port := os.Getenv("PORT"); port != "" {
+		webhook = "http://127.0.0.1:" + port + "/afdian"
+	}
+	addr := flag.String("addr", "127.0.0.1:9801", "监听地址")
+	userID := flag.String("user-id", envOr("USER_ID", "mock-author"), "爱发电 user_id，需与网关一致")
+	token := flag.String("token", envOr("TOKEN", "mock-token"), "爱发电 api token，需与网关一致")
+	flag.StringVar(&webhook, "webhook", webhook, "支付后回调的网关地址，留空不回调")
+	flag.Parse()
+
+	m := afdianmock.New(*userID, *token)
+	m.WebhookURL = webhook
+	log.Printf("[AfdianMock] listening on http://%s user_id=%s webhook=%s", *addr, *userID, webhook)
+	log.Printf("[AfdianMock] 下单页 http://%s/order/create?user_id=%s&custom_price=5.00&remark=test", *addr, *userID)
+	if err := http.ListenAndServe(*addr, m.Handler()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/export"
+)
+
+func runOrdersExport(args []string) error {
+	fs := flag.NewFlagSet("orders export", flag.ExitOnError)
+	from := fs.String("from", "", "起始日期（含），格式 2006-01-02")
+	to := fs.String("to", "", "结束日期（不含），格式 2006-01-02")
+	status := fs.String("status", "", "按状态过滤：pending/paid/expired/refunded")
+	site := fs.String("site", "", "按站点过滤，留空导出全部站点")
+	by := fs.String("by", "paid", "日期范围依据：paid 按支付时间，created 按创建时间")
+	format := fs.String("format", export.FormatCSV, "导出格式：csv 或 ndjson")
+	out := fs.String("o", "", "输出文件，留空输出到标准输出")
+	_ = fs.Parse(args)
+
+	f := afdian.OrderFilter{Site: *site, Status: *status, ByPaidAt: *by == "paid"}
+	var err error
+	if f.Since, err = parseDate(*from); err != nil {
+		return err
+	}
+	if f.Until, err = parseDate(*to); err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	ew, err := export.NewWriter(w, *format)
+	if err != nil {
+		return err
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	n := 0
+	if err := svc.EachOrder(f, func(o *afdian.Order) error {
+		n++
+		return ew.Write(o)
+	}); err != nil {
+		return err
+	}
+	if err := ew.Flush(); err != nil {
+		return err
+	}
+	if *out != "" {
+		fmt.Printf("已导出 %d 个订单到 %s\n", n, *out)
+	}
+	return nil
+}
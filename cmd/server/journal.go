@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func runJournalShow(args []string) error {
+	if len(args) != 1 {
+		return errors.New("用法: journal show <order_no|out_trade_no>")
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	list, err := svc.JournalByOrder(args[0])
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("没有回调记录")
+		return nil
+	}
+	for _, e := range list {
+		fmt.Printf("#%d %s verdict=%s order_no=%s out_trade_no=%s\n", e.ID, formatTime(e.ReceivedAt), e.Verdict, e.OrderNo, e.OutTradeNo)
+		fmt.Printf("  headers: %s\n", e.Headers)
+		fmt.Printf("  body: %s\n", e.Body)
+		fmt.Printf("  api_response: %s\n", e.APIResponse)
+	}
+	return nil
+}
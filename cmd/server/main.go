@@ -3,58 +3,112 @@ package main
 import (
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"strings"
 
 	"cloudreve-afdianpay/internal/afdian"
-	"cloudreve-afdianpay/internal/config"
-	"cloudreve-afdianpay/internal/server"
+	"cloudreve-afdianpay/internal/alert"
+	"cloudreve-afdianpay/internal/money"
 
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
+// command 子命令，name 可以是 "orders list" 这样的两级名称
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "启动 HTTP 服务（默认）", runServe},
+	{"migrate", "执行数据库迁移", runMigrate},
+	{"orders list", "列出订单 [-status paid] [-since 2024-01-01] [-limit 20] [-unnotified] [-q 关键字]", runOrdersList},
+	{"orders show", "查看订单详情 <order_no>", runOrdersShow},
+	{"orders export", "导出订单 [-from 2024-01-01] [-to 2024-02-01] [-status paid] [-site URL] [-by paid|created] [-format csv|ndjson] [-o 文件]", runOrdersExport},
+	{"orders mark-paid", "手动将订单标记为已支付并通知 Cloudreve [-notify=false] <order_no>", runOrdersMarkPaid},
+	{"notify replay", "重新通知 Cloudreve，未指定订单号时重放所有未成功通知的已支付订单 [order_no...]", runNotifyReplay},
+	{"review list", "查看付款复核队列 [-status open|attached|dismissed|all] [-limit 50]", runReviewList},
+	{"review attach", "将复核中的付款关联到订单并通知 Cloudreve [-force] [-note 备注] <id> <order_no>", runReviewAttach},
+	{"review dismiss", "忽略复核记录 [-note 备注] <id>", runReviewDismiss},
+	{"webhooks list", "查看事件推送记录 [-subscriber 名称] [-status pending|delivered|failed] [-limit 50]", runWebhooksList},
+	{"webhooks retry", "重新推送事件 <id...>", runWebhooksRetry},
+	{"journal show", "查看爱发电回调与查询响应原始记录 <order_no|out_trade_no>", runJournalShow},
+	{"reconcile", "与爱发电对账一次 [-lookback 720h]", runReconcile},
+	{"config check", "检查 .env 配置", runConfigCheck},
+}
+
 func main() {
 	// 加载 .env
 	_ = godotenv.Load(".env")
 
-	// 初始化检查
-	if err := config.ValidateEnv(); err != nil {
-		log.Fatalf("%v", err)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(rest); err != nil {
+		log.Fatalf("%s: %v", cmd.name, err)
+	}
+}
+
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		parts := strings.Fields(commands[i].name)
+		if len(args) >= len(parts) && strings.Join(args[:len(parts)], " ") == commands[i].name {
+			return &commands[i], args[len(parts):]
+		}
+	}
+	return nil, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法: server <命令> [参数]")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", c.name, c.usage)
 	}
-	fmt.Println("初始化检查通过")
+}
 
-	// Afdian 服务
+// newService 按 .env 配置创建 afdian.Service 并完成数据库迁移
+func newService() (*afdian.Service, error) {
 	dbPath := os.Getenv("DB_PATH")
 	if dbPath == "" {
 		dbPath = "./afdian_pay.db"
 	}
-	fmt.Printf("DB_PATH=%s\n", dbPath)
+	if err := money.Configure(os.Getenv("CURRENCIES_ENABLED"), os.Getenv("CURRENCIES_DISABLED"), os.Getenv("CURRENCY_EXPONENTS")); err != nil {
+		return nil, fmt.Errorf("货币配置错误: %w", err)
+	}
 	svc := afdian.NewService(dbPath)
-	if err := svc.EnsureDB(); err != nil {
-		log.Fatalf("数据库初始化失败: %v", err)
+	svc.DSN = os.Getenv("DB_DSN")
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		svc.Instance = id
 	}
-
-	// Gin
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
-
-	s := server.NewServer(svc)
-	r.POST("/afdian", s.AfdianCallback)
-	r.POST("/order", s.Order)
-	r.GET("/order", s.Order)
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "9000"
+	plans, err := afdian.LoadPlans(os.Getenv("PLAN_MAP_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("方案映射加载失败: %w", err)
 	}
-
-	fmt.Println("Cloudreve Afdian Pay Server\t已启动\nGithub: https://github.com/essesoul/Cloudreve-AfdianPay")
-	fmt.Println("-------------------------")
-	fmt.Println("程序运行端口：" + port)
-	fmt.Printf("SITE_URL=%s\n", os.Getenv("SITE_URL"))
-
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatalf("服务启动失败: %v", err)
+	svc.Plans = plans
+	limits, err := afdian.LoadLimits(os.Getenv("LIMITS_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("金额限制加载失败: %w", err)
+	}
+	svc.Limits = limits
+	subs, err := afdian.LoadSubscribers(os.Getenv("WEBHOOKS_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("事件订阅加载失败: %w", err)
+	}
+	svc.Subscribers = subs
+	alerts, err := alert.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("告警配置错误: %w", err)
+	}
+	svc.Alerts = alerts
+	if err := svc.EnsureDB(); err != nil {
+		return nil, fmt.Errorf("数据库初始化失败: %w", err)
 	}
+	return svc, nil
 }
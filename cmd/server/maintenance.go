@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cloudreve-afdianpay/internal/certs"
+	"cloudreve-afdianpay/internal/config"
+	"cloudreve-afdianpay/internal/money"
+)
+
+func runMigrate(args []string) error {
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	v, err := svc.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("数据库 %s 已迁移至版本 %d\n", svc.Backend(), v)
+	return nil
+}
+
+func runReconcile(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	lookback := fs.Duration("lookback", config.Duration("RECONCILE_LOOKBACK", 30*24*time.Hour), "对账回溯时长")
+	_ = fs.Parse(args)
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	res, err := svc.Reconcile(*lookback)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已扫描 %d 个爱发电订单，发现退款 %d 笔\n", res.Scanned, res.Refunded)
+	return nil
+}
+
+func runConfigCheck(args []string) error {
+	if err := config.ValidateEnv(); err != nil {
+		return err
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	v, err := svc.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("SITE_URL=%s\n", os.Getenv("SITE_URL"))
+	fmt.Printf("PORT=%s\n", os.Getenv("PORT"))
+	fmt.Printf("DB=%s (schema v%d)\n", svc.Backend(), v)
+	fmt.Printf("方案映射: %d 条\n", len(svc.Plans))
+	fmt.Printf("金额限制: %d 个站点配置\n", len(svc.Limits))
+	fmt.Printf("可下单货币: %d 种\n", len(money.Enabled()))
+	fmt.Printf("事件订阅: %d 个\n", len(svc.Subscribers))
+	if svc.Alerts.Enabled() {
+		names := make([]string, 0, len(svc.Alerts.Sinks))
+		for _, s := range svc.Alerts.Sinks {
+			names = append(names, s.Name())
+		}
+		fmt.Printf("告警渠道: %s\n", strings.Join(names, ", "))
+	} else {
+		fmt.Println("告警渠道: 未配置")
+	}
+	tlsManager, err := certs.New(certs.FromEnv())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("HTTPS: %s\n", tlsManager.Mode())
+	if base := os.Getenv("AFDIAN_BASE_URL"); base != "" {
+		fmt.Printf("爱发电地址: %s（非官方地址，仅用于测试）\n", base)
+	}
+	if os.Getenv("ADMIN_TOKEN") == "" {
+		fmt.Println("管理接口: 未启用")
+	} else {
+		fmt.Println("管理接口: 已启用")
+	}
+	fmt.Println("配置检查通过")
+	return nil
+}
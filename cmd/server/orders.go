@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+)
+
+func runOrdersList(args []string) error {
+	fs := flag.NewFlagSet("orders list", flag.ExitOnError)
+	status := fs.String("status", "", "按状态过滤：pending/paid/expired/refunded")
+	since := fs.String("since", "", "起始日期（含），格式 2006-01-02")
+	until := fs.String("until", "", "结束日期（不含），格式 2006-01-02")
+	limit := fs.Int("limit", 20, "最多显示条数，0 表示不限")
+	unnotified := fs.Bool("unnotified", false, "仅显示已支付但未成功通知的订单")
+	q := fs.String("q", "", "按订单号、爱发电订单号、赞助者 ID 或名称搜索")
+	_ = fs.Parse(args)
+
+	f := afdian.OrderFilter{Status: *status, Limit: *limit, Unnotified: *unnotified, Query: *q}
+	var err error
+	if f.Since, err = parseDate(*since); err != nil {
+		return err
+	}
+	if f.Until, err = parseDate(*until); err != nil {
+		return err
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	list, err := svc.ListOrders(f)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ORDER_NO\tAMOUNT\tSTATUS\tCREATED\tPAID\tNOTIFIED\tOUT_TRADE_NO\tSPONSOR")
+	for _, o := range list {
+		fmt.Fprintf(w, "%s\t%s %s\t%s\t%s\t%s\t%s\t%s\t%s\n", o.OrderNo, o.Amount, o.Amount.Currency, o.Status,
+			formatTime(o.CreatedAt), formatTime(o.PaidAt), formatTime(o.NotifiedAt), dash(o.OutTradeNo), dash(o.SponsorName))
+	}
+	return w.Flush()
+}
+
+func runOrdersShow(args []string) error {
+	if len(args) != 1 {
+		return errors.New("用法: orders show <order_no>")
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	o, err := svc.GetOrder(args[0])
+	if err != nil {
+		return err
+	}
+	if o == nil {
+		return afdian.ErrOrderNotFound
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "site\t%s\n", o.Site)
+	fmt.Fprintf(w, "order_no\t%s\n", o.OrderNo)
+	fmt.Fprintf(w, "amount\t%s %s\n", o.Amount, o.Amount.Currency)
+	fmt.Fprintf(w, "status\t%s\n", o.Status)
+	if o.Refunded.Minor != 0 {
+		fmt.Fprintf(w, "refunded\t%s %s\n", o.Refunded, o.Refunded.Currency)
+	}
+	fmt.Fprintf(w, "notify_url\t%s\n", o.NotifyURL)
+	if o.PlanID != "" {
+		fmt.Fprintf(w, "plan_id\t%s\n", o.PlanID)
+	}
+	if o.SkuID != "" {
+		fmt.Fprintf(w, "sku\t%s x%d\n", o.SkuID, o.SkuCount)
+	}
+	fmt.Fprintf(w, "created_at\t%s\n", formatTime(o.CreatedAt))
+	fmt.Fprintf(w, "paid_at\t%s\n", formatTime(o.PaidAt))
+	fmt.Fprintf(w, "notified_at\t%s\n", formatTime(o.NotifiedAt))
+	if o.OutTradeNo != "" {
+		fmt.Fprintf(w, "out_trade_no\t%s\n", o.OutTradeNo)
+		fmt.Fprintf(w, "sponsor\t%s (%s)\n", o.SponsorName, o.SponsorUserID)
+		fmt.Fprintf(w, "afdian_plan_id\t%s\n", dash(o.PaidPlanID))
+		fmt.Fprintf(w, "afdian_paid_at\t%s\n", formatTime(o.AfdianPaidAt))
+	}
+	refunds, err := svc.ListRefunds(o.OrderNo)
+	if err != nil {
+		return err
+	}
+	for _, r := range refunds {
+		fmt.Fprintf(w, "refund #%d\t%s %s source=%s revoke=%s %s %s\n", r.ID, r.Amount, r.Amount.Currency, r.Source, r.RevokeStatus, formatTime(r.CreatedAt), r.Reason)
+	}
+	return w.Flush()
+}
+
+func runOrdersMarkPaid(args []string) error {
+	fs := flag.NewFlagSet("orders mark-paid", flag.ExitOnError)
+	notify := fs.Bool("notify", true, "标记后通知 Cloudreve")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("用法: orders mark-paid [-notify=false] <order_no>")
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	o, err := svc.GetOrder(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if o == nil {
+		return afdian.ErrOrderNotFound
+	}
+	if err := svc.MarkOrderPaid(o.OrderNo, nil); err != nil {
+		return err
+	}
+	fmt.Printf("订单 %s 已标记为已支付\n", o.OrderNo)
+	if !*notify {
+		return nil
+	}
+	if err := svc.NotifyOrder(o); err != nil {
+		return fmt.Errorf("通知 Cloudreve 失败: %w", err)
+	}
+	fmt.Println("已通知 Cloudreve")
+	return nil
+}
+
+func runNotifyReplay(args []string) error {
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	var orders []afdian.Order
+	if len(args) == 0 {
+		if orders, err = svc.ListOrders(afdian.OrderFilter{Unnotified: true}); err != nil {
+			return err
+		}
+	}
+	for _, orderNo := range args {
+		o, err := svc.GetOrder(orderNo)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			return fmt.Errorf("%s: %w", orderNo, afdian.ErrOrderNotFound)
+		}
+		if o.Status != afdian.StatusPaid {
+			return fmt.Errorf("%s: 订单状态为 %s，不能通知", orderNo, o.Status)
+		}
+		orders = append(orders, *o)
+	}
+	failed := 0
+	for i := range orders {
+		notify := svc.NotifyOrder
+		if len(args) > 0 {
+			// 显式指定的订单即使已通知过也重新发送
+			notify = svc.ResendNotify
+		}
+		err := notify(&orders[i])
+		if errors.Is(err, afdian.ErrNotifyClaimed) {
+			fmt.Printf("%s\t跳过: %v\n", orders[i].OrderNo, err)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("%s\t失败: %v\n", orders[i].OrderNo, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s\t成功\n", orders[i].OrderNo)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d 个订单通知失败", failed, len(orders))
+	}
+	return nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的日期 %q，格式应为 2006-01-02", s)
+	}
+	return t, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
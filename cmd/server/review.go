@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"cloudreve-afdianpay/internal/afdian"
+)
+
+func runReviewList(args []string) error {
+	fs := flag.NewFlagSet("review list", flag.ExitOnError)
+	status := fs.String("status", afdian.ReviewOpen, "按状态过滤：open/attached/dismissed/all")
+	limit := fs.Int("limit", 50, "最多显示条数，0 表示不限")
+	_ = fs.Parse(args)
+	if *status == "all" {
+		*status = ""
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	list, err := svc.ListReviews(*status, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOUT_TRADE_NO\tAMOUNT\tREASON\tORDER_NO\tSTATUS\tCREATED\tREMARK\tNOTE")
+	for _, it := range list {
+		fmt.Fprintf(w, "%d\t%s\t%s %s\t%s\t%s\t%s\t%s\t%s\t%s\n", it.ID, dash(it.OutTradeNo), it.Amount, it.Amount.Currency, it.Reason,
+			dash(it.OrderNo), it.Status, formatTime(it.CreatedAt), dash(it.Remark), dash(it.Note))
+	}
+	return w.Flush()
+}
+
+func runReviewAttach(args []string) error {
+	fs := flag.NewFlagSet("review attach", flag.ExitOnError)
+	force := fs.Bool("force", false, "付款金额与订单金额不一致时仍然关联")
+	note := fs.String("note", "", "处理备注")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("用法: review attach [-force] [-note 备注] <id> <order_no>")
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("无效的复核 ID %q", fs.Arg(0))
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	o, err := svc.AttachReview(id, fs.Arg(1), *force, *note)
+	if o == nil {
+		return err
+	}
+	fmt.Printf("付款已关联到订单 %s\n", o.OrderNo)
+	if err != nil {
+		return fmt.Errorf("通知 Cloudreve 失败，可稍后执行 notify replay %s: %w", o.OrderNo, err)
+	}
+	fmt.Println("已通知 Cloudreve")
+	return nil
+}
+
+func runReviewDismiss(args []string) error {
+	fs := flag.NewFlagSet("review dismiss", flag.ExitOnError)
+	note := fs.String("note", "", "处理备注")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("用法: review dismiss [-note 备注] <id>")
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("无效的复核 ID %q", fs.Arg(0))
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	if err := svc.DismissReview(id, *note); err != nil {
+		return err
+	}
+	fmt.Printf("复核记录 %d 已忽略\n", id)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/certs"
+	"cloudreve-afdianpay/internal/config"
+	"cloudreve-afdianpay/internal/server"
+
+	"github.com/gin-gonic/gin"
+)
+
+func runServe(args []string) error {
+	// 初始化检查
+	if err := config.ValidateEnv(); err != nil {
+		return err
+	}
+	fmt.Println("初始化检查通过")
+
+	// Afdian 服务
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	fmt.Printf("DB=%s INSTANCE_ID=%s\n", svc.Backend(), svc.Instance)
+
+	// 收到 SIGINT/SIGTERM 时停止后台任务并优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 多实例共用数据库时，只有持有租约的实例运行过期清理与对账
+	leaseTTL := config.Duration("LEASE_TTL", 30*time.Second)
+	if leaseTTL < afdian.MinLeaseTTL {
+		return fmt.Errorf("LEASE_TTL 不能小于 %s，当前为 %s", afdian.MinLeaseTTL, leaseTTL)
+	}
+	elector := svc.NewElector("background-jobs", leaseTTL)
+	svc.Leader = elector.IsLeader
+	go elector.Run(ctx)
+
+	// 过期订单清理
+	go svc.RunSweeper(ctx, afdian.SweeperConfig{
+		TTL:       config.Duration("ORDER_TTL", 24*time.Hour),
+		Interval:  config.Duration("ORDER_SWEEP_INTERVAL", 5*time.Minute),
+		Retention: config.Duration("EXPIRED_RETENTION", 30*24*time.Hour),
+		// 回调原始记录用于支付纠纷取证，默认保留较长时间
+		JournalRetention: config.Duration("JOURNAL_RETENTION", 365*24*time.Hour),
+		WebhookRetention: config.Duration("WEBHOOK_RETENTION", 30*24*time.Hour),
+	})
+	// 定期对账，发现爱发电侧退款
+	go svc.RunReconciler(ctx, config.Duration("RECONCILE_INTERVAL", time.Hour), config.Duration("RECONCILE_LOOKBACK", 30*24*time.Hour))
+	// 重试未成功的 Cloudreve 通知，各实例逐单认领
+	go svc.RunNotifier(ctx, config.Duration("NOTIFY_RETRY_INTERVAL", time.Minute), config.Duration("NOTIFY_RETRY_WINDOW", 24*time.Hour))
+	// 向外部订阅者推送订单与付款事件，各实例逐条认领
+	go svc.RunWebhooks(ctx, config.Duration("WEBHOOK_RETRY_INTERVAL", 30*time.Second))
+
+	// Gin
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.Default()
+	// 按客户端 IP 限流依赖真实 IP，只信任 TRUSTED_PROXIES 传入的 X-Forwarded-For；未设置时不信任任何代理
+	if err := r.SetTrustedProxies(server.TrustedProxies()); err != nil {
+		return fmt.Errorf("TRUSTED_PROXIES 无效: %w", err)
+	}
+
+	server.NewServer(svc).Register(r)
+
+	// 设置证书文件或 ACME 域名后直接提供 HTTPS，无需反向代理
+	tlsManager, err := certs.New(certs.FromEnv())
+	if err != nil {
+		return err
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "9000"
+	}
+
+	fmt.Println("Cloudreve Afdian Pay Server\t已启动\nGithub: https://github.com/essesoul/Cloudreve-AfdianPay")
+	fmt.Println("-------------------------")
+	fmt.Println("程序运行端口：" + port)
+	fmt.Printf("SITE_URL=%s\n", os.Getenv("SITE_URL"))
+	fmt.Printf("HTTPS: %s\n", tlsManager.Mode())
+
+	srv := &http.Server{Addr: ":" + port, Handler: r, TLSConfig: tlsManager.TLSConfig()}
+	errCh := make(chan error, 2)
+	go func() {
+		if srv.TLSConfig != nil {
+			errCh <- srv.ListenAndServeTLS("", "")
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+	var redirect *http.Server
+	// HTTP 跳转到 HTTPS，启用 ACME 时同时响应 http-01 验证
+	if redirectPort := os.Getenv("HTTP_REDIRECT_PORT"); redirectPort != "" && tlsManager != nil {
+		fmt.Println("HTTP 跳转端口：" + redirectPort)
+		redirect = &http.Server{Addr: ":" + redirectPort, Handler: tlsManager.RedirectHandler(port), ReadHeaderTimeout: 10 * time.Second}
+		go func() { errCh <- redirect.ListenAndServe() }()
+	} else if redirectPort != "" {
+		log.Printf("[TLS] HTTPS not enabled, ignoring HTTP_REDIRECT_PORT=%s", redirectPort)
+	}
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("服务启动失败: %w", err)
+	case <-ctx.Done():
+	}
+	fmt.Println("正在关闭服务...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if redirect != nil {
+		_ = redirect.Shutdown(shutdownCtx)
+	}
+	return srv.Shutdown(shutdownCtx)
+}
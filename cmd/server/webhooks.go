@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"cloudreve-afdianpay/internal/afdian"
+)
+
+func runWebhooksList(args []string) error {
+	fs := flag.NewFlagSet("webhooks list", flag.ExitOnError)
+	subscriber := fs.String("subscriber", "", "按订阅者过滤")
+	status := fs.String("status", "", "按状态过滤：pending/delivered/failed")
+	limit := fs.Int("limit", 50, "最多显示条数，0 表示不限")
+	_ = fs.Parse(args)
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	list, err := svc.ListWebhookDeliveries(*subscriber, *status, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSUBSCRIBER\tEVENT\tSTATUS\tATTEMPTS\tCREATED\tNEXT\tLAST_ERROR")
+	for _, d := range list {
+		next := "-"
+		if d.Status == afdian.DeliveryPending {
+			next = formatTime(d.NextAttemptAt)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", d.ID, d.Subscriber, d.EventType, d.Status, d.Attempts,
+			formatTime(d.CreatedAt), next, dash(d.LastError))
+	}
+	return w.Flush()
+}
+
+func runWebhooksRetry(args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法: webhooks retry <id...>")
+	}
+	svc, err := newService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	for _, a := range args {
+		id, err := strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return fmt.Errorf("无效的推送记录 ID %q", a)
+		}
+		if err := svc.RetryWebhook(id); err != nil {
+			return fmt.Errorf("%d: %w", id, err)
+		}
+		fmt.Printf("%d\t已重新排队，服务将在下次扫描时发送\n", id)
+	}
+	return nil
+}
@@ -0,0 +1,909 @@
+// Package acmemock 模拟 ACME（RFC 8555）证书颁发机构，与 Pebble 类似，用于离线测试自动证书。
+//
+// 支持 directory、newNonce、newAccount、newOrder、授权与 http-01/tls-alpn-01 挑战、
+// finalize 与证书下载，校验 ES256 JWS 签名、nonce 与 url；证书由进程内生成的根证书签发，
+// 根证书可通过 RootPEM 或 GET /roots/0 获取。
+package acmemock
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// 路径，均相对于服务地址
+const (
+	pathDirectory  = "/dir"
+	pathNonce      = "/acme/new-nonce"
+	pathAccount    = "/acme/new-account"
+	pathOrder      = "/acme/new-order"
+	pathAccountURL = "/acme/acct/"
+	pathOrderURL   = "/acme/order/"
+	pathFinalize   = "/acme/finalize/"
+	pathAuthz      = "/acme/authz/"
+	pathChallenge  = "/acme/chal/"
+	pathCert       = "/acme/cert/"
+	pathRoot       = "/roots/0"
+)
+
+// RFC 8555 状态
+const (
+	statusPending     = "pending"
+	statusProcessing  = "processing"
+	statusReady       = "ready"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+)
+
+// idPeACMEIdentifier tls-alpn-01 证书中的 acmeIdentifier 扩展（RFC 8737）
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// CA 模拟的证书颁发机构
+type CA struct {
+	// AlwaysValid 不连接申请方，挑战一律视为通过（同 Pebble 的 PEBBLE_VA_ALWAYS_VALID）
+	AlwaysValid bool
+	// HTTPAddr/TLSAddr 验证 http-01、tls-alpn-01 时连接的地址，所有域名都视为解析到这里；
+	// 为空时连接 域名:80 与 域名:443
+	HTTPAddr string
+	TLSAddr  string
+	// CertValidity 签发证书的有效期，默认 90 天
+	CertValidity time.Duration
+
+	root    *x509.Certificate
+	rootKey *ecdsa.PrivateKey
+
+	mu         sync.Mutex
+	seq        int
+	nonces     map[string]bool
+	accounts   map[string]*account
+	orders     map[string]*order
+	authzs     map[string]*authz
+	challenges map[string]*challenge
+	issued     []*x509.Certificate
+}
+
+type account struct {
+	id      string
+	key     *ecdsa.PublicKey
+	thumb   string
+	contact []string
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	id      string
+	account *account
+	status  string
+	expires time.Time
+	ids     []identifier
+	authzs  []*authz
+	chain   []byte
+}
+
+type authz struct {
+	id         string
+	ident      identifier
+	status     string
+	expires    time.Time
+	challenges []*challenge
+}
+
+type challenge struct {
+	id        string
+	typ       string
+	token     string
+	status    string
+	validated time.Time
+	authz     *authz
+	err       *problem
+}
+
+// New 生成根证书并创建 CA
+func New() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          randSerial(),
+		Subject:               pkix.Name{CommonName: "acmemock root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{
+		root:       root,
+		rootKey:    key,
+		nonces:     make(map[string]bool),
+		accounts:   make(map[string]*account),
+		orders:     make(map[string]*order),
+		authzs:     make(map[string]*authz),
+		challenges: make(map[string]*challenge),
+	}, nil
+}
+
+// Root 签发证书所用的根证书
+func (ca *CA) Root() *x509.Certificate {
+	return ca.root
+}
+
+// RootPEM PEM 格式的根证书，申请方的客户端需信任它才能验证签发的证书
+func (ca *CA) RootPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw})
+}
+
+// Issued 已签发的证书，按签发顺序
+func (ca *CA) Issued() []*x509.Certificate {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return append([]*x509.Certificate(nil), ca.issued...)
+}
+
+// Handler ACME 服务的 HTTP 入口，目录地址为 <服务地址>/dir
+func (ca *CA) Handler() http.Handler {
+	return http.HandlerFunc(ca.serveHTTP)
+}
+
+func (ca *CA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Replay-Nonce", ca.newNonce())
+	p := r.URL.Path
+	switch {
+	case p == pathDirectory && r.Method == http.MethodGet:
+		ca.directory(w, r)
+	case p == pathNonce && (r.Method == http.MethodHead || r.Method == http.MethodGet):
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case p == pathRoot && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.RootPEM())
+	case r.Method != http.MethodPost:
+		writeProblem(w, http.StatusMethodNotAllowed, "malformed", "不支持的请求方法")
+	case p == pathAccount:
+		ca.newAccount(w, r)
+	case p == pathOrder:
+		ca.newOrder(w, r)
+	case strings.HasPrefix(p, pathAccountURL):
+		ca.getAccount(w, r, strings.TrimPrefix(p, pathAccountURL))
+	case strings.HasPrefix(p, pathOrderURL):
+		ca.getOrder(w, r, strings.TrimPrefix(p, pathOrderURL))
+	case strings.HasPrefix(p, pathFinalize):
+		ca.finalize(w, r, strings.TrimPrefix(p, pathFinalize))
+	case strings.HasPrefix(p, pathAuthz):
+		ca.postAuthz(w, r, strings.TrimPrefix(p, pathAuthz))
+	case strings.HasPrefix(p, pathChallenge):
+		ca.postChallenge(w, r, strings.TrimPrefix(p, pathChallenge))
+	case strings.HasPrefix(p, pathCert):
+		ca.getCert(w, r, strings.TrimPrefix(p, pathCert))
+	default:
+		writeProblem(w, http.StatusNotFound, "malformed", "资源不存在")
+	}
+}
+
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+func (ca *CA) directory(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   base + pathNonce,
+		"newAccount": base + pathAccount,
+		"newOrder":   base + pathOrder,
+		"meta": map[string]any{
+			"termsOfService":          base + "/terms",
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (ca *CA) newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	n := base64.RawURLEncoding.EncodeToString(b)
+	ca.mu.Lock()
+	// 未使用的 nonce 过多时全部作废，客户端收到 badNonce 后会自动重试
+	if len(ca.nonces) > 10000 {
+		ca.nonces = make(map[string]bool)
+	}
+	ca.nonces[n] = true
+	ca.mu.Unlock()
+	return n
+}
+
+func (ca *CA) nextID() string {
+	ca.seq++
+	return strconv.Itoa(ca.seq)
+}
+
+// request 验签通过的 JWS 请求
+type request struct {
+	account *account
+	// key 仅 newAccount 请求使用 jwk，其余请求为账户公钥
+	key     *ecdsa.PublicKey
+	payload []byte
+}
+
+// verify 校验 JWS 签名、nonce 与 url；newAccount 请求使用 jwk，其余请求使用 kid 指定的账户
+func (ca *CA) verify(w http.ResponseWriter, r *http.Request, useJWK bool) (*request, bool) {
+	var body struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", "请求体不是 JWS")
+		return nil, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body.Protected)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", "protected 不是 base64url")
+		return nil, false
+	}
+	var hdr struct {
+		Alg   string          `json:"alg"`
+		Nonce string          `json:"nonce"`
+		URL   string          `json:"url"`
+		JWK   json.RawMessage `json:"jwk"`
+		KID   string          `json:"kid"`
+	}
+	if err := json.Unmarshal(raw, &hdr); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", "protected 不是 JSON")
+		return nil, false
+	}
+	if hdr.Alg != "ES256" {
+		writeProblem(w, http.StatusBadRequest, "badSignatureAlgorithm", "仅支持 ES256")
+		return nil, false
+	}
+	ca.mu.Lock()
+	fresh := ca.nonces[hdr.Nonce]
+	delete(ca.nonces, hdr.Nonce)
+	ca.mu.Unlock()
+	if !fresh {
+		writeProblem(w, http.StatusBadRequest, "badNonce", "nonce 无效或已使用")
+		return nil, false
+	}
+	if hdr.URL != baseURL(r)+r.URL.Path {
+		writeProblem(w, http.StatusUnauthorized, "unauthorized", "url 与请求地址不一致")
+		return nil, false
+	}
+
+	req := &request{}
+	switch {
+	case useJWK && len(hdr.JWK) > 0 && hdr.KID == "":
+		if req.key, err = parseJWK(hdr.JWK); err != nil {
+			writeProblem(w, http.StatusBadRequest, "badPublicKey", err.Error())
+			return nil, false
+		}
+	case !useJWK && len(hdr.JWK) == 0 && hdr.KID != "":
+		ca.mu.Lock()
+		req.account = ca.accounts[strings.TrimPrefix(hdr.KID, baseURL(r)+pathAccountURL)]
+		ca.mu.Unlock()
+		if req.account == nil {
+			writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "账户不存在")
+			return nil, false
+		}
+		req.key = req.account.key
+	default:
+		writeProblem(w, http.StatusBadRequest, "malformed", "jwk 与 kid 必须且只能有一个")
+		return nil, false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(body.Signature)
+	if err != nil || len(sig) != 64 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "签名格式错误")
+		return nil, false
+	}
+	digest := sha256.Sum256([]byte(body.Protected + "." + body.Payload))
+	if !ecdsa.Verify(req.key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		writeProblem(w, http.StatusUnauthorized, "unauthorized", "签名校验失败")
+		return nil, false
+	}
+	if req.payload, err = base64.RawURLEncoding.DecodeString(body.Payload); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", "payload 不是 base64url")
+		return nil, false
+	}
+	return req, true
+}
+
+// parseJWK 解析 P-256 EC 公钥
+func parseJWK(raw json.RawMessage) (*ecdsa.PublicKey, error) {
+	var k struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, errors.New("jwk 不是 JSON")
+	}
+	if k.Kty != "EC" || k.Crv != "P-256" {
+		return nil, errors.New("仅支持 P-256 EC 公钥")
+	}
+	x, errX := base64.RawURLEncoding.DecodeString(k.X)
+	y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+	if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("jwk 坐标格式错误")
+	}
+	// 借助 ecdh 校验点在曲线上
+	if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, errors.New("jwk 不是有效的 P-256 公钥")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func (ca *CA) newAccount(w http.ResponseWriter, r *http.Request) {
+	req, ok := ca.verify(w, r, true)
+	if !ok {
+		return
+	}
+	var p struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", "payload 不是 JSON")
+		return
+	}
+	thumb, err := acme.JWKThumbprint(req.key)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badPublicKey", err.Error())
+		return
+	}
+	ca.mu.Lock()
+	var acct *account
+	for _, a := range ca.accounts {
+		if a.thumb == thumb {
+			acct = a
+		}
+	}
+	status := http.StatusOK
+	if acct == nil && !p.OnlyReturnExisting {
+		acct = &account{id: ca.nextID(), key: req.key, thumb: thumb, contact: p.Contact}
+		ca.accounts[acct.id] = acct
+		status = http.StatusCreated
+	}
+	ca.mu.Unlock()
+	if acct == nil {
+		writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "账户不存在")
+		return
+	}
+	w.Header().Set("Location", baseURL(r)+pathAccountURL+acct.id)
+	writeJSON(w, status, accountJSON(acct))
+}
+
+func (ca *CA) getAccount(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := ca.verify(w, r, false)
+	if !ok {
+		return
+	}
+	if req.account.id != id {
+		writeProblem(w, http.StatusUnauthorized, "unauthorized", "无权访问该账户")
+		return
+	}
+	writeJSON(w, http.StatusOK, accountJSON(req.account))
+}
+
+func accountJSON(a *account) map[string]any {
+	return map[string]any{"status": statusValid, "contact": a.contact}
+}
+
+func (ca *CA) newOrder(w http.ResponseWriter, r *http.Request) {
+	req, ok := ca.verify(w, r, false)
+	if !ok {
+		return
+	}
+	var p struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil || len(p.Identifiers) == 0 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "identifiers 不能为空")
+		return
+	}
+	for i, id := range p.Identifiers {
+		if id.Type != "dns" || id.Value == "" || net.ParseIP(id.Value) != nil {
+			writeProblem(w, http.StatusBadRequest, "rejectedIdentifier", "仅支持 DNS 域名: "+id.Value)
+			return
+		}
+		p.Identifiers[i].Value = strings.ToLower(id.Value)
+	}
+	expires := time.Now().Add(time.Hour)
+	ca.mu.Lock()
+	o := &order{id: ca.nextID(), account: req.account, status: statusPending, expires: expires, ids: p.Identifiers}
+	for _, id := range p.Identifiers {
+		z := &authz{id: ca.nextID(), ident: id, status: statusPending, expires: expires}
+		for _, typ := range []string{"http-01", "tls-alpn-01"} {
+			ch := &challenge{id: ca.nextID(), typ: typ, token: newToken(), status: statusPending, authz: z}
+			z.challenges = append(z.challenges, ch)
+			ca.challenges[ch.id] = ch
+		}
+		o.authzs = append(o.authzs, z)
+		ca.authzs[z.id] = z
+	}
+	ca.orders[o.id] = o
+	body := ca.orderJSON(r, o)
+	ca.mu.Unlock()
+	w.Header().Set("Location", baseURL(r)+pathOrderURL+o.id)
+	writeJSON(w, http.StatusCreated, body)
+}
+
+// refresh 按授权状态更新订单状态，调用方需持有 ca.mu
+func (o *order) refresh() {
+	if o.status != statusPending {
+		return
+	}
+	ready := true
+	for _, z := range o.authzs {
+		switch z.status {
+		case statusValid:
+		case statusPending:
+			ready = false
+		default:
+			o.status = statusInvalid
+			return
+		}
+	}
+	if ready {
+		o.status = statusReady
+	}
+}
+
+// orderJSON 调用方需持有 ca.mu
+func (ca *CA) orderJSON(r *http.Request, o *order) map[string]any {
+	o.refresh()
+	base := baseURL(r)
+	authzs := make([]string, 0, len(o.authzs))
+	for _, z := range o.authzs {
+		authzs = append(authzs, base+pathAuthz+z.id)
+	}
+	m := map[string]any{
+		"status":         o.status,
+		"expires":        o.expires.UTC().Format(time.RFC3339),
+		"identifiers":    o.ids,
+		"authorizations": authzs,
+		"finalize":       base + pathFinalize + o.id,
+	}
+	if o.chain != nil {
+		m["certificate"] = base + pathCert + o.id
+	}
+	return m
+}
+
+// lookupOrder 取得属于该账户的订单，调用方需持有 ca.mu
+func (ca *CA) lookupOrder(w http.ResponseWriter, req *request, id string) *order {
+	o := ca.orders[id]
+	if o == nil || o.account != req.account {
+		writeProblem(w, http.StatusNotFound, "malformed", "订单不存在")
+		return nil
+	}
+	return o
+}
+
+func (ca *CA) getOrder(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := ca.verify(w, r, false)
+	if !ok {
+		return
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if o := ca.lookupOrder(w, req, id); o != nil {
+		w.Header().Set("Location", baseURL(r)+pathOrderURL+o.id)
+		writeJSON(w, http.StatusOK, ca.orderJSON(r, o))
+	}
+}
+
+func (ca *CA) postAuthz(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := ca.verify(w, r, false)
+	if !ok {
+		return
+	}
+	var p struct {
+		Status string `json:"status"`
+	}
+	if len(req.payload) > 0 {
+		if err := json.Unmarshal(req.payload, &p); err != nil {
+			writeProblem(w, http.StatusBadRequest, "malformed", "payload 不是 JSON")
+			return
+		}
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	z := ca.authzs[id]
+	if z == nil || !ca.ownsAuthz(req.account, z) {
+		writeProblem(w, http.StatusNotFound, "malformed", "授权不存在")
+		return
+	}
+	switch p.Status {
+	case "":
+	case statusDeactivated:
+		z.status = statusDeactivated
+	default:
+		writeProblem(w, http.StatusBadRequest, "malformed", "只能将授权设为 deactivated")
+		return
+	}
+	writeJSON(w, http.StatusOK, ca.authzJSON(r, z))
+}
+
+// ownsAuthz 调用方需持有 ca.mu
+func (ca *CA) ownsAuthz(a *account, z *authz) bool {
+	for _, o := range ca.orders {
+		if o.account != a {
+			continue
+		}
+		for _, oz := range o.authzs {
+			if oz == z {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ca *CA) authzJSON(r *http.Request, z *authz) map[string]any {
+	chals := make([]map[string]any, 0, len(z.challenges))
+	for _, ch := range z.challenges {
+		chals = append(chals, challengeJSON(r, ch))
+	}
+	return map[string]any{
+		"identifier": z.ident,
+		"status":     z.status,
+		"expires":    z.expires.UTC().Format(time.RFC3339),
+		"challenges": chals,
+	}
+}
+
+func challengeJSON(r *http.Request, ch *challenge) map[string]any {
+	m := map[string]any{
+		"type":   ch.typ,
+		"url":    baseURL(r) + pathChallenge + ch.id,
+		"token":  ch.token,
+		"status": ch.status,
+	}
+	if !ch.validated.IsZero() {
+		m["validated"] = ch.validated.UTC().Format(time.RFC3339)
+	}
+	if ch.err != nil {
+		m["error"] = ch.err
+	}
+	return m
+}
+
+// postChallenge 空 payload 为查询；"{}" 表示申请方已就绪，立即验证并返回结果
+func (ca *CA) postChallenge(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := ca.verify(w, r, false)
+	if !ok {
+		return
+	}
+	ca.mu.Lock()
+	ch := ca.challenges[id]
+	if ch == nil || !ca.ownsAuthz(req.account, ch.authz) {
+		ca.mu.Unlock()
+		writeProblem(w, http.StatusNotFound, "malformed", "挑战不存在")
+		return
+	}
+	start := len(req.payload) > 0 && ch.status == statusPending && ch.authz.status == statusPending
+	if start {
+		ch.status = statusProcessing
+	}
+	domain, token := ch.authz.ident.Value, ch.token
+	ca.mu.Unlock()
+
+	if start {
+		// 验证需要连接申请方，不持有锁
+		err := ca.validate(ch.typ, domain, token+"."+req.account.thumb)
+		ca.mu.Lock()
+		if err != nil {
+			log.Printf("[AcmeMock] %s %s invalid: %v", ch.typ, domain, err)
+			ch.status, ch.authz.status = statusInvalid, statusInvalid
+			ch.err = &problem{Type: "urn:ietf:params:acme:error:unauthorized", Detail: err.Error(), Status: http.StatusForbidden}
+		} else {
+			ch.status, ch.authz.status = statusValid, statusValid
+			ch.validated = time.Now()
+		}
+		ca.mu.Unlock()
+	}
+
+	ca.mu.Lock()
+	body := challengeJSON(r, ch)
+	ca.mu.Unlock()
+	w.Header().Set("Link", fmt.Sprintf("<%s>;rel=\"up\"", baseURL(r)+pathAuthz+ch.authz.id))
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (ca *CA) validate(typ, domain, keyAuth string) error {
+	if ca.AlwaysValid {
+		return nil
+	}
+	switch typ {
+	case "http-01":
+		addr := ca.HTTPAddr
+		if addr == "" {
+			addr = net.JoinHostPort(domain, "80")
+		}
+		return validateHTTP01(addr, domain, keyAuth)
+	case "tls-alpn-01":
+		addr := ca.TLSAddr
+		if addr == "" {
+			addr = net.JoinHostPort(domain, "443")
+		}
+		return validateTLSALPN01(addr, domain, keyAuth)
+	}
+	return fmt.Errorf("不支持的挑战类型 %s", typ)
+}
+
+func validateHTTP01(addr, domain, keyAuth string) error {
+	token, _, _ := strings.Cut(keyAuth, ".")
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/.well-known/acme-challenge/"+token, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = domain
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 4096))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http-01 响应状态 %d", res.StatusCode)
+	}
+	if got := strings.TrimSpace(string(b)); got != keyAuth {
+		return fmt.Errorf("http-01 key authorization 不匹配: %q", got)
+	}
+	return nil
+}
+
+func validateTLSALPN01(addr, domain, keyAuth string) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName: domain,
+		NextProtos: []string{acme.ALPNProto},
+		// 挑战证书为自签名，只检查 acmeIdentifier 扩展
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	cs := conn.ConnectionState()
+	if cs.NegotiatedProtocol != acme.ALPNProto {
+		return fmt.Errorf("tls-alpn-01 未协商 %s", acme.ALPNProto)
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls-alpn-01 没有证书")
+	}
+	leaf := cs.PeerCertificates[0]
+	if len(leaf.DNSNames) != 1 || !strings.EqualFold(leaf.DNSNames[0], domain) {
+		return fmt.Errorf("tls-alpn-01 证书域名 %v 不是 %s", leaf.DNSNames, domain)
+	}
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+		var got []byte
+		if _, err := asn1.Unmarshal(ext.Value, &got); err != nil {
+			return fmt.Errorf("tls-alpn-01 acmeIdentifier 格式错误: %w", err)
+		}
+		if !ext.Critical || !bytes.Equal(got, want[:]) {
+			return errors.New("tls-alpn-01 acmeIdentifier 不匹配")
+		}
+		return nil
+	}
+	return errors.New("tls-alpn-01 证书缺少 acmeIdentifier 扩展")
+}
+
+func (ca *CA) finalize(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := ca.verify(w, r, false)
+	if !ok {
+		return
+	}
+	var p struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", "payload 不是 JSON")
+		return
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	o := ca.lookupOrder(w, req, id)
+	if o == nil {
+		return
+	}
+	if o.refresh(); o.status != statusReady {
+		writeProblem(w, http.StatusForbidden, "orderNotReady", "订单状态为 "+o.status)
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(p.CSR)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", "csr 不是 base64url")
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	if err := matchNames(csr, o.ids); err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	leaf, err := ca.issue(csr)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	o.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}), ca.RootPEM()...)
+	o.status = statusValid
+	ca.issued = append(ca.issued, leaf)
+	w.Header().Set("Location", baseURL(r)+pathOrderURL+o.id)
+	writeJSON(w, http.StatusOK, ca.orderJSON(r, o))
+}
+
+// matchNames CSR 中的域名须与订单完全一致
+func matchNames(csr *x509.CertificateRequest, ids []identifier) error {
+	names := map[string]bool{}
+	for _, n := range csr.DNSNames {
+		names[strings.ToLower(n)] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		names[strings.ToLower(cn)] = true
+	}
+	want := map[string]bool{}
+	for _, id := range ids {
+		want[id.Value] = true
+	}
+	if len(names) != len(want) {
+		return fmt.Errorf("csr 域名 %v 与订单不一致", sortedKeys(names))
+	}
+	for n := range names {
+		if !want[n] {
+			return fmt.Errorf("csr 域名 %s 不在订单中", n)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (ca *CA) issue(csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	validity := ca.CertValidity
+	if validity <= 0 {
+		validity = 90 * 24 * time.Hour
+	}
+	now := time.Now()
+	names := csr.DNSNames
+	if len(names) == 0 {
+		names = []string{csr.Subject.CommonName}
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randSerial(),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.root, csr.PublicKey, ca.rootKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func (ca *CA) getCert(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := ca.verify(w, r, false)
+	if !ok {
+		return
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	o := ca.lookupOrder(w, req, id)
+	if o == nil {
+		return
+	}
+	if o.chain == nil {
+		writeProblem(w, http.StatusNotFound, "malformed", "证书尚未签发")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	_, _ = w.Write(o.chain)
+}
+
+// problem RFC 7807 错误
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func writeProblem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem{Type: "urn:ietf:params:acme:error:" + typ, Detail: detail, Status: status})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func randSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return n
+}
@@ -0,0 +1,141 @@
+package acmemock
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func newClient(t *testing.T) (*CA, *acme.Client) {
+	t.Helper()
+	ca, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AlwaysValid = true
+	srv := httptest.NewServer(ca.Handler())
+	t.Cleanup(srv.Close)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, &acme.Client{Key: key, DirectoryURL: srv.URL + "/dir"}
+}
+
+func csr(t *testing.T, names ...string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: names}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func problemType(err error) string {
+	var e *acme.Error
+	if errors.As(err, &e) {
+		return strings.TrimPrefix(e.ProblemType, "urn:ietf:params:acme:error:")
+	}
+	return ""
+}
+
+func TestIssue(t *testing.T) {
+	ca, client := newClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+	// 同一私钥再次注册返回已有账户
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != acme.ErrAccountAlreadyExists {
+		t.Fatalf("second Register = %v", err)
+	}
+
+	o, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.test", "b.example.test"))
+	if err != nil || o.Status != "pending" || len(o.AuthzURLs) != 2 {
+		t.Fatalf("AuthorizeOrder = %+v, %v", o, err)
+	}
+	if _, _, err := client.CreateOrderCert(ctx, o.FinalizeURL, csr(t, "a.example.test", "b.example.test"), true); problemType(err) != "orderNotReady" {
+		t.Fatalf("finalize before authz = %v", err)
+	}
+	for _, u := range o.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(z.Challenges) != 2 {
+			t.Fatalf("challenges = %d", len(z.Challenges))
+		}
+		if _, err := client.Accept(ctx, z.Challenges[0]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.WaitAuthorization(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if o, err = client.WaitOrder(ctx, o.URI); err != nil || o.Status != "ready" {
+		t.Fatalf("WaitOrder = %+v, %v", o, err)
+	}
+
+	if _, _, err := client.CreateOrderCert(ctx, o.FinalizeURL, csr(t, "a.example.test", "c.example.test"), true); problemType(err) != "badCSR" {
+		t.Fatalf("finalize with extra name = %v", err)
+	}
+	chain, _, err := client.CreateOrderCert(ctx, o.FinalizeURL, csr(t, "a.example.test", "b.example.test"), true)
+	if err != nil || len(chain) != 2 {
+		t.Fatalf("CreateOrderCert = %d certs, %v", len(chain), err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Root())
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "b.example.test", Roots: roots}); err != nil {
+		t.Fatalf("leaf does not verify: %v", err)
+	}
+	if len(ca.Issued()) != 1 {
+		t.Fatalf("Issued = %d", len(ca.Issued()))
+	}
+}
+
+func TestRejectRequests(t *testing.T) {
+	_, client := newClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// 未注册的账户
+	if _, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.test")); err == nil {
+		t.Fatal("order without account succeeded")
+	}
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AuthorizeOrder(ctx, acme.IPIDs("127.0.0.1")); problemType(err) != "rejectedIdentifier" {
+		t.Fatalf("IP identifier = %v", err)
+	}
+
+	// 未签名的请求
+	res, err := http.Post(strings.TrimSuffix(client.DirectoryURL, "/dir")+"/acme/new-order", "application/jose+json", strings.NewReader(`{"protected":"e30","payload":"","signature":""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest || res.Header.Get("Replay-Nonce") == "" {
+		t.Fatalf("unsigned request = %d", res.StatusCode)
+	}
+}
@@ -1,169 +1,483 @@
 package afdian
 
 import (
-	"crypto/md5"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"cloudreve-afdianpay/internal/alert"
+	"cloudreve-afdianpay/internal/money"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Service struct {
 	DBPath string
+	// DSN 非空时使用 PostgreSQL/MySQL（见 parseDSN），优先于 DBPath
+	DSN string
+	// Plans Cloudreve 商品到爱发电方案/SKU 的映射，为空时一律使用自定义金额下单
+	Plans []Plan
+	// Instance 实例标识，作为租约与通知认领的持有者
+	Instance string
+	// Leader 返回当前实例是否应运行单例任务（过期清理、对账），为 nil 时总是运行
+	Leader func() bool
+	// Limits 下单金额限制，为 nil 时使用 DefaultLimits
+	Limits Limits
+	// Subscribers 订单与付款事件的外部订阅者，见 webhook.go
+	Subscribers []Subscriber
+	// Alerts 运维告警，为 nil 时不告警
+	Alerts *alert.Manager
+
+	mu sync.Mutex
+	d  *dialect
+	// wdb 写连接（SQLite 单写者），rdb 只读连接池；PostgreSQL/MySQL 两者为同一连接池
+	wdb, rdb *sql.DB
+	stmts    map[stmtKey]*sql.Stmt
+	// closed Close 之后为 true，EnsureDB 不再打开数据库
+	closed bool
+	// webhookKick 有新事件时唤醒 RunWebhooks；webhookBusy 为正在投递的订阅者（受 s.mu 保护），
+	// webhookSem 限制同时投递的订阅者数
+	webhookKick chan struct{}
+	webhookBusy map[string]bool
+	webhookSem  chan struct{}
+	// watchers 订单状态订阅者，见 watch.go
+	watchMu  sync.Mutex
+	watchers map[string]map[chan OrderUpdate]struct{}
+}
+
+// 订单状态
+const (
+	StatusPending  = "pending"
+	StatusPaid     = "paid"
+	StatusExpired  = "expired"
+	StatusRefunded = "refunded"
+)
+
+var (
+	// ErrOrderExpired 订单已过期，不能再标记为已支付
+	ErrOrderExpired = errors.New("订单已过期")
+	// ErrOrderConflict 同一站点下订单号已存在且金额不一致
+	ErrOrderConflict = errors.New("订单号已存在且金额不一致")
+	// ErrOrderNotFound 订单不存在
+	ErrOrderNotFound = errors.New("订单不存在")
+)
+
+// Order 本地保存的订单
+type Order struct {
+	Site    string
+	OrderNo string
+	// Amount 换算后的 CNY 金额，Original 为 Cloudreve 下单时的原始币种金额
+	Amount   money.Amount
+	Original money.Amount
+	// Refunded 已登记的退款总额（CNY），达到 Amount 时订单转为已退款
+	Refunded  money.Amount
+	NotifyURL string
+	Status    string
+	CreatedAt time.Time
+	// PlanID/SkuID 为空表示自定义金额订单
+	PlanID   string
+	SkuID    string
+	SkuCount int
+	// PaidAt/NotifiedAt 为零值表示尚未支付/尚未成功通知
+	PaidAt     time.Time
+	NotifiedAt time.Time
+	// 以下为爱发电侧支付信息，支付后写入
+	OutTradeNo    string
+	SponsorUserID string
+	SponsorName   string
+	PaidPlanID    string
+	// AfdianPaidAt 爱发电订单的原始创建时间
+	AfdianPaidAt time.Time
+}
+
+// Payment 爱发电侧的支付信息，来自 query-order 接口
+type Payment struct {
+	OutTradeNo    string
+	Remark        string
+	Amount        money.Amount
+	SponsorUserID string
+	SponsorName   string
+	PlanID        string
+	PaidTime      time.Time
+}
+
+// OrderFilter 订单列表查询条件，零值字段不参与过滤
+type OrderFilter struct {
+	Site   string
+	Status string
+	Since  time.Time
+	Until  time.Time
+	// ByPaidAt 为 true 时 Since/Until 按支付时间过滤，否则按创建时间
+	ByPaidAt bool
+	// Unnotified 仅返回已支付但尚未成功通知 Cloudreve 的订单
+	Unnotified bool
+	// Query 按订单号、爱发电订单号、赞助者 ID 精确匹配或赞助者名称模糊匹配
+	Query string
+	Limit int
 }
 
 func NewService(dbPath string) *Service {
-	return &Service{DBPath: dbPath}
+	return &Service{DBPath: dbPath, Instance: defaultInstanceID()}
 }
 
-func (s *Service) open() (*sql.DB, error) {
-	abs, _ := filepath.Abs(s.DBPath)
-	// 使用 WAL 与 busy_timeout，减少并发访问时的锁冲突
-	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&cache=shared", abs)
-	log.Printf("[DB] open dsn=%s", dsn)
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
+// siteURL 当前站点，订单号在站点内唯一
+func siteURL() string {
+	return strings.TrimRight(os.Getenv("SITE_URL"), "/")
+}
+
+const orderColumns = "site, order_no, amount_minor, currency, orig_amount_minor, orig_currency, notify_url, status, created_at, plan_id, sku_id, sku_count, paid_at, notified_at, out_trade_no, sponsor_user_id, sponsor_name, paid_plan_id, afdian_paid_at, refunded_minor"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrder(row rowScanner) (*Order, error) {
+	var o Order
+	var currency, origCurrency string
+	var createdAt int64
+	var paidAt, notifiedAt, afdianPaidAt sql.NullInt64
+	if err := row.Scan(&o.Site, &o.OrderNo, &o.Amount.Minor, &currency, &o.Original.Minor, &origCurrency, &o.NotifyURL, &o.Status, &createdAt,
+		&o.PlanID, &o.SkuID, &o.SkuCount, &paidAt, &notifiedAt, &o.OutTradeNo, &o.SponsorUserID, &o.SponsorName, &o.PaidPlanID, &afdianPaidAt, &o.Refunded.Minor); err != nil {
 		return nil, err
 	}
-	// SQLite 推荐单连接，避免数据库锁
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
-	db.SetConnMaxLifetime(5 * time.Minute)
-	return db, nil
+	o.AfdianPaidAt = unixOrZero(afdianPaidAt)
+	o.Amount.Currency = money.Currency(currency)
+	o.Original.Currency = money.Currency(origCurrency)
+	o.Refunded.Currency = o.Amount.Currency
+	o.CreatedAt = time.Unix(createdAt, 0)
+	o.PaidAt = unixOrZero(paidAt)
+	o.NotifiedAt = unixOrZero(notifiedAt)
+	return &o, nil
 }
 
-func ensureTableExists(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS afdian_pay (
-		order_no TEXT,
-		amount TEXT,
-		notify_url TEXT,
-		is_paid BOOLEAN DEFAULT 0
-	)`)
-	if err != nil {
-		log.Printf("[DB] ensure table error: %v", err)
+func unixOrZero(v sql.NullInt64) time.Time {
+	if !v.Valid || v.Int64 == 0 {
+		return time.Time{}
 	}
-	return err
+	return time.Unix(v.Int64, 0)
+}
+
+// insertOrderQuery 写入新订单，同一站点下订单号已存在时不写入
+const insertOrderQuery = `INSERT INTO afdian_pay (site, order_no, amount, amount_minor, currency, orig_amount_minor, orig_currency, notify_url, is_paid, status, created_at, plan_id, sku_id, sku_count)
+		VALUES (?,?,?,?,?,?,?,?,0,?,?,?,?,?)`
+
+// insertArgs insertOrderQuery 的参数；amount 保留十进制文本以兼容旧数据，比较一律使用 amount_minor
+func (o *Order) insertArgs() []interface{} {
+	return []interface{}{o.Site, o.OrderNo, o.Amount.String(), o.Amount.Minor, string(o.Amount.Currency), o.Original.Minor, string(o.Original.Currency),
+		o.NotifyURL, o.Status, o.CreatedAt.Unix(), o.PlanID, o.SkuID, o.SkuCount}
 }
 
-func (s *Service) EnsureDB() error {
-	db, err := s.open()
+// dbInsert 写入新订单；同一站点下订单号已存在时不写入并返回 false
+func (s *Service) dbInsert(o *Order) (bool, error) {
+	if err := s.EnsureDB(); err != nil {
+		return false, err
+	}
+	res, err := s.exec(s.d.insertIgnore(insertOrderQuery, "site, order_no"), o.insertArgs()...)
 	if err != nil {
-		return err
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetOrder 按订单号查询当前站点的订单，不存在时返回 nil
+func (s *Service) GetOrder(orderNo string) (*Order, error) {
+	o, err := scanOrder(s.queryRow("SELECT "+orderColumns+" FROM afdian_pay WHERE site = ? AND order_no = ?", siteURL(), orderNo))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
 	}
-	defer db.Close()
-	return ensureTableExists(db)
+	return o, err
+}
+
+// ListOrders 按条件查询订单，按创建时间倒序
+func (s *Service) ListOrders(f OrderFilter) ([]Order, error) {
+	var list []Order
+	err := s.EachOrder(f, func(o *Order) error {
+		list = append(list, *o)
+		return nil
+	})
+	return list, err
 }
 
-func (s *Service) dbInsert(orderNo string, amountFen int64, notifyURL string) error {
-	db, err := s.open()
+// EachOrder 逐行遍历符合条件的订单，用于导出等大结果集；fn 返回错误时停止
+func (s *Service) EachOrder(f OrderFilter, fn func(o *Order) error) error {
+	timeColumn := "created_at"
+	if f.ByPaidAt {
+		timeColumn = "paid_at"
+	}
+	query := "SELECT " + orderColumns + " FROM afdian_pay WHERE 1=1"
+	var args []interface{}
+	if f.Site != "" {
+		query += " AND site = ?"
+		args = append(args, f.Site)
+	}
+	if f.Status != "" {
+		query += " AND status = ?"
+		args = append(args, f.Status)
+	}
+	if !f.Since.IsZero() {
+		query += " AND " + timeColumn + " >= ?"
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		query += " AND " + timeColumn + " < ?"
+		args = append(args, f.Until.Unix())
+	}
+	if f.Unnotified {
+		query += " AND status = ? AND notified_at IS NULL"
+		args = append(args, StatusPaid)
+	}
+	if f.Query != "" {
+		query += " AND (order_no = ? OR out_trade_no = ? OR sponsor_user_id = ? OR sponsor_name LIKE ?)"
+		args = append(args, f.Query, f.Query, f.Query, "%"+f.Query+"%")
+	}
+	query += " ORDER BY " + timeColumn + " DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+	rows, err := s.query(query, args...)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	if err := ensureTableExists(db); err != nil {
-		return err
+	defer rows.Close()
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(o); err != nil {
+			return err
+		}
 	}
-	amountStr := fmt.Sprintf("%.2f", float64(amountFen)/100.0)
-	_, err = db.Exec("INSERT INTO afdian_pay (order_no, amount, notify_url, is_paid) VALUES (?,?,?,0)", orderNo, amountStr, notifyURL)
-	return err
+	return rows.Err()
 }
 
-// NewOrder 生成爱发电下单 URL，并写入本地 DB
-func (s *Service) NewOrder(orderInfoJSON string, amountFen int64) (string, error) {
+// NewOrder 生成爱发电下单 URL，并写入本地 DB。
+// 商品名称或金额命中 Plans 时生成方案/SKU 下单链接，否则使用自定义金额。
+func (s *Service) NewOrder(orderInfoJSON string, amount money.Amount) (string, error) {
 	userID := os.Getenv("USER_ID")
 	if userID == "" {
 		return "", errors.New("USER_ID 未设置")
 	}
 	var oi struct {
 		OrderNo   string `json:"order_no"`
+		Name      string `json:"name"`
 		NotifyURL string `json:"notify_url"`
+		// 换算前的原始金额，缺省时与 amount 相同
+		OriginalAmount   int64  `json:"original_amount"`
+		OriginalCurrency string `json:"original_currency"`
 	}
 	if err := json.Unmarshal([]byte(orderInfoJSON), &oi); err != nil {
 		return "", err
 	}
-	orderURL := fmt.Sprintf("https://afdian.com/order/create?user_id=%s&remark=%s&custom_price=%s", userID, url.QueryEscape(oi.OrderNo), fmt.Sprintf("%.2f", float64(amountFen)/100.0))
-	if err := s.dbInsert(oi.OrderNo, amountFen, oi.NotifyURL); err != nil {
+	if amount.Currency != money.CNY {
+		return "", money.ErrCurrencyMismatch
+	}
+	o := &Order{
+		Site:      siteURL(),
+		OrderNo:   oi.OrderNo,
+		Amount:    amount,
+		Original:  amount,
+		NotifyURL: oi.NotifyURL,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+	}
+	if oi.OriginalCurrency != "" {
+		o.Original = money.New(oi.OriginalAmount, money.Currency(oi.OriginalCurrency))
+	}
+	if p := matchPlan(s.Plans, oi.Name, amount); p != nil {
+		o.PlanID, o.SkuID, o.SkuCount = p.PlanID, p.SkuID, p.count()
+		log.Printf("[NewOrder] order_no=%s name=%q matched plan_id=%s sku_id=%s", o.OrderNo, oi.Name, o.PlanID, o.SkuID)
+	}
+	var inserted bool
+	var err error
+	if daily := s.limits().dailyCap(o.Site); daily != nil {
+		inserted, err = s.insertWithinDailyCap(o, daily)
+	} else {
+		inserted, err = s.dbInsert(o)
+	}
+	if err != nil {
 		return "", err
 	}
-	return orderURL, nil
+	if !inserted {
+		// Cloudreve 重试下单：金额一致时返回同一链接
+		existing, err := s.GetOrder(oi.OrderNo)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return "", errors.New("订单写入冲突但未找到已有订单")
+		}
+		if !existing.Amount.Equal(amount) {
+			log.Printf("[NewOrder] duplicate order_no=%s with different amount: existing=%s new=%s", oi.OrderNo, existing.Amount, amount)
+			return "", ErrOrderConflict
+		}
+		if existing.Status == StatusExpired {
+			return "", ErrOrderExpired
+		}
+		log.Printf("[NewOrder] duplicate order_no=%s, reuse existing", oi.OrderNo)
+		o = existing
+	} else {
+		s.emitOrder(EventOrderCreated, o.OrderNo, "")
+	}
+	return checkoutURL(userID, o), nil
+}
+
+// CheckResult CheckOrder 的结果
+type CheckResult struct {
+	// Order 本地订单，未找到时为 nil
+	Order *Order
+	// Payment 爱发电侧查到的支付信息，未找到时为 nil
+	Payment *Payment
+	Matched bool
+	// Match 定位本地订单的方式（MatchRemark/MatchLegacy/MatchRecovered）
+	Match string
+	// ReviewReason 爱发电侧已付款但无法自动入账（找不到订单、金额或方案不符）时的复核原因
+	ReviewReason string
+	// APIResponse query-order 原始响应，供回调日志留存
+	APIResponse []byte
 }
 
-// CheckOrder 先通过 API 主动验证，再查本地订单
-func (s *Service) CheckOrder(orderNo, outTradeNo string) (string, string, string, bool, error) {
-	apiOrderNo, apiTotalAmount, ok, err := s.apiCheck(outTradeNo)
-	if err != nil || !ok || apiOrderNo == "" || apiTotalAmount == 0 {
+// CheckOrder 先通过 API 主动验证，再按爱发电返回的留言定位本地订单（见 resolveOrder）；
+// 爱发电订单不是交易成功状态、或 API 金额与本地金额不一致视为不匹配。爱发电侧查到订单时返回其支付信息，匹配成功时补充赞助者名称。
+func (s *Service) CheckOrder(outTradeNo string) (*CheckResult, error) {
+	res := &CheckResult{}
+	it, apiTotal, raw, err := s.apiCheck(outTradeNo)
+	res.APIResponse = raw
+	if err != nil || it == nil || apiTotal.Minor == 0 {
 		if err != nil {
 			log.Printf("[CheckOrder] apiCheck error: %v", err)
+		} else if it != nil {
+			log.Printf("[CheckOrder] apiCheck not ok: remark=%q total=%s", it.Remark, apiTotal)
 		} else {
-			log.Printf("[CheckOrder] apiCheck not ok: ok=%v orderNo=%q total=%d", ok, apiOrderNo, apiTotalAmount)
+			log.Printf("[CheckOrder] apiCheck not ok: order not found")
 		}
-		return "", "", "", false, err
+		return res, err
+	}
+	p := &Payment{
+		OutTradeNo:    it.OutTradeNo,
+		Remark:        it.Remark,
+		Amount:        apiTotal,
+		SponsorUserID: it.UserID,
+		PlanID:        it.PlanID,
+	}
+	if it.CreateTime > 0 {
+		p.PaidTime = time.Unix(it.CreateTime, 0)
 	}
+	res.Payment = p
 
-	db, err := s.open()
+	o, match, err := s.resolveOrder(it.Remark, p)
+	if errors.Is(err, errAmbiguousPayment) {
+		res.ReviewReason = ReviewRemarkAmbiguous
+		return res, nil
+	}
 	if err != nil {
-		log.Printf("[DB] open error: %v", err)
-		return "", "", "", false, err
+		log.Printf("[CheckOrder] query error: %v", err)
+		return res, err
 	}
-	defer db.Close()
-	if err := ensureTableExists(db); err != nil {
-		return "", "", "", false, err
+	if it.Status != afdianStatusPaid {
+		// 未完成或已退款的爱发电订单不入账，否则对账时又会被当作退款
+		log.Printf("[CheckOrder] afdian order not paid: out_trade_no=%s status=%d", it.OutTradeNo, it.Status)
+		res.Order, res.Match = o, match
+		res.ReviewReason = ReviewNotPaid
+		return res, nil
 	}
-	row := db.QueryRow("SELECT order_no, amount, notify_url FROM afdian_pay WHERE order_no = ?", orderNo)
-	var ono, amountStr, notify string
-	if err := row.Scan(&ono, &amountStr, &notify); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("[CheckOrder] no local order: %s", orderNo)
-			return "", "", "", false, nil
-		}
-		log.Printf("[CheckOrder] scan error: %v", err)
-		return "", "", "", false, err
+	if o == nil {
+		log.Printf("[CheckOrder] no local order for remark %q", it.Remark)
+		res.ReviewReason = ReviewRemarkUnmatched
+		return res, nil
+	}
+	res.Order, res.Match = o, match
+	if !o.Amount.Equal(apiTotal) {
+		log.Printf("[CheckOrder] api amount mismatch: order_no=%s local=%s api=%s", o.OrderNo, o.Amount, apiTotal)
+		res.ReviewReason = ReviewAmountMismatch
+		return res, nil
+	}
+	if !matchesPlan(o, it) {
+		log.Printf("[CheckOrder] plan mismatch: order_no=%s plan=%s sku=%s api_plan=%s api_sku=%v", o.OrderNo, o.PlanID, o.SkuID, it.PlanID, it.SkuDetail)
+		res.ReviewReason = ReviewPlanMismatch
+		return res, nil
+	}
+	log.Printf("[CheckOrder] local order matched: order_no=%s match=%s amount=%s notify=%s", o.OrderNo, match, o.Amount, o.NotifyURL)
+	if name, err := querySponsorName(it.UserID); err != nil {
+		log.Printf("[CheckOrder] query sponsor %s error: %v", it.UserID, err)
+	} else {
+		p.SponsorName = name
 	}
-	log.Printf("[CheckOrder] local order matched: order_no=%s amount=%s notify=%s", ono, amountStr, notify)
-	return ono, amountStr, notify, true, nil
+	res.Matched = true
+	return res, nil
 }
 
-func (s *Service) MarkOrderPaid(orderNo string) error {
-	db, err := s.open()
+// MarkOrderPaid 将订单标记为已支付并记录爱发电支付信息（人工标记时 p 为 nil）；
+// 订单不存在返回 ErrOrderNotFound，已过期的订单返回 ErrOrderExpired，已退款的订单返回 ErrAlreadyRefunded
+func (s *Service) MarkOrderPaid(orderNo string, p *Payment) error {
+	return s.markPaid(orderNo, p, StatusPending)
+}
+
+// markPaid 将状态为 from 的订单标记为已支付；已支付的订单只补充缺失的支付信息
+func (s *Service) markPaid(orderNo string, p *Payment, from string) error {
+	if p == nil {
+		p = &Payment{}
+	}
+	var afdianPaidAt interface{}
+	if !p.PaidTime.IsZero() {
+		afdianPaidAt = p.PaidTime.Unix()
+	}
+	// 重复回调不覆盖已有的支付信息
+	args := []interface{}{StatusPaid, time.Now().Unix(), p.OutTradeNo, p.SponsorUserID, p.SponsorName, p.PlanID, afdianPaidAt, siteURL(), orderNo}
+	res, err := s.exec(markPaidQuery, append(args, from)...)
 	if err != nil {
-		log.Printf("[DB] open error: %v", err)
+		log.Printf("[DB] mark paid error: %v", err)
 		return err
 	}
-	defer db.Close()
-	if err := ensureTableExists(db); err != nil {
-		return err
+	if n, _ := res.RowsAffected(); n > 0 {
+		if from != StatusPaid {
+			s.publishOrder(orderNo)
+			s.emitOrder(EventOrderPaid, orderNo, "")
+		}
+		return nil
 	}
-	_, err = db.Exec("UPDATE afdian_pay SET is_paid = 1 WHERE order_no = ?", orderNo)
-	if err != nil {
+	// 重复回调：补全缺失的支付信息；MySQL 只统计实际变化的行，信息已完整时为 0
+	if _, err := s.exec(markPaidQuery, append(args, StatusPaid)...); err != nil {
 		log.Printf("[DB] mark paid error: %v", err)
+		return err
+	}
+	var status string
+	_ = s.queryRow("SELECT status FROM afdian_pay WHERE site = ? AND order_no = ?", siteURL(), orderNo).Scan(&status)
+	if status == StatusPaid {
+		return nil
+	}
+	log.Printf("[DB] mark paid skipped: order_no=%s status=%q", orderNo, status)
+	switch status {
+	case "":
+		return ErrOrderNotFound
+	case StatusRefunded:
+		return ErrAlreadyRefunded
+	case StatusExpired:
+		return ErrOrderExpired
 	}
-	return err
+	return fmt.Errorf("订单状态为 %s，不能标记为已支付", status)
 }
 
+const markPaidQuery = `UPDATE afdian_pay SET is_paid = 1, status = ?, paid_at = COALESCE(paid_at, ?),
+	out_trade_no = CASE WHEN out_trade_no = '' THEN ? ELSE out_trade_no END,
+	sponsor_user_id = CASE WHEN sponsor_user_id = '' THEN ? ELSE sponsor_user_id END,
+	sponsor_name = CASE WHEN sponsor_name = '' THEN ? ELSE sponsor_name END,
+	paid_plan_id = CASE WHEN paid_plan_id = '' THEN ? ELSE paid_plan_id END,
+	afdian_paid_at = COALESCE(afdian_paid_at, ?)
+	WHERE site = ? AND order_no = ? AND status = ?`
+
+// GetOrderStatus 订单是否处于已支付状态；以 status 列为准，退款后不再返回已支付
 func (s *Service) GetOrderStatus(orderNo string) (bool, error) {
-	db, err := s.open()
-	if err != nil {
-		log.Printf("[DB] open error: %v", err)
-		return false, err
-	}
-	defer db.Close()
-	if err := ensureTableExists(db); err != nil {
-		return false, err
-	}
-	row := db.QueryRow("SELECT is_paid FROM afdian_pay WHERE order_no = ?", orderNo)
-	var paidRaw interface{}
-	if err := row.Scan(&paidRaw); err != nil {
+	var status string
+	if err := s.queryRow("SELECT status FROM afdian_pay WHERE site = ? AND order_no = ?", siteURL(), orderNo).Scan(&status); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			log.Printf("[GetOrderStatus] no such order: %s", orderNo)
 			return false, nil
@@ -171,93 +485,29 @@ func (s *Service) GetOrderStatus(orderNo string) (bool, error) {
 		log.Printf("[GetOrderStatus] scan error: %v", err)
 		return false, err
 	}
-	paid := false
-	switch v := paidRaw.(type) {
-	case int64:
-		paid = v != 0
-	case bool:
-		paid = v
-	case string:
-		lv := strings.ToLower(strings.TrimSpace(v))
-		if lv == "true" || lv == "1" {
-			paid = true
-		}
-	case []byte:
-		lv := strings.ToLower(strings.TrimSpace(string(v)))
-		if lv == "true" || lv == "1" {
-			paid = true
-		}
-	default:
-		// 尝试格式化解析
-		s := fmt.Sprintf("%v", v)
-		if n, err := strconv.Atoi(s); err == nil {
-			paid = n != 0
-		} else if strings.EqualFold(s, "true") {
-			paid = true
-		}
-	}
-	log.Printf("[GetOrderStatus] order=%s paid=%v (raw=%T:%v)", orderNo, paid, paidRaw, paidRaw)
-	return paid, nil
+	log.Printf("[GetOrderStatus] order=%s status=%s", orderNo, status)
+	return status == StatusPaid, nil
 }
 
-// apiCheck 调用爱发电 API 查询订单
-func (s *Service) apiCheck(outTradeNo string) (string, int, bool, error) {
-	urlStr := "https://afdian.com/api/open/query-order"
-	userID := os.Getenv("USER_ID")
-	token := os.Getenv("TOKEN")
-	if userID == "" || token == "" {
-		return "", 0, false, errors.New("USER_ID/TOKEN 未设置")
-	}
-	ts := fmt.Sprintf("%d", time.Now().Unix())
-	params := fmt.Sprintf("{\"out_trade_no\":\"%s\"}", outTradeNo)
-	signData := token + "params" + params + "ts" + ts + "user_id" + userID
-	h := md5.Sum([]byte(signData))
-	sign := hex.EncodeToString(h[:])
-
-	form := url.Values{}
-	form.Set("user_id", userID)
-	form.Set("params", params)
-	form.Set("ts", ts)
-	form.Set("sign", sign)
-	log.Printf("[apiCheck] request out_trade_no=%s ts=%s", outTradeNo, ts)
-
-	resp, err := http.PostForm(urlStr, form)
+// apiCheck 调用爱发电 API 查询订单，未查到时返回 nil；同时返回原始响应
+func (s *Service) apiCheck(outTradeNo string) (*apiOrder, money.Amount, []byte, error) {
+	log.Printf("[apiCheck] request out_trade_no=%s", outTradeNo)
+	result, raw, err := queryOrders(map[string]interface{}{"out_trade_no": outTradeNo})
 	if err != nil {
-		log.Printf("[apiCheck] http error: %v", err)
-		return "", 0, false, err
-	}
-	defer resp.Body.Close()
-	var payload struct {
-		Data struct {
-			TotalCount int `json:"total_count"`
-			List       []struct {
-				TotalAmount interface{} `json:"total_amount"`
-				Remark      string      `json:"remark"`
-			} `json:"list"`
-		} `json:"data"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		log.Printf("[apiCheck] decode error: %v", err)
-		return "", 0, false, err
-	}
-	log.Printf("[apiCheck] total_count=%d list_len=%d", payload.Data.TotalCount, len(payload.Data.List))
-	if payload.Data.TotalCount == 0 || len(payload.Data.List) == 0 {
-		return "", 0, false, nil
-	}
-	it := payload.Data.List[0]
-	// Python: int(str(total_amount).split(".")[0])
-	totalStr := fmt.Sprintf("%v", it.TotalAmount)
-	if idx := strings.IndexByte(totalStr, '.'); idx >= 0 {
-		totalStr = totalStr[:idx]
-	}
-	var totalInt int
-	for i := 0; i < len(totalStr); i++ {
-		c := totalStr[i]
-		if c < '0' || c > '9' {
-			break
-		}
-		totalInt = totalInt*10 + int(c-'0')
+		log.Printf("[apiCheck] query error: %v", err)
+		s.Alerts.Record(alert.KindAPIError, fmt.Sprintf("query-order out_trade_no=%s: %v", outTradeNo, err))
+		return nil, money.Amount{}, raw, err
+	}
+	log.Printf("[apiCheck] total_count=%d list_len=%d", result.TotalCount, len(result.List))
+	if result.TotalCount == 0 || len(result.List) == 0 {
+		return nil, money.Amount{}, raw, nil
+	}
+	it := &result.List[0]
+	total, err := money.ParseAny(it.TotalAmount, money.CNY)
+	if err != nil {
+		log.Printf("[apiCheck] invalid total_amount %v: %v", it.TotalAmount, err)
+		return nil, money.Amount{}, raw, err
 	}
-	log.Printf("[apiCheck] remark=%s total=%d", it.Remark, totalInt)
-	return it.Remark, totalInt, true, nil
+	log.Printf("[apiCheck] remark=%s total=%s", it.Remark, total)
+	return it, total, raw, nil
 }
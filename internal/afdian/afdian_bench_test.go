@@ -0,0 +1,59 @@
+package afdian
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloudreve-afdianpay/internal/money"
+)
+
+func newBenchService(b *testing.B) *Service {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	os.Setenv("SITE_URL", "https://bench.example.com")
+	os.Setenv("USER_ID", "bench")
+	svc := NewService(filepath.Join(b.TempDir(), "bench.db"))
+	if err := svc.EnsureDB(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { svc.Close() })
+	return svc
+}
+
+func BenchmarkNewOrder(b *testing.B) {
+	svc := newBenchService(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		info := fmt.Sprintf(`{"order_no":"bench-%d","notify_url":"http://localhost/notify"}`, i)
+		if _, err := svc.NewOrder(info, money.New(500, money.CNY)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetOrderStatusParallel(b *testing.B) {
+	svc := newBenchService(b)
+	const seeded = 1000
+	for i := 0; i < seeded; i++ {
+		info := fmt.Sprintf(`{"order_no":"bench-%d","notify_url":"http://localhost/notify"}`, i)
+		if _, err := svc.NewOrder(info, money.New(500, money.CNY)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := svc.GetOrderStatus(fmt.Sprintf("bench-%d", i%seeded)); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package afdian
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// afdianBase 爱发电站点地址，可通过 AFDIAN_BASE_URL 指向本地模拟服务（cmd/afdianmock）
+func afdianBase() string {
+	if v := strings.TrimRight(os.Getenv("AFDIAN_BASE_URL"), "/"); v != "" {
+		return v
+	}
+	return "https://afdian.com"
+}
+
+// maxResponseSize 爱发电接口响应体上限
+const maxResponseSize = 4 << 20
+
+// apiOrder query-order 接口返回的订单
+type apiOrder struct {
+	OutTradeNo  string      `json:"out_trade_no"`
+	UserID      string      `json:"user_id"`
+	PlanID      string      `json:"plan_id"`
+	TotalAmount interface{} `json:"total_amount"`
+	Remark      string      `json:"remark"`
+	// Status 2 为交易成功
+	Status     int       `json:"status"`
+	CreateTime int64     `json:"create_time"`
+	SkuDetail  []SkuItem `json:"sku_detail"`
+}
+
+type queryOrderResult struct {
+	TotalCount int        `json:"total_count"`
+	TotalPage  int        `json:"total_page"`
+	List       []apiOrder `json:"list"`
+}
+
+// queryOrders 调用 query-order，params 可为 out_trade_no 或 page/per_page；同时返回原始响应
+func queryOrders(params map[string]interface{}) (*queryOrderResult, []byte, error) {
+	var result queryOrderResult
+	raw, err := apiPost("query-order", params, &result)
+	if err != nil {
+		return nil, raw, err
+	}
+	return &result, raw, nil
+}
+
+// apiPost 按爱发电开放平台规则签名并请求，data 字段解码到 out，返回原始响应体
+func apiPost(path string, params map[string]interface{}, out interface{}) ([]byte, error) {
+	userID := os.Getenv("USER_ID")
+	token := os.Getenv("TOKEN")
+	if userID == "" || token == "" {
+		return nil, errors.New("USER_ID/TOKEN 未设置")
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	ts := fmt.Sprintf("%d", time.Now().Unix())
+	signData := token + "params" + string(paramsJSON) + "ts" + ts + "user_id" + userID
+	h := md5.Sum([]byte(signData))
+	sign := hex.EncodeToString(h[:])
+
+	form := url.Values{}
+	form.Set("user_id", userID)
+	form.Set("params", string(paramsJSON))
+	form.Set("ts", ts)
+	form.Set("sign", sign)
+
+	resp, err := http.PostForm(afdianBase()+"/api/open/"+path, form)
+	if err != nil {
+		log.Printf("[API] %s http error: %v", path, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var payload struct {
+		Ec   int             `json:"ec"`
+		Em   string          `json:"em"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		log.Printf("[API] %s decode error: %v", path, err)
+		return raw, err
+	}
+	if payload.Ec != 200 {
+		return raw, fmt.Errorf("爱发电接口 %s 返回错误: ec=%d em=%s", path, payload.Ec, payload.Em)
+	}
+	if len(payload.Data) == 0 || out == nil {
+		return raw, nil
+	}
+	return raw, json.Unmarshal(payload.Data, out)
+}
+
+// querySponsorName 通过 query-sponsor 查询赞助者昵称
+func querySponsorName(userID string) (string, error) {
+	if userID == "" {
+		return "", nil
+	}
+	var result struct {
+		List []struct {
+			User struct {
+				UserID string `json:"user_id"`
+				Name   string `json:"name"`
+			} `json:"user"`
+		} `json:"list"`
+	}
+	if _, err := apiPost("query-sponsor", map[string]interface{}{"user_id": userID, "page": 1}, &result); err != nil {
+		return "", err
+	}
+	for _, it := range result.List {
+		if it.User.UserID == userID {
+			return it.User.Name, nil
+		}
+	}
+	return "", nil
+}
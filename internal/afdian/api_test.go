@@ -0,0 +1,51 @@
+package afdian
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloudreve-afdianpay/internal/afdianmock"
+	"cloudreve-afdianpay/internal/money"
+)
+
+// 对本地模拟服务验证签名与接口解析
+func TestAPIAgainstMock(t *testing.T) {
+	m := afdianmock.New("author", "secret")
+	ts := httptest.NewServer(m.Handler())
+	defer ts.Close()
+	t.Setenv("AFDIAN_BASE_URL", ts.URL)
+	t.Setenv("USER_ID", "author")
+	t.Setenv("TOKEN", "secret")
+
+	o, err := m.Pay(afdianmock.PayRequest{Remark: "o-api", TotalAmount: "12.5", SponsorID: "u-api", SponsorName: "乙", NoWebhook: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, total, raw, err := (&Service{}).apiCheck(o.OutTradeNo)
+	if err != nil || it == nil {
+		t.Fatalf("apiCheck = %v, %v", it, err)
+	}
+	if it.Remark != "o-api" || it.UserID != "u-api" || !total.Equal(money.New(1250, money.CNY)) || len(raw) == 0 {
+		t.Fatalf("apiCheck = %+v total=%s", it, total)
+	}
+	if name, err := querySponsorName("u-api"); err != nil || name != "乙" {
+		t.Fatalf("querySponsorName = %q, %v", name, err)
+	}
+	if it, _, _, err := (&Service{}).apiCheck("missing"); err != nil || it != nil {
+		t.Fatalf("apiCheck missing = %v, %v", it, err)
+	}
+
+	t.Setenv("TOKEN", "wrong")
+	if _, _, err := queryOrders(map[string]interface{}{"page": 1}); err == nil || !strings.Contains(err.Error(), "400005") {
+		t.Fatalf("bad token err = %v", err)
+	}
+}
+
+func TestCheckoutURLBase(t *testing.T) {
+	t.Setenv("AFDIAN_BASE_URL", "http://127.0.0.1:9801/")
+	u := checkoutURL("author", &Order{OrderNo: "o-1", Amount: money.New(500, money.CNY)})
+	if !strings.HasPrefix(u, "http://127.0.0.1:9801/order/create?user_id=author&") {
+		t.Fatalf("checkoutURL = %s", u)
+	}
+}
@@ -0,0 +1,249 @@
+package afdian
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// ErrClosed 服务已关闭
+var ErrClosed = errors.New("数据库已关闭")
+
+type stmtKey struct {
+	db    *sql.DB
+	query string
+}
+
+// EnsureDB 打开数据库并执行迁移，进程内只打开一次，重复调用无副作用；Close 之后返回 ErrClosed。
+// 设置了 DSN 时使用对应的 PostgreSQL/MySQL 后端，否则使用 DBPath 指向的 SQLite 文件
+func (s *Service) EnsureDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.wdb != nil {
+		return nil
+	}
+	d, dsn, err := parseDSN(s.DSN, s.DBPath)
+	if err != nil {
+		return err
+	}
+	if d.name == DialectSQLite {
+		err = s.openSQLite(d, dsn)
+	} else {
+		err = s.openServer(d, dsn)
+	}
+	if err != nil {
+		return err
+	}
+	s.d = d
+	s.stmts = make(map[stmtKey]*sql.Stmt)
+	return nil
+}
+
+func (s *Service) openSQLite(d *dialect, path string) error {
+	abs, _ := filepath.Abs(path)
+	// 写连接：WAL + busy_timeout，事务直接获取写锁，避免升级锁时的死锁
+	wdsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate", abs)
+	log.Printf("[DB] open dsn=%s", wdsn)
+	wdb, err := sql.Open(DialectSQLite, wdsn)
+	if err != nil {
+		return err
+	}
+	// SQLite 同一时刻只允许一个写者，写连接池限制为 1
+	wdb.SetMaxOpenConns(1)
+	wdb.SetMaxIdleConns(1)
+	wdb.SetConnMaxLifetime(0)
+	if err := migrate(wdb, d); err != nil {
+		log.Printf("[DB] ensure table error: %v", err)
+		wdb.Close()
+		return err
+	}
+
+	// 读连接：WAL 模式下读不阻塞写，可并发
+	rdsn := fmt.Sprintf("file:%s?_busy_timeout=5000&mode=ro", abs)
+	rdb, err := sql.Open(DialectSQLite, rdsn)
+	if err != nil {
+		wdb.Close()
+		return err
+	}
+	readers := runtime.NumCPU()
+	if readers < 4 {
+		readers = 4
+	}
+	rdb.SetMaxOpenConns(readers)
+	rdb.SetMaxIdleConns(readers)
+	rdb.SetConnMaxIdleTime(5 * time.Minute)
+	s.wdb, s.rdb = wdb, rdb
+	return nil
+}
+
+// openServer 打开 PostgreSQL/MySQL，读写共用一个连接池
+func (s *Service) openServer(d *dialect, dsn string) error {
+	log.Printf("[DB] open %s dsn=%s", d.name, redactDSN(dsn))
+	db, err := sql.Open(d.name, dsn)
+	if err != nil {
+		return err
+	}
+	conns := runtime.NumCPU() * 2
+	if conns < 8 {
+		conns = 8
+	}
+	db.SetMaxOpenConns(conns)
+	db.SetMaxIdleConns(conns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+	if err := migrate(db, d); err != nil {
+		log.Printf("[DB] ensure table error: %v", err)
+		db.Close()
+		return err
+	}
+	s.wdb, s.rdb = db, db
+	return nil
+}
+
+// Backend 返回存储后端描述（密码已隐去），用于启动信息
+func (s *Service) Backend() string {
+	d, dsn, err := parseDSN(s.DSN, s.DBPath)
+	if err != nil {
+		return redactDSN(s.DSN)
+	}
+	return d.name + " " + redactDSN(dsn)
+}
+
+// Close 关闭预编译语句与数据库连接，之后的调用均返回 ErrClosed，不会重新打开
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.wdb == nil {
+		return nil
+	}
+	for _, st := range s.stmts {
+		st.Close()
+	}
+	s.stmts = nil
+	var rerr error
+	if s.rdb != s.wdb {
+		rerr = s.rdb.Close()
+	}
+	werr := s.wdb.Close()
+	s.wdb, s.rdb = nil, nil
+	if werr != nil {
+		return werr
+	}
+	return rerr
+}
+
+func (s *Service) writer() (*sql.DB, error) {
+	if err := s.EnsureDB(); err != nil {
+		return nil, err
+	}
+	return s.wdb, nil
+}
+
+// prepare 返回缓存的预编译语句；缓存不设上限，query 中的值必须以 ? 传参，不能拼接进 SQL
+func (s *Service) prepare(write bool, query string) (*sql.Stmt, error) {
+	if err := s.EnsureDB(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wdb == nil {
+		return nil, ErrClosed
+	}
+	db := s.rdb
+	if write {
+		db = s.wdb
+	}
+	key := stmtKey{db, query}
+	if st, ok := s.stmts[key]; ok {
+		return st, nil
+	}
+	st, err := db.Prepare(s.d.rebind(query))
+	if err != nil {
+		log.Printf("[DB] prepare error: %v", err)
+		return nil, err
+	}
+	s.stmts[key] = st
+	return st, nil
+}
+
+// rebind 供事务内的语句改写占位符
+func (s *Service) rebind(query string) string {
+	return s.d.rebind(query)
+}
+
+func (s *Service) exec(query string, args ...interface{}) (sql.Result, error) {
+	st, err := s.prepare(true, query)
+	if err != nil {
+		return nil, err
+	}
+	return st.Exec(args...)
+}
+
+func (s *Service) query(query string, args ...interface{}) (*sql.Rows, error) {
+	st, err := s.prepare(false, query)
+	if err != nil {
+		return nil, err
+	}
+	return st.Query(args...)
+}
+
+func (s *Service) queryRow(query string, args ...interface{}) rowScanner {
+	st, err := s.prepare(false, query)
+	if err != nil {
+		return errRow{err}
+	}
+	return st.QueryRow(args...)
+}
+
+// errRow 预编译失败时返回，Scan 直接返回该错误
+type errRow struct{ err error }
+
+func (r errRow) Scan(dest ...interface{}) error { return r.err }
+
+var errLockTimeout = errors.New("等待数据库锁超时")
+
+// lockConn 在一个独占连接上获取跨实例的命名锁（PostgreSQL advisory lock 按 key，MySQL GET_LOCK 按 name），
+// 返回持有锁的连接与释放函数；需要在锁内执行的事务应在该连接上开启。SQLite 不支持，调用方自行处理
+func lockConn(db *sql.DB, d *dialect, name string, key int64, wait time.Duration) (*sql.Conn, func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var release string
+	var args []interface{}
+	if d.name == DialectPostgres {
+		release, args = "SELECT pg_advisory_unlock($1)", []interface{}{key}
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", args...)
+	} else {
+		release, args = "SELECT RELEASE_LOCK(?)", []interface{}{name}
+		var got sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(wait.Seconds())).Scan(&got)
+		if err == nil && got.Int64 != 1 {
+			err = errLockTimeout
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, func() {
+		if _, err := conn.ExecContext(context.Background(), release, args...); err != nil {
+			log.Printf("[DB] release lock %s error: %v", name, err)
+		}
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,53 @@
+package afdian
+
+import "testing"
+
+func TestParseDSN(t *testing.T) {
+	cases := []struct {
+		dsn, dialect, driverDSN string
+	}{
+		{"", DialectSQLite, "./a.db"},
+		{"sqlite:///var/lib/afdian.db", DialectSQLite, "/var/lib/afdian.db"},
+		{"postgres://u:p@db:5432/afdian?sslmode=disable", DialectPostgres, "postgres://u:p@db:5432/afdian?sslmode=disable"},
+		{"postgresql://db/afdian", DialectPostgres, "postgresql://db/afdian"},
+		{"mysql://u:p@tcp(db:3306)/afdian", DialectMySQL, "u:p@tcp(db:3306)/afdian"},
+	}
+	for _, c := range cases {
+		d, dsn, err := parseDSN(c.dsn, "./a.db")
+		if err != nil {
+			t.Fatalf("parseDSN(%q): %v", c.dsn, err)
+		}
+		if d.name != c.dialect || dsn != c.driverDSN {
+			t.Errorf("parseDSN(%q) = %s %q, want %s %q", c.dsn, d.name, dsn, c.dialect, c.driverDSN)
+		}
+	}
+	if _, _, err := parseDSN("oracle://db", ""); err == nil {
+		t.Error("unsupported scheme accepted")
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	cases := map[string]string{
+		"postgres://u:secret@db/afdian": "postgres://u:xxxxx@db/afdian",
+		"u:secret@tcp(db:3306)/afdian":  "u:xxxxx@tcp(db:3306)/afdian",
+		"/var/lib/afdian.db":            "/var/lib/afdian.db",
+	}
+	for in, want := range cases {
+		if got := redactDSN(in); got != want {
+			t.Errorf("redactDSN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	pg := dialects[DialectPostgres]
+	got := pg.rebind("UPDATE t SET a = ?, b = '?' WHERE c = ? AND d IN (?, ?)")
+	want := "UPDATE t SET a = $1, b = '?' WHERE c = $2 AND d IN ($3, $4)"
+	if got != want {
+		t.Errorf("rebind = %q, want %q", got, want)
+	}
+	q := "SELECT 1 WHERE a = ?"
+	if got := dialects[DialectMySQL].rebind(q); got != q {
+		t.Errorf("mysql rebind changed query: %q", got)
+	}
+}
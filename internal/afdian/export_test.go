@@ -0,0 +1,74 @@
+package afdian
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testExport EachOrder 的导出过滤条件，作为存储一致性测试的子测试运行
+func testExport(t *testing.T, svc *Service) {
+	// 使用远离其他用例的时间段，只匹配本用例的订单
+	base := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	paidAt := base.AddDate(0, 1, 0)
+	for i, no := range []string{"o-export-1", "o-export-2", "o-export-3"} {
+		newTestOrder(t, svc, no, 500)
+		if _, err := svc.exec("UPDATE afdian_pay SET created_at = ? WHERE order_no = ?", base.Unix()+int64(i*60), no); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.MarkOrderPaid("o-export-2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.exec("UPDATE afdian_pay SET paid_at = ? WHERE order_no = ?", paidAt.Unix(), "o-export-2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.exec("UPDATE afdian_pay SET site = ? WHERE order_no = ?", "https://other.example.com", "o-export-3"); err != nil {
+		t.Fatal(err)
+	}
+
+	collect := func(f OrderFilter) []string {
+		t.Helper()
+		var nos []string
+		if err := svc.EachOrder(f, func(o *Order) error { nos = append(nos, o.OrderNo); return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return nos
+	}
+	window := OrderFilter{Since: base, Until: base.Add(3 * time.Minute)}
+	cases := []struct {
+		name string
+		f    func(f OrderFilter) OrderFilter
+		want []string
+	}{
+		{"created window", func(f OrderFilter) OrderFilter { return f }, []string{"o-export-3", "o-export-2", "o-export-1"}},
+		{"site", func(f OrderFilter) OrderFilter { f.Site = siteURL(); return f }, []string{"o-export-2", "o-export-1"}},
+		{"status", func(f OrderFilter) OrderFilter { f.Status = StatusPaid; return f }, []string{"o-export-2"}},
+		// Until 不包含边界
+		{"until exclusive", func(f OrderFilter) OrderFilter { f.Until = base.Add(time.Minute); return f }, []string{"o-export-1"}},
+		{"limit", func(f OrderFilter) OrderFilter { f.Limit = 2; return f }, []string{"o-export-3", "o-export-2"}},
+		{"by paid_at", func(OrderFilter) OrderFilter {
+			return OrderFilter{ByPaidAt: true, Since: paidAt, Until: paidAt.Add(time.Second)}
+		}, []string{"o-export-2"}},
+	}
+	for _, c := range cases {
+		got := collect(c.f(window))
+		if len(got) != len(c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+
+	// fn 返回错误时停止遍历
+	stop := errors.New("stop")
+	calls := 0
+	if err := svc.EachOrder(window, func(*Order) error { calls++; return stop }); !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("EachOrder stop = %v after %d calls", err, calls)
+	}
+}
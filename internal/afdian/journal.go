@@ -0,0 +1,104 @@
+package afdian
+
+import (
+	"log"
+	"time"
+)
+
+// 回调处理结论
+const (
+	VerdictPaid            = "paid"
+	VerdictBadPayload      = "bad_payload"
+	VerdictNotMatched      = "not_matched"
+	VerdictOrderExpired    = "order_expired"
+	VerdictAlreadyRefunded = "already_refunded"
+	VerdictError           = "error"
+)
+
+// JournalEntry 一次爱发电回调的原始记录，只追加不修改
+type JournalEntry struct {
+	ID         int64
+	Site       string
+	OrderNo    string
+	OutTradeNo string
+	// Headers 回调请求头（JSON）
+	Headers string
+	// Body 回调原始请求体
+	Body string
+	// APIResponse 对应的 query-order 原始响应
+	APIResponse string
+	Verdict     string
+	ReceivedAt  time.Time
+	ProcessedAt time.Time
+}
+
+// AppendJournal 写入一条回调记录
+func (s *Service) AppendJournal(e *JournalEntry) error {
+	if e.Site == "" {
+		e.Site = siteURL()
+	}
+	db, err := s.writer()
+	if err != nil {
+		return err
+	}
+	e.ID, err = s.d.insertID(db, `INSERT INTO afdian_journal (site, order_no, out_trade_no, headers, body, api_response, verdict, received_at, processed_at)
+		VALUES (?,?,?,?,?,?,?,?,?)`,
+		e.Site, e.OrderNo, e.OutTradeNo, e.Headers, e.Body, e.APIResponse, e.Verdict, e.ReceivedAt.Unix(), e.ProcessedAt.Unix())
+	if err != nil {
+		log.Printf("[DB] append journal error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// JournalByOrder 按订单号或爱发电订单号查询回调记录，按时间正序
+func (s *Service) JournalByOrder(key string) ([]JournalEntry, error) {
+	rows, err := s.query(`SELECT id, site, order_no, out_trade_no, headers, body, api_response, verdict, received_at, processed_at
+		FROM afdian_journal WHERE order_no = ? OR out_trade_no = ? ORDER BY id`, key, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []JournalEntry
+	for rows.Next() {
+		var e JournalEntry
+		var receivedAt, processedAt int64
+		if err := rows.Scan(&e.ID, &e.Site, &e.OrderNo, &e.OutTradeNo, &e.Headers, &e.Body, &e.APIResponse, &e.Verdict, &receivedAt, &processedAt); err != nil {
+			return nil, err
+		}
+		e.ReceivedAt = time.Unix(receivedAt, 0)
+		e.ProcessedAt = time.Unix(processedAt, 0)
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// PurgeJournal 删除早于 now-retention 的回调记录
+func (s *Service) PurgeJournal(retention time.Duration) (int64, error) {
+	res, err := s.exec("DELETE FROM afdian_journal WHERE received_at < ?", time.Now().Add(-retention).Unix())
+	if err != nil {
+		log.Printf("[DB] purge journal error: %v", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func createJournalTable(m *migrator) error {
+	return m.execAll(
+		`CREATE TABLE IF NOT EXISTS afdian_journal (
+			id {id},
+			site {key} NOT NULL DEFAULT '',
+			order_no {key} NOT NULL DEFAULT '',
+			out_trade_no {key} NOT NULL DEFAULT '',
+			headers {text},
+			body {text},
+			api_response {text},
+			verdict {key} NOT NULL,
+			received_at {int} NOT NULL,
+			processed_at {int} NOT NULL
+		)`,
+		m.d.createIndex("idx_afdian_journal_order", "afdian_journal", "order_no", false),
+		m.d.createIndex("idx_afdian_journal_trade", "afdian_journal", "out_trade_no", false),
+		m.d.createIndex("idx_afdian_journal_received", "afdian_journal", "received_at", false),
+	)
+}
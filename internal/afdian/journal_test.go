@@ -0,0 +1,55 @@
+package afdian
+
+import (
+	"testing"
+	"time"
+)
+
+// testJournal 回调原始记录的写入、查询与清理，作为存储一致性测试的子测试运行
+func testJournal(t *testing.T, svc *Service) {
+	now := time.Now().Truncate(time.Second)
+	e1 := &JournalEntry{OrderNo: "o-journal", OutTradeNo: "T-j", Headers: `{"a":["b"]}`, Body: "{}", APIResponse: `{"ec":200}`, Verdict: VerdictPaid, ReceivedAt: now, ProcessedAt: now.Add(time.Second)}
+	e2 := &JournalEntry{OrderNo: "o-journal", Body: "old", Verdict: VerdictError, ReceivedAt: now.Add(-72 * time.Hour), ProcessedAt: now.Add(-72 * time.Hour)}
+	e3 := &JournalEntry{Site: "https://other.example.com", OrderNo: "o-journal", Body: "bad", Verdict: VerdictBadPayload, ReceivedAt: now, ProcessedAt: now}
+	for _, e := range []*JournalEntry{e1, e2, e3} {
+		if err := svc.AppendJournal(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e1.ID == 0 || e2.ID <= e1.ID || e3.ID <= e2.ID {
+		t.Fatalf("journal ids = %d, %d, %d", e1.ID, e2.ID, e3.ID)
+	}
+
+	list, err := svc.JournalByOrder("T-j")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("journal by trade no: %d entries", len(list))
+	}
+	got := list[0]
+	if got.ID != e1.ID || got.Site != siteURL() || got.Headers != e1.Headers || got.Body != e1.Body || got.APIResponse != e1.APIResponse ||
+		got.Verdict != VerdictPaid || !got.ReceivedAt.Equal(e1.ReceivedAt) || !got.ProcessedAt.Equal(e1.ProcessedAt) {
+		t.Fatalf("journal entry = %+v", got)
+	}
+
+	// 按订单号查询，按写入顺序返回，保留指定的站点
+	list, err = svc.JournalByOrder("o-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].ID != e1.ID || list[1].ID != e2.ID || list[2].Site != e3.Site {
+		t.Fatalf("journal by order: %+v", list)
+	}
+	if list, err := svc.JournalByOrder("o-journal-missing"); err != nil || len(list) != 0 {
+		t.Fatalf("journal for unknown order = %v, %v", list, err)
+	}
+
+	n, err := svc.PurgeJournal(24 * time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("PurgeJournal = %d, %v; want 1", n, err)
+	}
+	if list, _ := svc.JournalByOrder("o-journal"); len(list) != 2 || list[0].ID != e1.ID || list[1].ID != e3.ID {
+		t.Fatalf("journal after purge: %+v", list)
+	}
+}
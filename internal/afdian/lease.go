@@ -0,0 +1,113 @@
+package afdian
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// defaultInstanceID 实例标识：主机名-进程号，多副本部署时用于区分租约与通知认领的持有者
+func defaultInstanceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// AcquireLease 获取或续约名为 name 的租约，租约未过期且由其他实例持有时返回 false。
+// 各实例的时钟需保持同步（NTP），ttl 应远大于时钟偏差
+func (s *Service) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	until := now.Add(ttl).Unix()
+	res, err := s.exec("UPDATE afdian_lease SET holder = ?, expires_at = ? WHERE name = ? AND (holder = ? OR expires_at < ?)",
+		holder, until, name, holder, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	res, err = s.exec(s.d.insertIgnore("INSERT INTO afdian_lease (name, holder, expires_at) VALUES (?,?,?)", "name"), name, holder, until)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	// MySQL 续约时若值未变化也返回 0 行，按当前持有者判断
+	var cur string
+	var expiresAt int64
+	if err := s.queryRow("SELECT holder, expires_at FROM afdian_lease WHERE name = ?", name).Scan(&cur, &expiresAt); err != nil {
+		return false, err
+	}
+	return cur == holder && expiresAt >= now.Unix(), nil
+}
+
+// ReleaseLease 主动释放租约，便于其他实例立即接管
+func (s *Service) ReleaseLease(name, holder string) error {
+	_, err := s.exec("UPDATE afdian_lease SET expires_at = 0 WHERE name = ? AND holder = ?", name, holder)
+	return err
+}
+
+// Elector 基于数据库租约的选主，持有租约的实例运行单例任务（过期清理、对账）
+type Elector struct {
+	svc    *Service
+	name   string
+	holder string
+	ttl    time.Duration
+	leader atomic.Bool
+}
+
+// MinLeaseTTL 租约时长下限：到期时间按秒记录，且每 ttl/3 续约一次
+const MinLeaseTTL = 3 * time.Second
+
+// NewElector 创建租约名为 name 的选主器，持有者为 Service.Instance，ttl 不应小于 MinLeaseTTL
+func (s *Service) NewElector(name string, ttl time.Duration) *Elector {
+	return &Elector{svc: s, name: name, holder: s.Instance, ttl: ttl}
+}
+
+// IsLeader 当前实例是否持有租约
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run 每 ttl/3 续约一次，直到 ctx 结束后释放租约
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.renew()
+		select {
+		case <-ctx.Done():
+			if e.leader.Swap(false) {
+				if err := e.svc.ReleaseLease(e.name, e.holder); err != nil {
+					log.Printf("[Lease] release %s error: %v", e.name, err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) renew() {
+	ok, err := e.svc.AcquireLease(e.name, e.holder, e.ttl)
+	if err != nil {
+		// 无法确认租约时停止运行单例任务，宁可少跑一次也不重复执行
+		log.Printf("[Lease] renew %s error: %v", e.name, err)
+		ok = false
+	}
+	if was := e.leader.Swap(ok); was != ok {
+		if ok {
+			log.Printf("[Lease] %s acquired by %s", e.name, e.holder)
+		} else {
+			log.Printf("[Lease] %s lost by %s", e.name, e.holder)
+		}
+	}
+}
+
+// isLeader 未配置选主时视为单实例部署
+func (s *Service) isLeader() bool {
+	return s.Leader == nil || s.Leader()
+}
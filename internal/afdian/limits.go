@@ -0,0 +1,315 @@
+package afdian
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"time"
+
+	"cloudreve-afdianpay/internal/money"
+)
+
+// 金额限制类型，见 LimitError
+const (
+	// LimitBelowMin/LimitAboveMax 换算后的 CNY 金额超出范围
+	LimitBelowMin = "below_min"
+	LimitAboveMax = "above_max"
+	// LimitOriginalBelowMin/LimitOriginalAboveMax 换算前的原始币种金额超出范围
+	LimitOriginalBelowMin = "original_below_min"
+	LimitOriginalAboveMax = "original_above_max"
+	// LimitDailyCap 站点当日下单总额超过上限
+	LimitDailyCap = "daily_cap"
+)
+
+// defaultMinCNY 爱发电自定义金额的下限
+const defaultMinCNY = "5.00"
+
+// LimitError 下单金额不满足限制
+type LimitError struct {
+	Kind  string
+	Limit money.Amount
+}
+
+func (e *LimitError) Error() string {
+	switch e.Kind {
+	case LimitBelowMin:
+		return fmt.Sprintf("CNY金额需要大于等于%s元", trimZeros(e.Limit.String()))
+	case LimitAboveMax:
+		return fmt.Sprintf("CNY金额需要小于等于%s元", trimZeros(e.Limit.String()))
+	case LimitOriginalBelowMin:
+		return fmt.Sprintf("%s金额需要大于等于%s", e.Limit.Currency, e.Limit)
+	case LimitOriginalAboveMax:
+		return fmt.Sprintf("%s金额需要小于等于%s", e.Limit.Currency, e.Limit)
+	case LimitDailyCap:
+		return fmt.Sprintf("今日订单金额已达上限%s元", trimZeros(e.Limit.String()))
+	}
+	return "订单金额不满足限制"
+}
+
+// trimZeros 去掉小数部分末尾的 0，如 5.00 → 5、5.50 → 5.5
+func trimZeros(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// AmountRange 单一币种的金额范围，留空表示不限制
+type AmountRange struct {
+	Min string `json:"min"`
+	Max string `json:"max"`
+
+	min, max *money.Amount
+}
+
+// SiteLimits 站点的下单限制
+type SiteLimits struct {
+	// Amounts 按币种的金额范围，CNY 用于换算后的金额，其他币种用于换算前的原始金额
+	Amounts map[string]*AmountRange `json:"amounts"`
+	// DailyCap 站点当日（本地时间）待支付与已支付订单的 CNY 总额上限，留空表示不限制
+	DailyCap string `json:"daily_cap"`
+
+	dailyCap *money.Amount
+}
+
+// Limits 按站点的下单限制，键为通过校验的 X-Cr-Site-Url（即 SITE_URL），"*" 为所有站点的默认值；
+// 站点配置按币种逐项覆盖默认值
+type Limits map[string]*SiteLimits
+
+// DefaultLimits 未配置 LIMITS_FILE 时的限制：CNY 不低于 5 元
+func DefaultLimits() Limits {
+	l := Limits{"*": {Amounts: map[string]*AmountRange{string(money.CNY): {Min: defaultMinCNY}}}}
+	_ = l.parse()
+	return l
+}
+
+// LoadLimits 读取下单限制 JSON 文件并叠加到默认限制上，path 为空时返回默认限制
+func LoadLimits(path string) (Limits, error) {
+	l := DefaultLimits()
+	if path == "" {
+		return l, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file Limits
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	if err := file.parse(); err != nil {
+		return nil, err
+	}
+	for site, sl := range file {
+		if site != "*" {
+			l[site] = sl
+			continue
+		}
+		def := l["*"]
+		for cur, r := range sl.Amounts {
+			def.Amounts[cur] = r
+		}
+		if sl.dailyCap != nil {
+			def.DailyCap, def.dailyCap = sl.DailyCap, sl.dailyCap
+		}
+	}
+	return l, nil
+}
+
+func (l Limits) parse() error {
+	for site, sl := range l {
+		if sl == nil {
+			return fmt.Errorf("站点 %s 的限制为空", site)
+		}
+		if site != "*" && strings.HasSuffix(site, "/") {
+			return fmt.Errorf("站点 %s 不应以 / 结尾", site)
+		}
+		amounts := make(map[string]*AmountRange, len(sl.Amounts))
+		for code, r := range sl.Amounts {
+			cur, err := money.ParseCurrency(code)
+			if err != nil {
+				return fmt.Errorf("站点 %s 的币种 %q 无效", site, code)
+			}
+			if r == nil {
+				return fmt.Errorf("站点 %s 的 %s 限制为空", site, cur)
+			}
+			if r.min, err = parseLimit(r.Min, cur); err != nil {
+				return fmt.Errorf("站点 %s 的 %s 下限无效: %w", site, cur, err)
+			}
+			if r.max, err = parseLimit(r.Max, cur); err != nil {
+				return fmt.Errorf("站点 %s 的 %s 上限无效: %w", site, cur, err)
+			}
+			if r.min != nil && r.max != nil && r.min.Minor > r.max.Minor {
+				return fmt.Errorf("站点 %s 的 %s 下限大于上限", site, cur)
+			}
+			amounts[string(cur)] = r
+		}
+		sl.Amounts = amounts
+		var err error
+		if sl.dailyCap, err = parseLimit(sl.DailyCap, money.CNY); err != nil {
+			return fmt.Errorf("站点 %s 的每日上限无效: %w", site, err)
+		}
+	}
+	return nil
+}
+
+func parseLimit(s string, cur money.Currency) (*money.Amount, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	a, err := money.Parse(s, cur)
+	if err != nil {
+		return nil, err
+	}
+	if a.Minor < 0 {
+		return nil, money.ErrInvalidAmount
+	}
+	return &a, nil
+}
+
+// amountRange 站点在该币种的范围，站点未配置的一侧使用默认值
+func (l Limits) amountRange(site string, cur money.Currency) (min, max *money.Amount) {
+	if def := l["*"]; def != nil {
+		if r := def.Amounts[string(cur)]; r != nil {
+			min, max = r.min, r.max
+		}
+	}
+	if sl := l[site]; sl != nil {
+		if r := sl.Amounts[string(cur)]; r != nil {
+			if r.Min != "" {
+				min = r.min
+			}
+			if r.Max != "" {
+				max = r.max
+			}
+		}
+	}
+	return min, max
+}
+
+func (l Limits) dailyCap(site string) *money.Amount {
+	if sl := l[site]; sl != nil && sl.dailyCap != nil {
+		return sl.dailyCap
+	}
+	if def := l["*"]; def != nil {
+		return def.dailyCap
+	}
+	return nil
+}
+
+// limits 未设置 Limits 时使用默认限制
+func (s *Service) limits() Limits {
+	if s.Limits == nil {
+		return DefaultLimits()
+	}
+	return s.Limits
+}
+
+// CheckOriginal 检查站点 site 换算前的原始币种金额，CNY 订单由 CheckAmount 检查
+func (s *Service) CheckOriginal(site string, a money.Amount) error {
+	if a.Currency == money.CNY {
+		return nil
+	}
+	min, max := s.limits().amountRange(site, a.Currency)
+	if min != nil && a.Minor < min.Minor {
+		return &LimitError{Kind: LimitOriginalBelowMin, Limit: *min}
+	}
+	if max != nil && a.Minor > max.Minor {
+		return &LimitError{Kind: LimitOriginalAboveMax, Limit: *max}
+	}
+	return nil
+}
+
+// CheckAmount 检查站点 site 换算后的 CNY 金额与当日总额；orderNo 不计入当日总额，Cloudreve 重试下单时不会重复计算
+func (s *Service) CheckAmount(site, orderNo string, cny money.Amount) error {
+	l := s.limits()
+	min, max := l.amountRange(site, money.CNY)
+	if min != nil && cny.Minor < min.Minor {
+		return &LimitError{Kind: LimitBelowMin, Limit: *min}
+	}
+	if max != nil && cny.Minor > max.Minor {
+		return &LimitError{Kind: LimitAboveMax, Limit: *max}
+	}
+	daily := l.dailyCap(site)
+	if daily == nil {
+		return nil
+	}
+	total, err := s.DailyTotal(site, orderNo, time.Now())
+	if err != nil {
+		return err
+	}
+	if total.Minor+cny.Minor > daily.Minor {
+		return &LimitError{Kind: LimitDailyCap, Limit: *daily}
+	}
+	return nil
+}
+
+// dailyTotalQuery 站点在 [start, end) 内创建的待支付与已支付订单的 CNY 总额，不含指定订单号
+const dailyTotalQuery = "SELECT COALESCE(SUM(amount_minor), 0) FROM afdian_pay WHERE site = ? AND created_at >= ? AND created_at < ? AND status IN (?, ?) AND order_no <> ?"
+
+func dailyTotalArgs(site, excludeOrderNo string, day time.Time) []interface{} {
+	y, m, d := day.Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, day.Location())
+	return []interface{}{site, start.Unix(), start.AddDate(0, 0, 1).Unix(), StatusPending, StatusPaid, excludeOrderNo}
+}
+
+// DailyTotal 站点 site 在 day 当天（本地时间）创建的待支付与已支付订单的 CNY 总额，不含 excludeOrderNo
+func (s *Service) DailyTotal(site, excludeOrderNo string, day time.Time) (money.Amount, error) {
+	var total int64
+	if err := s.queryRow(dailyTotalQuery, dailyTotalArgs(site, excludeOrderNo, day)...).Scan(&total); err != nil {
+		return money.Amount{}, err
+	}
+	return money.New(total, money.CNY), nil
+}
+
+// dailyCapLockWait 等待同一站点其他下单完成的最长时间
+const dailyCapLockWait = 10 * time.Second
+
+// insertWithinDailyCap 在同一写事务内重新计算站点当日总额并写入订单，多实例并发下单时不会超出 daily。
+// SQLite 的写事务（BEGIN IMMEDIATE）本身互斥；PostgreSQL/MySQL 在开启事务前按站点获取命名锁，
+// 同一站点的下单在此串行。订单号已存在时不写入并返回 false，由调用方按重试处理
+func (s *Service) insertWithinDailyCap(o *Order, daily *money.Amount) (bool, error) {
+	db, err := s.writer()
+	if err != nil {
+		return false, err
+	}
+	var tx *sql.Tx
+	if s.d.name == DialectSQLite {
+		tx, err = db.Begin()
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(o.Site))
+		sum := h.Sum64()
+		conn, unlock, lerr := lockConn(db, s.d, fmt.Sprintf("afdianpay_daily_cap_%016x", sum), int64(sum), dailyCapLockWait)
+		if lerr != nil {
+			return false, lerr
+		}
+		defer unlock()
+		tx, err = conn.BeginTx(context.Background(), nil)
+	}
+	if err != nil {
+		return false, err
+	}
+	// 提交后 Rollback 不生效，提前返回时回滚
+	defer func() { _ = tx.Rollback() }()
+	var total int64
+	if err := tx.QueryRow(s.rebind(dailyTotalQuery), dailyTotalArgs(o.Site, o.OrderNo, o.CreatedAt)...).Scan(&total); err != nil {
+		return false, err
+	}
+	if total+o.Amount.Minor > daily.Minor {
+		return false, &LimitError{Kind: LimitDailyCap, Limit: *daily}
+	}
+	res, err := tx.Exec(s.rebind(s.d.insertIgnore(insertOrderQuery, "site, order_no")), o.insertArgs()...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
@@ -0,0 +1,107 @@
+package afdian
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloudreve-afdianpay/internal/money"
+)
+
+func writeLimits(t *testing.T, json string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "limits.json")
+	if err := os.WriteFile(path, []byte(json), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLimits(t *testing.T) {
+	cases := []struct {
+		json string
+		err  string
+	}{
+		{`{"*":{"amounts":{"usd":{"min":"1","max":"500"}},"daily_cap":"20000"},"https://a.example.com":{"amounts":{"CNY":{"max":"1000"}}}}`, ""},
+		{`{"*":{"amounts":{"XXX":{"min":"1"}}}}`, "币种"},
+		{`{"*":{"amounts":{"CNY":{"min":"10","max":"5"}}}}`, "下限大于上限"},
+		{`{"*":{"amounts":{"CNY":{"min":"-1"}}}}`, "下限无效"},
+		{`{"*":{"amounts":{"JPY":{"max":"1.5"}}}}`, "上限无效"},
+		{`{"*":{"daily_cap":"abc"}}`, "每日上限无效"},
+		{`{"https://a.example.com/":{}}`, "不应以 / 结尾"},
+		{`[]`, "解析"},
+	}
+	for _, c := range cases {
+		l, err := LoadLimits(writeLimits(t, c.json))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("LoadLimits(%s) err = %v, want %q", c.json, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 默认的 CNY 下限保留，站点逐项覆盖
+		min, max := l.amountRange("https://a.example.com", money.CNY)
+		if min == nil || min.Minor != 500 || max == nil || max.Minor != 100000 {
+			t.Fatalf("site CNY range = %v, %v", min, max)
+		}
+		if min, max := l.amountRange("https://b.example.com", "USD"); min.Minor != 100 || max.Minor != 50000 {
+			t.Fatalf("default USD range = %v, %v", min, max)
+		}
+		if c := l.dailyCap("https://a.example.com"); c == nil || c.Minor != 2000000 {
+			t.Fatalf("daily cap = %v", c)
+		}
+	}
+	l, err := LoadLimits("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if min, max := l.amountRange("https://a.example.com", money.CNY); min.Minor != 500 || max != nil || l.dailyCap("") != nil {
+		t.Fatalf("default limits = %v, %v", min, max)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	t.Setenv("SITE_URL", "https://a.example.com")
+	svc := NewService(filepath.Join(t.TempDir(), "limits.db"))
+	defer svc.Close()
+	l, err := LoadLimits(writeLimits(t, `{"*":{"amounts":{"USD":{"min":"1.00","max":"100.00"},"CNY":{"min":"0"}}},"https://a.example.com":{"amounts":{"CNY":{"min":"2","max":"500"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Limits = l
+	original := func(a money.Amount) error { return svc.CheckOriginal(siteURL(), a) }
+	cases := []struct {
+		amount money.Amount
+		check  func(money.Amount) error
+		kind   string
+		msg    string
+	}{
+		{money.New(99, "USD"), original, LimitOriginalBelowMin, "USD金额需要大于等于1.00"},
+		{money.New(10001, "USD"), original, LimitOriginalAboveMax, "USD金额需要小于等于100.00"},
+		{money.New(100, "USD"), original, "", ""},
+		// EUR 未配置，不限制
+		{money.New(1, "EUR"), original, "", ""},
+		{money.New(199, money.CNY), original, "", ""},
+		{money.New(199, money.CNY), func(a money.Amount) error { return svc.CheckAmount(siteURL(), "x", a) }, LimitBelowMin, "CNY金额需要大于等于2元"},
+		{money.New(50001, money.CNY), func(a money.Amount) error { return svc.CheckAmount(siteURL(), "x", a) }, LimitAboveMax, "CNY金额需要小于等于500元"},
+		{money.New(200, money.CNY), func(a money.Amount) error { return svc.CheckAmount(siteURL(), "x", a) }, "", ""},
+	}
+	for _, c := range cases {
+		err := c.check(c.amount)
+		var le *LimitError
+		if c.kind == "" {
+			if err != nil {
+				t.Fatalf("check %s = %v", c.amount, err)
+			}
+			continue
+		}
+		if !errors.As(err, &le) || le.Kind != c.kind || le.Error() != c.msg {
+			t.Fatalf("check %s = %v, want %s %q", c.amount, err, c.kind, c.msg)
+		}
+	}
+}
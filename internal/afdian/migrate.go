@@ -0,0 +1,377 @@
+package afdian
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cloudreve-afdianpay/internal/money"
+)
+
+// migration 一次数据库结构变更，按 version 顺序执行且只执行一次
+type migration struct {
+	version int
+	name    string
+	up      func(m *migrator) error
+}
+
+// migrator 在迁移事务内执行语句，按方言替换建表类型占位与参数占位符。
+// 注意 MySQL 的 DDL 会隐式提交，迁移中途失败时需人工检查
+type migrator struct {
+	tx *sql.Tx
+	d  *dialect
+}
+
+func (m *migrator) exec(query string, args ...interface{}) (sql.Result, error) {
+	return m.tx.Exec(m.d.rebind(m.d.types.Replace(query)), args...)
+}
+
+// execAll 依次执行多条无参数语句
+func (m *migrator) execAll(stmts ...string) error {
+	for _, q := range stmts {
+		if _, err := m.exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacy 旧版（Python）只使用 SQLite，历史数据的修补只在 SQLite 上执行
+func (m *migrator) legacy() bool {
+	return m.d.name == DialectSQLite
+}
+
+var migrations = []migration{
+	{1, "create afdian_pay", func(m *migrator) error {
+		_, err := m.exec(`CREATE TABLE IF NOT EXISTS afdian_pay (
+			order_no {key},
+			amount {key},
+			notify_url TEXT,
+			is_paid {bool} DEFAULT 0
+		)`)
+		return err
+	}},
+	{2, "amount minor units and currency", migrateAmountMinor},
+	{3, "order status and timestamps", func(m *migrator) error {
+		if err := m.execAll(
+			"ALTER TABLE afdian_pay ADD COLUMN status {key} NOT NULL DEFAULT 'pending'",
+			"ALTER TABLE afdian_pay ADD COLUMN created_at {int} NOT NULL DEFAULT 0",
+			"ALTER TABLE afdian_pay ADD COLUMN paid_at {int}",
+			"ALTER TABLE afdian_pay ADD COLUMN expired_at {int}",
+		); err != nil {
+			return err
+		}
+		if m.legacy() {
+			if _, err := m.exec("UPDATE afdian_pay SET status = 'paid' WHERE is_paid = 1 OR is_paid = 'true'"); err != nil {
+				return err
+			}
+		}
+		// 旧订单没有创建时间，以迁移时间为准，之后按 TTL 正常过期
+		_, err := m.exec("UPDATE afdian_pay SET created_at = ? WHERE created_at = 0", time.Now().Unix())
+		return err
+	}},
+	{4, "create afdian_review", func(m *migrator) error {
+		_, err := m.exec(`CREATE TABLE IF NOT EXISTS afdian_review (
+			id {id},
+			order_no {key} NOT NULL DEFAULT '',
+			out_trade_no {key} NOT NULL DEFAULT '',
+			amount_minor {int} NOT NULL DEFAULT 0,
+			currency {key} NOT NULL DEFAULT 'CNY',
+			reason {key} NOT NULL,
+			created_at {int} NOT NULL
+		)`)
+		return err
+	}},
+	{5, "unique order_no per site", migrateUniqueOrderNo},
+	{6, "create afdian_refund", func(m *migrator) error {
+		return m.execAll(`CREATE TABLE IF NOT EXISTS afdian_refund (
+			id {id},
+			site {key} NOT NULL,
+			order_no {key} NOT NULL,
+			amount_minor {int} NOT NULL,
+			currency {key} NOT NULL,
+			reason {text},
+			source {key} NOT NULL,
+			revoke_status {key} NOT NULL,
+			created_at {int} NOT NULL
+		)`,
+			m.d.createIndex("idx_afdian_refund_order", "afdian_refund", "site, order_no", false),
+		)
+	}},
+	{7, "plan and sku checkout", func(m *migrator) error {
+		return m.execAll(
+			"ALTER TABLE afdian_pay ADD COLUMN plan_id {key} NOT NULL DEFAULT ''",
+			"ALTER TABLE afdian_pay ADD COLUMN sku_id {key} NOT NULL DEFAULT ''",
+			"ALTER TABLE afdian_pay ADD COLUMN sku_count {int} NOT NULL DEFAULT 0",
+		)
+	}},
+	{8, "notified_at", func(m *migrator) error {
+		return m.execAll(
+			"ALTER TABLE afdian_pay ADD COLUMN notified_at {int}",
+			// 旧版本不记录通知结果，已支付的历史订单视为已通知，避免 notify replay 重复发放
+			"UPDATE afdian_pay SET notified_at = COALESCE(paid_at, created_at) WHERE status = 'paid'",
+		)
+	}},
+	{9, "original amount and out_trade_no", func(m *migrator) error {
+		return m.execAll(
+			"ALTER TABLE afdian_pay ADD COLUMN orig_amount_minor {int} NOT NULL DEFAULT 0",
+			"ALTER TABLE afdian_pay ADD COLUMN orig_currency {key} NOT NULL DEFAULT 'CNY'",
+			"ALTER TABLE afdian_pay ADD COLUMN out_trade_no {key} NOT NULL DEFAULT ''",
+			// 旧订单未记录原始币种，按 CNY 金额回填
+			"UPDATE afdian_pay SET orig_amount_minor = amount_minor, orig_currency = currency",
+		)
+	}},
+	{10, "sponsor identity", func(m *migrator) error {
+		return m.execAll(
+			"ALTER TABLE afdian_pay ADD COLUMN sponsor_user_id {key} NOT NULL DEFAULT ''",
+			"ALTER TABLE afdian_pay ADD COLUMN sponsor_name {key} NOT NULL DEFAULT ''",
+			"ALTER TABLE afdian_pay ADD COLUMN paid_plan_id {key} NOT NULL DEFAULT ''",
+			"ALTER TABLE afdian_pay ADD COLUMN afdian_paid_at {int}",
+			m.d.createIndex("idx_afdian_pay_out_trade_no", "afdian_pay", "out_trade_no", false),
+			m.d.createIndex("idx_afdian_pay_sponsor", "afdian_pay", "sponsor_user_id", false),
+		)
+	}},
+	{11, "create afdian_journal", createJournalTable},
+	{12, "leases and notify claims", func(m *migrator) error {
+		return m.execAll(`CREATE TABLE IF NOT EXISTS afdian_lease (
+			name {key} PRIMARY KEY,
+			holder {key} NOT NULL,
+			expires_at {int} NOT NULL
+		)`,
+			"ALTER TABLE afdian_pay ADD COLUMN notify_claimed_by {key} NOT NULL DEFAULT ''",
+			"ALTER TABLE afdian_pay ADD COLUMN notify_claim_until {int}",
+		)
+	}},
+	{13, "review remark", func(m *migrator) error {
+		return m.execAll(
+			"ALTER TABLE afdian_review ADD COLUMN remark {key} NOT NULL DEFAULT ''",
+			m.d.createIndex("idx_afdian_review_trade", "afdian_review", "out_trade_no", false),
+		)
+	}},
+	{14, "review resolution", func(m *migrator) error {
+		return m.execAll(
+			"ALTER TABLE afdian_review ADD COLUMN status {key} NOT NULL DEFAULT 'open'",
+			"ALTER TABLE afdian_review ADD COLUMN note {key} NOT NULL DEFAULT ''",
+			"ALTER TABLE afdian_review ADD COLUMN resolved_at {int}",
+			m.d.createIndex("idx_afdian_review_status", "afdian_review", "status", false),
+		)
+	}},
+	{15, "create afdian_webhook", createWebhookTable},
+	{16, "clear is_paid on refunded orders", func(m *migrator) error {
+		// 旧版本退款时只修改 status，is_paid 仍为 1
+		_, err := m.exec("UPDATE afdian_pay SET is_paid = 0 WHERE status = 'refunded'")
+		return err
+	}},
+	{17, "refunded total", func(m *migrator) error {
+		return m.execAll(
+			"ALTER TABLE afdian_pay ADD COLUMN refunded_minor {int} NOT NULL DEFAULT 0",
+			`UPDATE afdian_pay SET refunded_minor = COALESCE((SELECT SUM(r.amount_minor) FROM afdian_refund r
+				WHERE r.site = afdian_pay.site AND r.order_no = afdian_pay.order_no), 0)`,
+		)
+	}},
+	{18, "legacy remark orders", func(m *migrator) error {
+		// 此前创建的待支付订单，赞助者手中的链接可能是旧版的纯订单号留言
+		return m.execAll(
+			"ALTER TABLE afdian_pay ADD COLUMN legacy_remark {int} NOT NULL DEFAULT 0",
+			"UPDATE afdian_pay SET legacy_remark = 1 WHERE status = 'pending'",
+		)
+	}},
+	{19, "unique review per payment", migrateReviewDedupe},
+}
+
+// migrate 执行尚未应用的迁移
+func migrate(db *sql.DB, d *dialect) error {
+	unlock, err := lockMigrations(db, d)
+	if err != nil {
+		log.Printf("[DB] migration lock error: %v", err)
+		return err
+	}
+	defer unlock()
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255)
+	)`); err != nil {
+		log.Printf("[DB] ensure schema_migrations error: %v", err)
+		return err
+	}
+	current, err := latestVersion(db.QueryRow(latestVersionQuery))
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		// 事务内重新读取版本：其他实例可能已在等待期间完成了这次迁移
+		if v, err := latestVersion(tx.QueryRow(latestVersionQuery)); err != nil || v >= m.version {
+			_ = tx.Rollback()
+			if err != nil {
+				return err
+			}
+			current = v
+			continue
+		}
+		if err := m.up(&migrator{tx, d}); err != nil {
+			_ = tx.Rollback()
+			log.Printf("[DB] migration %d (%s) error: %v", m.version, m.name, err)
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+		if _, err := tx.Exec(d.rebind("INSERT INTO schema_migrations (version, name) VALUES (?,?)"), m.version, m.name); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("[DB] migration %d (%s) applied", m.version, m.name)
+	}
+	return nil
+}
+
+// migrationLock 迁移锁的名称（MySQL）与键（PostgreSQL）
+const (
+	migrationLockName = "afdianpay_schema_migrations"
+	migrationLockKey  = 7310472551
+	migrationLockWait = 5 * time.Minute
+)
+
+// lockMigrations 多实例同时启动时串行执行迁移，返回释放锁的函数。
+// PostgreSQL 使用 advisory lock，MySQL 使用 GET_LOCK，见 lockConn；
+// SQLite 的迁移事务以 BEGIN IMMEDIATE 获取写锁，由 migrate 在事务内重新检查版本
+func lockMigrations(db *sql.DB, d *dialect) (func(), error) {
+	if d.name == DialectSQLite {
+		return func() {}, nil
+	}
+	_, unlock, err := lockConn(db, d, migrationLockName, migrationLockKey, migrationLockWait)
+	if errors.Is(err, errLockTimeout) {
+		err = errors.New("等待其他实例完成数据库迁移超时")
+	}
+	return unlock, err
+}
+
+// migrateAmountMinor 新增 amount_minor/currency 列，并由旧的 amount 文本回填
+func migrateAmountMinor(m *migrator) error {
+	if err := m.execAll(
+		"ALTER TABLE afdian_pay ADD COLUMN amount_minor {int} NOT NULL DEFAULT 0",
+		"ALTER TABLE afdian_pay ADD COLUMN currency {key} NOT NULL DEFAULT 'CNY'",
+	); err != nil {
+		return err
+	}
+	if !m.legacy() {
+		return nil
+	}
+	rows, err := m.tx.Query("SELECT rowid, amount FROM afdian_pay")
+	if err != nil {
+		return err
+	}
+	type backfill struct {
+		rowID int64
+		minor int64
+	}
+	var pending []backfill
+	for rows.Next() {
+		var rowID int64
+		var amountStr sql.NullString
+		if err := rows.Scan(&rowID, &amountStr); err != nil {
+			rows.Close()
+			return err
+		}
+		a, err := money.Parse(amountStr.String, money.CNY)
+		if err != nil {
+			log.Printf("[DB] backfill skip rowid=%d amount=%q: %v", rowID, amountStr.String, err)
+			continue
+		}
+		pending = append(pending, backfill{rowID, a.Minor})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, b := range pending {
+		if _, err := m.exec("UPDATE afdian_pay SET amount_minor = ? WHERE rowid = ?", b.minor, b.rowID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateUniqueOrderNo 新增 site 列并建立 (site, order_no) 唯一索引。
+// 已有订单的 site 取迁移时的 SITE_URL，之后修改 SITE_URL 不会更新历史订单，
+// 因此有历史订单时要求 SITE_URL 已设置。
+// 旧数据中的重复行只保留一条：优先已支付，其次最新写入。
+func migrateUniqueOrderNo(m *migrator) error {
+	site := siteURL()
+	if site == "" {
+		var n int
+		if err := m.tx.QueryRow("SELECT COUNT(*) FROM afdian_pay").Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return errors.New("升级已有订单需要先设置 SITE_URL，历史订单的站点取迁移时的 SITE_URL")
+		}
+	}
+	if _, err := m.exec("ALTER TABLE afdian_pay ADD COLUMN site {key} NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := m.exec("UPDATE afdian_pay SET site = ?", site); err != nil {
+		return err
+	}
+	if m.legacy() {
+		if err := dedupeLegacyOrders(m); err != nil {
+			return err
+		}
+	}
+	_, err := m.exec(m.d.createIndex("idx_afdian_pay_site_order", "afdian_pay", "site, order_no", true))
+	return err
+}
+
+func dedupeLegacyOrders(m *migrator) error {
+	res, err := m.exec(`DELETE FROM afdian_pay WHERE rowid NOT IN (
+		SELECT (SELECT p2.rowid FROM afdian_pay p2
+			WHERE p2.site = p1.site AND p2.order_no = p1.order_no
+			ORDER BY p2.status = 'paid' DESC, p2.rowid DESC LIMIT 1)
+		FROM afdian_pay p1 GROUP BY p1.site, p1.order_no
+	)`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[DB] removed %d duplicate orders", n)
+	}
+	return nil
+}
+
+// migrateReviewDedupe 爱发电重复回调可能并发到达，改由唯一索引保证同一笔付款同一原因只入队一次。
+// dedupe_trade_no 在 out_trade_no 非空时等于它、否则为 NULL（NULL 不参与唯一约束）；
+// 已有的重复记录只保留最早的一条参与去重
+func migrateReviewDedupe(m *migrator) error {
+	return m.execAll(
+		"ALTER TABLE afdian_review ADD COLUMN dedupe_trade_no {key}",
+		// MySQL 不允许 UPDATE 的子查询直接引用同一张表，多套一层派生表
+		`UPDATE afdian_review SET dedupe_trade_no = out_trade_no WHERE id IN (
+			SELECT id FROM (SELECT MIN(id) AS id FROM afdian_review WHERE out_trade_no <> '' GROUP BY out_trade_no, reason) t)`,
+		m.d.createIndex("idx_afdian_review_dedupe", "afdian_review", "dedupe_trade_no, reason", true),
+	)
+}
+
+const latestVersionQuery = "SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1"
+
+// latestVersion 读取最新迁移版本，尚未迁移时为 0
+func latestVersion(row rowScanner) (int, error) {
+	var v int
+	if err := row.Scan(&v); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	return v, nil
+}
+
+// SchemaVersion 返回当前已应用的最新迁移版本
+func (s *Service) SchemaVersion() (int, error) {
+	return latestVersion(s.queryRow(latestVersionQuery))
+}
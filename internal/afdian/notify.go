@@ -0,0 +1,160 @@
+package afdian
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloudreve-afdianpay/internal/alert"
+)
+
+const (
+	// notifyAttempts 单次通知的最大尝试次数
+	notifyAttempts = 3
+	// notifyClaimTTL 通知认领的有效期，需覆盖一次通知的全部重试；实例崩溃后到期由其他实例接手
+	notifyClaimTTL = 2 * time.Minute
+)
+
+// ErrNotifyClaimed 订单已通知，或正由其他实例通知
+var ErrNotifyClaimed = errors.New("订单已通知或正在通知")
+
+// NotifyOrder 通知 Cloudreve 订单已支付，失败时指数退避重试，成功后记录 notified_at。
+// 通知前先认领订单，已通知或已被其他实例认领时返回 ErrNotifyClaimed，避免多实例重复发放
+func (s *Service) NotifyOrder(o *Order) error {
+	return s.notify(o, false)
+}
+
+// ResendNotify 重新通知订单，不论此前是否已通知成功（notify replay 指定订单号时使用）
+func (s *Service) ResendNotify(o *Order) error {
+	return s.notify(o, true)
+}
+
+func (s *Service) notify(o *Order, resend bool) error {
+	if o.NotifyURL == "" {
+		return errors.New("订单缺少 notify_url")
+	}
+	claimed, err := s.claimNotify(o.OrderNo, resend)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("[Notify] skip order_no=%s: already notified or claimed", o.OrderNo)
+		return ErrNotifyClaimed
+	}
+	var lastErr error
+	for attempt := 0; attempt < notifyAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<(attempt-1)) * time.Second)
+		}
+		if lastErr = notifyOnce(o.NotifyURL); lastErr == nil {
+			log.Printf("[Notify] ok order_no=%s", o.OrderNo)
+			return s.markNotified(o.OrderNo)
+		}
+		log.Printf("[Notify] order_no=%s attempt #%d failed: %v", o.OrderNo, attempt+1, lastErr)
+	}
+	s.releaseNotify(o.OrderNo)
+	s.Alerts.Record(alert.KindNotifyFailed, fmt.Sprintf("order_no=%s: %v", o.OrderNo, lastErr))
+	s.emitOrder(EventOrderNotifyFailed, o.OrderNo, lastErr.Error())
+	return lastErr
+}
+
+func notifyOnce(notifyURL string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(notifyURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status=%d", resp.StatusCode)
+	}
+	var r struct {
+		Code  int    `json:"code"`
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&r)
+	if r.Code != 0 {
+		return fmt.Errorf("code=%d error=%s", r.Code, r.Error)
+	}
+	return nil
+}
+
+func (s *Service) markNotified(orderNo string) error {
+	_, err := s.exec("UPDATE afdian_pay SET notified_at = ?, notify_claim_until = NULL WHERE site = ? AND order_no = ?", time.Now().Unix(), siteURL(), orderNo)
+	if err != nil {
+		log.Printf("[DB] mark notified error: %v", err)
+		return err
+	}
+	s.publishOrder(orderNo)
+	s.emitOrder(EventOrderNotified, orderNo, "")
+	return nil
+}
+
+// claimNotify 认领订单的通知任务；resend 为 false 时已通知的订单不可认领
+func (s *Service) claimNotify(orderNo string, resend bool) (bool, error) {
+	now := time.Now()
+	query := `UPDATE afdian_pay SET notify_claimed_by = ?, notify_claim_until = ?
+		WHERE site = ? AND order_no = ? AND status = ? AND (notify_claim_until IS NULL OR notify_claim_until < ?)`
+	if !resend {
+		query += " AND notified_at IS NULL"
+	}
+	res, err := s.exec(query, s.Instance, now.Add(notifyClaimTTL).Unix(), siteURL(), orderNo, StatusPaid, now.Unix())
+	if err != nil {
+		log.Printf("[DB] claim notify error: %v", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// releaseNotify 通知失败后释放认领，由通知任务稍后重试
+func (s *Service) releaseNotify(orderNo string) {
+	_, err := s.exec("UPDATE afdian_pay SET notify_claim_until = NULL WHERE site = ? AND order_no = ? AND notify_claimed_by = ?", siteURL(), orderNo, s.Instance)
+	if err != nil {
+		log.Printf("[DB] release notify claim error: %v", err)
+	}
+}
+
+// RunNotifier 周期性重试 window 内支付但尚未成功通知的订单，直到 ctx 结束。
+// 各实例可同时运行，订单逐个认领，不会重复通知
+func (s *Service) RunNotifier(ctx context.Context, interval, window time.Duration) {
+	if interval <= 0 {
+		log.Printf("[Notify] retry worker disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.notifyPending(ctx, window)
+	}
+}
+
+func (s *Service) notifyPending(ctx context.Context, window time.Duration) {
+	f := OrderFilter{Unnotified: true, Limit: 100}
+	if window > 0 {
+		f.ByPaidAt = true
+		f.Since = time.Now().Add(-window)
+	}
+	orders, err := s.ListOrders(f)
+	if err != nil {
+		log.Printf("[Notify] list pending error: %v", err)
+		return
+	}
+	for i := range orders {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.NotifyOrder(&orders[i]); err != nil && !errors.Is(err, ErrNotifyClaimed) {
+			log.Printf("[Notify] retry order_no=%s failed: %v", orders[i].OrderNo, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package afdian
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"cloudreve-afdianpay/internal/money"
+)
+
+// Plan 将 Cloudreve 商品映射到爱发电方案或售卖 SKU
+type Plan struct {
+	// Name 匹配 Cloudreve 下单时的商品名称，留空则不按名称匹配
+	Name string `json:"name"`
+	// Amount 匹配换算后的 CNY 金额（如 "5.00"），留空则不按金额匹配
+	Amount string `json:"amount"`
+	PlanID string `json:"plan_id"`
+	// SkuID 非空时生成售卖商品链接，否则生成赞助方案链接
+	SkuID string `json:"sku_id"`
+	Count int    `json:"count"`
+
+	amount *money.Amount
+}
+
+// SkuItem 爱发电订单中的 SKU
+type SkuItem struct {
+	SkuID string `json:"sku_id"`
+	Count int    `json:"count"`
+	Name  string `json:"name,omitempty"`
+}
+
+// LoadPlans 读取方案映射 JSON 文件，path 为空时返回空映射
+func LoadPlans(path string) ([]Plan, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plans []Plan
+	if err := json.Unmarshal(b, &plans); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	for i := range plans {
+		p := &plans[i]
+		if p.PlanID == "" {
+			return nil, fmt.Errorf("第 %d 个方案缺少 plan_id", i+1)
+		}
+		if p.Name == "" && p.Amount == "" {
+			return nil, fmt.Errorf("方案 %s 需要设置 name 或 amount", p.PlanID)
+		}
+		if p.Amount != "" {
+			a, err := money.Parse(p.Amount, money.CNY)
+			if err != nil {
+				return nil, fmt.Errorf("方案 %s 金额无效: %w", p.PlanID, err)
+			}
+			p.amount = &a
+		}
+	}
+	return plans, nil
+}
+
+func (p *Plan) count() int {
+	if p.SkuID == "" {
+		return 0
+	}
+	if p.Count <= 0 {
+		return 1
+	}
+	return p.Count
+}
+
+// matchPlan 先按商品名称匹配，再按金额匹配；名称与金额同时设置时两者都需满足
+func matchPlan(plans []Plan, name string, amount money.Amount) *Plan {
+	for i := range plans {
+		p := &plans[i]
+		if p.Name != "" && p.Name == name && (p.amount == nil || p.amount.Equal(amount)) {
+			return p
+		}
+	}
+	for i := range plans {
+		p := &plans[i]
+		if p.Name == "" && p.amount != nil && p.amount.Equal(amount) {
+			return p
+		}
+	}
+	return nil
+}
+
+// CheckoutURL 生成订单的爱发电下单链接，由支付页在访问时生成，链接规则变更对已创建的订单同样生效
+func (s *Service) CheckoutURL(o *Order) (string, error) {
+	userID := os.Getenv("USER_ID")
+	if userID == "" {
+		return "", errors.New("USER_ID 未设置")
+	}
+	return checkoutURL(userID, o), nil
+}
+
+// checkoutURL 生成爱发电下单链接，remark 携带订单号与校验码用于回调匹配
+func checkoutURL(userID string, o *Order) string {
+	if o.PlanID == "" {
+		return fmt.Sprintf("%s/order/create?user_id=%s&remark=%s&custom_price=%s", afdianBase(), userID, url.QueryEscape(FormatRemark(o.OrderNo)), o.Amount.String())
+	}
+	q := url.Values{}
+	q.Set("user_id", userID)
+	q.Set("plan_id", o.PlanID)
+	q.Set("remark", FormatRemark(o.OrderNo))
+	if o.SkuID != "" {
+		sku, _ := json.Marshal([]SkuItem{{SkuID: o.SkuID, Count: o.SkuCount}})
+		q.Set("product_type", "1")
+		q.Set("sku", string(sku))
+	} else {
+		q.Set("product_type", "0")
+		q.Set("month", "1")
+	}
+	return afdianBase() + "/order/create?" + q.Encode()
+}
+
+// matchesPlan 方案/SKU 订单需与爱发电返回的 plan_id 与 sku_detail 一致
+func matchesPlan(o *Order, it *apiOrder) bool {
+	if o.PlanID == "" {
+		return true
+	}
+	if it.PlanID != o.PlanID {
+		return false
+	}
+	if o.SkuID == "" {
+		return true
+	}
+	for _, sku := range it.SkuDetail {
+		if sku.SkuID == o.SkuID && sku.Count == o.SkuCount {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package afdian
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloudreve-afdianpay/internal/alert"
+	"cloudreve-afdianpay/internal/money"
+)
+
+// afdianStatusPaid 爱发电订单状态：交易成功
+const afdianStatusPaid = 2
+
+// ReconcileResult 一次对账的统计
+type ReconcileResult struct {
+	Scanned  int
+	Refunded int
+}
+
+// Reconcile 翻页拉取 lookback 时间内的爱发电订单，与本地已支付订单比对，
+// 爱发电侧不再是交易成功状态的视为已退款。
+func (s *Service) Reconcile(lookback time.Duration) (*ReconcileResult, error) {
+	since := time.Now().Add(-lookback).Unix()
+	res := &ReconcileResult{}
+	for page := 1; ; page++ {
+		result, _, err := queryOrders(map[string]interface{}{"page": page, "per_page": 100})
+		if err != nil {
+			return res, err
+		}
+		done := page >= result.TotalPage || len(result.List) == 0
+		for _, it := range result.List {
+			if it.CreateTime > 0 && it.CreateTime < since {
+				done = true
+				continue
+			}
+			res.Scanned++
+			if it.Status == afdianStatusPaid {
+				continue
+			}
+			o, err := s.orderByPayment(&it)
+			if err != nil {
+				return res, err
+			}
+			if o == nil || o.Status != StatusPaid {
+				continue
+			}
+			reason := fmt.Sprintf("爱发电订单 %s 状态为 %d", it.OutTradeNo, it.Status)
+			// 已人工登记过部分退款时只补登剩余金额
+			if _, err := s.RecordRefund(o.OrderNo, money.Amount{}, reason, RefundSourceReconcile, true); err != nil {
+				log.Printf("[Reconcile] record refund error order_no=%s: %v", o.OrderNo, err)
+				continue
+			}
+			res.Refunded++
+		}
+		if done {
+			break
+		}
+	}
+	log.Printf("[Reconcile] scanned=%d refunded=%d", res.Scanned, res.Refunded)
+	return res, nil
+}
+
+// RunReconciler 周期性对账，直到 ctx 结束；多实例部署时只有租约持有者执行
+func (s *Service) RunReconciler(ctx context.Context, interval, lookback time.Duration) {
+	if interval <= 0 {
+		log.Printf("[Reconcile] disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.isLeader() {
+			continue
+		}
+		if _, err := s.Reconcile(lookback); err != nil {
+			log.Printf("[Reconcile] error: %v", err)
+			s.Alerts.Record(alert.KindAPIError, "对账失败: "+err.Error())
+		}
+	}
+}
+
+// orderByPayment 按留言中的订单号或已记录的爱发电订单号查找本地订单
+func (s *Service) orderByPayment(it *apiOrder) (*Order, error) {
+	orderNo, ok := ParseRemark(it.Remark)
+	if !ok {
+		orderNo = strings.TrimSpace(it.Remark)
+	}
+	if orderNo != "" {
+		if o, err := s.GetOrder(orderNo); err != nil || o != nil {
+			return o, err
+		}
+	}
+	if it.OutTradeNo == "" {
+		return nil, nil
+	}
+	o, err := scanOrder(s.queryRow("SELECT "+orderColumns+" FROM afdian_pay WHERE site = ? AND out_trade_no = ?", siteURL(), it.OutTradeNo))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return o, err
+}
@@ -0,0 +1,188 @@
+package afdian
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"cloudreve-afdianpay/internal/money"
+	"cloudreve-afdianpay/internal/signature"
+)
+
+// 退款来源
+const (
+	RefundSourceAdmin     = "admin"
+	RefundSourceReconcile = "reconcile"
+)
+
+// 通知 Cloudreve 回收积分/容量包的结果
+const (
+	RevokeSkipped = "skipped"
+	RevokeOK      = "revoked"
+	RevokeFailed  = "failed"
+)
+
+var (
+	ErrOrderNotPaid      = errors.New("订单未支付，无法退款")
+	ErrAlreadyRefunded   = errors.New("订单已退款")
+	ErrRefundExceedsPaid = errors.New("退款金额超过订单剩余可退金额")
+)
+
+// Refund 退款记录
+type Refund struct {
+	ID           int64
+	Site         string
+	OrderNo      string
+	Amount       money.Amount
+	Reason       string
+	Source       string
+	RevokeStatus string
+	CreatedAt    time.Time
+}
+
+// RecordRefund 记录退款并累计到订单的退款总额，总额达到订单金额时订单置为已退款，
+// 此前的部分退款不改变订单状态。amount 为零值时退还剩余全部金额；
+// revoke 为 true 且配置了 REFUND_REVOKE_URL 时通知 Cloudreve 回收。
+func (s *Service) RecordRefund(orderNo string, amount money.Amount, reason, source string, revoke bool) (*Refund, error) {
+	o, err := s.GetOrder(orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, ErrOrderNotFound
+	}
+	switch o.Status {
+	case StatusPaid:
+	case StatusRefunded:
+		return nil, ErrAlreadyRefunded
+	default:
+		return nil, ErrOrderNotPaid
+	}
+	left := money.New(o.Amount.Minor-o.Refunded.Minor, o.Amount.Currency)
+	if amount.Minor == 0 {
+		amount = left
+	}
+	if cmp, err := amount.Cmp(left); err != nil {
+		return nil, err
+	} else if cmp > 0 || amount.Minor <= 0 {
+		return nil, ErrRefundExceedsPaid
+	}
+
+	r := &Refund{Site: o.Site, OrderNo: o.OrderNo, Amount: amount, Reason: reason, Source: source, RevokeStatus: RevokeSkipped, CreatedAt: time.Now()}
+	db, err := s.writer()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(s.rebind(refundQuery), amount.Minor, StatusRefunded, amount.Minor, amount.Minor, o.Site, o.OrderNo, StatusPaid, amount.Minor)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// 并发情况下已被其他请求处理：订单已全额退款，或剩余金额已不足
+		_ = tx.Rollback()
+		if cur, err := s.GetOrder(orderNo); err == nil && cur != nil && cur.Status == StatusRefunded {
+			return nil, ErrAlreadyRefunded
+		}
+		return nil, ErrRefundExceedsPaid
+	}
+	r.ID, err = s.d.insertID(tx, "INSERT INTO afdian_refund (site, order_no, amount_minor, currency, reason, source, revoke_status, created_at) VALUES (?,?,?,?,?,?,?,?)",
+		r.Site, r.OrderNo, r.Amount.Minor, string(r.Amount.Currency), r.Reason, r.Source, r.RevokeStatus, r.CreatedAt.Unix())
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Printf("[Refund] order_no=%s amount=%s source=%s reason=%q", r.OrderNo, r.Amount, r.Source, r.Reason)
+	s.publishOrder(r.OrderNo)
+
+	revokeURL := os.Getenv("REFUND_REVOKE_URL")
+	if !revoke || revokeURL == "" {
+		return r, nil
+	}
+	r.RevokeStatus = RevokeOK
+	if err := revokeOnCloudreve(revokeURL, r); err != nil {
+		log.Printf("[Refund] revoke error order_no=%s: %v", r.OrderNo, err)
+		r.RevokeStatus = RevokeFailed
+	}
+	if _, err := s.exec("UPDATE afdian_refund SET revoke_status = ? WHERE id = ?", r.RevokeStatus, r.ID); err != nil {
+		log.Printf("[DB] update revoke status error: %v", err)
+	}
+	return r, nil
+}
+
+// refundQuery 累加退款总额，达到订单金额时置为已退款并清除 is_paid；
+// 剩余金额不足时不更新。MySQL 按书写顺序赋值，refunded_minor 必须放在最后，
+// 前面的 CASE 才能读到累加前的值
+const refundQuery = `UPDATE afdian_pay SET
+	status = CASE WHEN refunded_minor + ? >= amount_minor THEN ? ELSE status END,
+	is_paid = CASE WHEN refunded_minor + ? >= amount_minor THEN 0 ELSE is_paid END,
+	refunded_minor = refunded_minor + ?
+	WHERE site = ? AND order_no = ? AND status = ? AND refunded_minor + ? <= amount_minor`
+
+// ListRefunds 查询订单的退款记录
+func (s *Service) ListRefunds(orderNo string) ([]Refund, error) {
+	rows, err := s.query("SELECT id, site, order_no, amount_minor, currency, reason, source, revoke_status, created_at FROM afdian_refund WHERE site = ? AND order_no = ? ORDER BY id", siteURL(), orderNo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Refund
+	for rows.Next() {
+		var r Refund
+		var currency string
+		var createdAt int64
+		if err := rows.Scan(&r.ID, &r.Site, &r.OrderNo, &r.Amount.Minor, &currency, &r.Reason, &r.Source, &r.RevokeStatus, &createdAt); err != nil {
+			return nil, err
+		}
+		r.Amount.Currency = money.Currency(currency)
+		r.CreatedAt = time.Unix(createdAt, 0)
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// revokeOnCloudreve 以 Cloudreve 通信签名 POST 退款信息，由站点侧回收已发放的积分或容量包
+func revokeOnCloudreve(revokeURL string, r *Refund) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"order_no": r.OrderNo,
+		"amount":   r.Amount.Minor,
+		"currency": string(r.Amount.Currency),
+		"reason":   r.Reason,
+	})
+	req, err := http.NewRequest(http.MethodPost, revokeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cr-Site-Url", r.Site)
+	signature.Sign(req, os.Getenv("COMMUNICATION_KEY"), time.Now().Add(5*time.Minute))
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Code  int    `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("status=%d: %w", resp.StatusCode, err)
+	}
+	if out.Code != 0 {
+		return fmt.Errorf("code=%d error=%s", out.Code, out.Error)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package afdian
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloudreve-afdianpay/internal/config"
+)
+
+// remarkSumLen 留言校验码长度（十六进制字符）
+const remarkSumLen = 6
+
+// remarkToken 留言中可能携带订单号的片段，赞助者在留言前后追加的文字会被忽略
+var remarkToken = regexp.MustCompile(`[0-9A-Za-z_.:-]+`)
+
+// remarkKey 校验码密钥，默认使用通信密钥
+func remarkKey() string {
+	if k := os.Getenv("REMARK_KEY"); k != "" {
+		return k
+	}
+	return os.Getenv("COMMUNICATION_KEY")
+}
+
+func remarkSum(orderNo string) string {
+	mac := hmac.New(sha256.New, []byte(remarkKey()))
+	mac.Write([]byte(siteURL() + "\n" + orderNo))
+	return hex.EncodeToString(mac.Sum(nil))[:remarkSumLen]
+}
+
+// FormatRemark 生成爱发电下单链接中的留言：订单号-校验码
+func FormatRemark(orderNo string) string {
+	return orderNo + "-" + remarkSum(orderNo)
+}
+
+// ParseRemark 从留言中找出校验通过的订单号
+func ParseRemark(remark string) (string, bool) {
+	for _, tok := range remarkToken.FindAllString(remark, -1) {
+		i := len(tok) - remarkSumLen - 1
+		if i <= 0 || tok[i] != '-' {
+			continue
+		}
+		orderNo, sum := tok[:i], strings.ToLower(tok[i+1:])
+		if hmac.Equal([]byte(sum), []byte(remarkSum(orderNo))) {
+			return orderNo, true
+		}
+	}
+	return "", false
+}
+
+// 留言匹配方式
+const (
+	MatchRemark    = "remark"
+	MatchLegacy    = "legacy"
+	MatchRecovered = "recovered"
+)
+
+// errAmbiguousPayment 留言无法识别且有多个金额相同的待支付订单
+var errAmbiguousPayment = errors.New("多个待支付订单金额相同")
+
+// resolveOrder 按爱发电留言定位本地订单：先识别带校验码的订单号，再按旧格式（纯订单号）精确匹配
+// （仅限迁移前创建、标记了 legacy_remark 的订单，新订单的留言必须带校验码），
+// 都失败时在支付时间前的窗口内（放宽 1 分钟时钟偏差）找金额相同的唯一待支付订单。未找到时返回 nil
+func (s *Service) resolveOrder(remark string, p *Payment) (*Order, string, error) {
+	if orderNo, ok := ParseRemark(remark); ok {
+		o, err := s.GetOrder(orderNo)
+		return o, MatchRemark, err
+	}
+	if orderNo := strings.TrimSpace(remark); orderNo != "" {
+		o, err := scanOrder(s.queryRow("SELECT "+orderColumns+" FROM afdian_pay WHERE site = ? AND order_no = ? AND legacy_remark = 1", siteURL(), orderNo))
+		if errors.Is(err, sql.ErrNoRows) {
+			o, err = nil, nil
+		}
+		if err != nil || o != nil {
+			return o, MatchLegacy, err
+		}
+	}
+	paidAt := p.PaidTime
+	if paidAt.IsZero() {
+		paidAt = time.Now()
+	}
+	window := config.Duration("REMARK_MATCH_WINDOW", 2*time.Hour)
+	if window <= 0 {
+		return nil, "", nil
+	}
+	rows, err := s.query("SELECT "+orderColumns+" FROM afdian_pay WHERE site = ? AND status = ? AND amount_minor = ? AND currency = ? AND created_at BETWEEN ? AND ? LIMIT 2",
+		siteURL(), StatusPending, p.Amount.Minor, string(p.Amount.Currency), paidAt.Add(-window).Unix(), paidAt.Add(time.Minute).Unix())
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var found []*Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		found = append(found, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	switch len(found) {
+	case 0:
+		return nil, "", nil
+	case 1:
+		log.Printf("[CheckOrder] remark %q recovered to order_no=%s by amount=%s", remark, found[0].OrderNo, p.Amount)
+		return found[0], MatchRecovered, nil
+	}
+	log.Printf("[CheckOrder] remark %q ambiguous: multiple pending orders with amount=%s", remark, p.Amount)
+	return nil, "", errAmbiguousPayment
+}
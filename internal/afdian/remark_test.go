@@ -0,0 +1,43 @@
+package afdian
+
+import "testing"
+
+func TestRemarkRoundTrip(t *testing.T) {
+	t.Setenv("SITE_URL", "https://remark.example.com")
+	t.Setenv("COMMUNICATION_KEY", "key")
+	remark := FormatRemark("20240101-42")
+	cases := []struct {
+		remark string
+		want   string
+		ok     bool
+	}{
+		{remark, "20240101-42", true},
+		{"谢谢！" + remark + " 加油", "20240101-42", true},
+		{"  " + remark + "\n", "20240101-42", true},
+		{"20240101-42", "", false},
+		{"20240101-43" + remark[len("20240101-42"):], "", false},
+		{remark[:len(remark)-1], "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, ok := ParseRemark(c.remark)
+		if got != c.want || ok != c.ok {
+			t.Errorf("ParseRemark(%q) = %q, %v; want %q, %v", c.remark, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestRemarkKeyedPerSite(t *testing.T) {
+	t.Setenv("COMMUNICATION_KEY", "key")
+	t.Setenv("SITE_URL", "https://a.example.com")
+	remark := FormatRemark("1001")
+	t.Setenv("SITE_URL", "https://b.example.com")
+	if _, ok := ParseRemark(remark); ok {
+		t.Error("remark from another site accepted")
+	}
+	t.Setenv("REMARK_KEY", "other")
+	t.Setenv("SITE_URL", "https://a.example.com")
+	if _, ok := ParseRemark(remark); ok {
+		t.Error("remark accepted after REMARK_KEY changed")
+	}
+}
@@ -0,0 +1,260 @@
+package afdian
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"cloudreve-afdianpay/internal/money"
+)
+
+// 待人工复核的原因
+const (
+	ReviewOrderExpired = "order_expired"
+	// ReviewRemarkUnmatched 留言被修改且找不到金额、时间相符的待支付订单
+	ReviewRemarkUnmatched = "remark_unmatched"
+	// ReviewRemarkAmbiguous 留言被修改且有多个金额相同的待支付订单
+	ReviewRemarkAmbiguous = "remark_ambiguous"
+	// ReviewAmountMismatch 爱发电实付金额与订单金额不一致
+	ReviewAmountMismatch = "amount_mismatch"
+	// ReviewPlanMismatch 爱发电方案/SKU 与订单不一致
+	ReviewPlanMismatch = "plan_mismatch"
+	// ReviewNotPaid 爱发电订单不是交易成功状态（未完成或已退款）
+	ReviewNotPaid = "not_paid"
+)
+
+// 复核状态
+const (
+	ReviewOpen      = "open"
+	ReviewAttached  = "attached"
+	ReviewDismissed = "dismissed"
+)
+
+var (
+	ErrReviewNotFound = errors.New("复核记录不存在")
+	ErrReviewResolved = errors.New("复核记录已处理")
+	// ErrReviewAmountMismatch 付款金额与目标订单不一致，需确认后强制关联
+	ErrReviewAmountMismatch = errors.New("付款金额与订单金额不一致")
+	// ErrReviewNotPaid 爱发电订单未交易成功，不能关联到订单
+	ErrReviewNotPaid = errors.New("爱发电订单未交易成功，不能关联")
+	// ErrOrderAlreadyPaid 目标订单已通过其他付款入账
+	ErrOrderAlreadyPaid = errors.New("订单已支付")
+)
+
+// maxReviewRemark 复核记录中保留的留言与备注长度上限
+const maxReviewRemark = 200
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+// ReviewItem 无法自动入账、需要人工处理的付款
+type ReviewItem struct {
+	ID         int64
+	OrderNo    string
+	OutTradeNo string
+	Amount     money.Amount
+	// Remark 爱发电订单的原始留言
+	Remark    string
+	Reason    string
+	CreatedAt time.Time
+	// Status 处理状态，Note 为处理备注，ResolvedAt 为零值表示未处理
+	Status     string
+	Note       string
+	ResolvedAt time.Time
+}
+
+// AddReview 写入复核队列
+func (s *Service) AddReview(it ReviewItem) error {
+	currency := it.Amount.Currency
+	if currency == "" {
+		currency = money.CNY
+	}
+	// 爱发电会重复（甚至并发）回调，同一笔付款同一原因只入队一次，由唯一索引去重
+	var dedupe interface{}
+	if it.OutTradeNo != "" {
+		dedupe = it.OutTradeNo
+	}
+	db, err := s.writer()
+	if err != nil {
+		return err
+	}
+	remark := truncateRunes(it.Remark, maxReviewRemark)
+	id, inserted, err := s.d.insertIgnoreID(db, "INSERT INTO afdian_review (order_no, out_trade_no, dedupe_trade_no, amount_minor, currency, remark, reason, created_at) VALUES (?,?,?,?,?,?,?,?)",
+		"dedupe_trade_no, reason", it.OrderNo, it.OutTradeNo, dedupe, it.Amount.Minor, string(currency), remark, it.Reason, time.Now().Unix())
+	if err != nil {
+		log.Printf("[DB] add review error: %v", err)
+		return err
+	}
+	if !inserted {
+		return nil
+	}
+	log.Printf("[Review] queued order_no=%s out_trade_no=%s amount=%s reason=%s", it.OrderNo, it.OutTradeNo, it.Amount, it.Reason)
+	s.emit(EventPaymentUnmatched, &PaymentEventData{ReviewID: id, OutTradeNo: it.OutTradeNo, OrderNo: it.OrderNo,
+		Amount: it.Amount.String(), Currency: string(currency), Remark: remark, Reason: it.Reason})
+	return nil
+}
+
+const reviewColumns = "id, order_no, out_trade_no, amount_minor, currency, remark, reason, created_at, status, note, resolved_at"
+
+func scanReview(row rowScanner) (*ReviewItem, error) {
+	var it ReviewItem
+	var currency string
+	var createdAt int64
+	var resolvedAt sql.NullInt64
+	if err := row.Scan(&it.ID, &it.OrderNo, &it.OutTradeNo, &it.Amount.Minor, &currency, &it.Remark, &it.Reason, &createdAt, &it.Status, &it.Note, &resolvedAt); err != nil {
+		return nil, err
+	}
+	it.Amount.Currency = money.Currency(currency)
+	it.CreatedAt = time.Unix(createdAt, 0)
+	it.ResolvedAt = unixOrZero(resolvedAt)
+	return &it, nil
+}
+
+// ListReviews 按状态查询复核队列（status 为空时返回全部），按时间倒序
+func (s *Service) ListReviews(status string, limit int) ([]ReviewItem, error) {
+	query := "SELECT " + reviewColumns + " FROM afdian_review"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []ReviewItem
+	for rows.Next() {
+		it, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *it)
+	}
+	return list, rows.Err()
+}
+
+// GetReview 查询复核记录，不存在时返回 ErrReviewNotFound
+func (s *Service) GetReview(id int64) (*ReviewItem, error) {
+	it, err := scanReview(s.queryRow("SELECT "+reviewColumns+" FROM afdian_review WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReviewNotFound
+	}
+	return it, err
+}
+
+// resolveReview 将待处理的复核记录置为 status，已被处理时返回 ErrReviewResolved
+func (s *Service) resolveReview(id int64, status, orderNo, note string) error {
+	query := "UPDATE afdian_review SET status = ?, note = ?, resolved_at = ?"
+	args := []interface{}{status, truncateRunes(note, maxReviewRemark), time.Now().Unix()}
+	if orderNo != "" {
+		query += ", order_no = ?"
+		args = append(args, orderNo)
+	}
+	res, err := s.exec(query+" WHERE id = ? AND status = ?", append(args, id, ReviewOpen)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := s.GetReview(id); err != nil {
+			return err
+		}
+		return ErrReviewResolved
+	}
+	return nil
+}
+
+// DismissReview 忽略复核记录（如已线下退款）
+func (s *Service) DismissReview(id int64, note string) error {
+	if err := s.resolveReview(id, ReviewDismissed, "", note); err != nil {
+		return err
+	}
+	log.Printf("[Review] dismissed id=%d note=%q", id, note)
+	return nil
+}
+
+// AttachReview 将复核中的付款关联到订单：标记订单已支付（已过期的订单同样入账）并通知 Cloudreve。
+// 付款金额与订单金额不一致时需 force；返回通知错误时订单已入账，可稍后 notify replay
+func (s *Service) AttachReview(id int64, orderNo string, force bool, note string) (*Order, error) {
+	it, err := s.GetReview(id)
+	if err != nil {
+		return nil, err
+	}
+	if it.Status != ReviewOpen {
+		return nil, ErrReviewResolved
+	}
+	if it.Reason == ReviewNotPaid {
+		return nil, ErrReviewNotPaid
+	}
+	o, err := s.GetOrder(orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, ErrOrderNotFound
+	}
+	switch o.Status {
+	case StatusPending, StatusExpired:
+	case StatusRefunded:
+		return nil, ErrAlreadyRefunded
+	default:
+		return nil, ErrOrderAlreadyPaid
+	}
+	if !force && !o.Amount.Equal(it.Amount) {
+		return nil, ErrReviewAmountMismatch
+	}
+	// 先占用复核记录，避免并发重复关联
+	if err := s.resolveReview(id, ReviewAttached, orderNo, note); err != nil {
+		return nil, err
+	}
+	p := s.reviewPayment(it)
+	if err := s.markPaid(orderNo, p, o.Status); err != nil {
+		if _, rerr := s.exec("UPDATE afdian_review SET status = ?, resolved_at = NULL WHERE id = ?", ReviewOpen, id); rerr != nil {
+			log.Printf("[DB] reopen review id=%d error: %v", id, rerr)
+		}
+		return nil, err
+	}
+	log.Printf("[Review] attached id=%d out_trade_no=%s to order_no=%s force=%v", id, it.OutTradeNo, orderNo, force)
+	if o, err = s.GetOrder(orderNo); err != nil {
+		return nil, err
+	}
+	nerr := s.NotifyOrder(o)
+	if fresh, err := s.GetOrder(orderNo); err == nil && fresh != nil {
+		o = fresh
+	}
+	return o, nerr
+}
+
+// reviewPayment 以爱发电 API 为准补全付款信息，查询失败时使用复核记录中的数据
+func (s *Service) reviewPayment(it *ReviewItem) *Payment {
+	p := &Payment{OutTradeNo: it.OutTradeNo, Remark: it.Remark, Amount: it.Amount}
+	if it.OutTradeNo == "" {
+		return p
+	}
+	api, total, _, err := s.apiCheck(it.OutTradeNo)
+	if err != nil || api == nil {
+		log.Printf("[Review] query payment %s failed, using queued data: %v", it.OutTradeNo, err)
+		return p
+	}
+	p.Amount = total
+	p.SponsorUserID = api.UserID
+	p.PlanID = api.PlanID
+	if api.CreateTime > 0 {
+		p.PaidTime = time.Unix(api.CreateTime, 0)
+	}
+	if name, err := querySponsorName(api.UserID); err == nil {
+		p.SponsorName = name
+	}
+	return p
+}
@@ -0,0 +1,96 @@
+package afdian
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SweeperConfig 过期清理任务配置
+type SweeperConfig struct {
+	// TTL 未支付订单的有效期
+	TTL time.Duration
+	// Interval 扫描间隔
+	Interval time.Duration
+	// Retention 已过期订单的保留时长，为 0 时不删除
+	Retention time.Duration
+	// JournalRetention 回调记录的保留时长，为 0 时不删除
+	JournalRetention time.Duration
+	// WebhookRetention 已送达或已失败的事件推送记录的保留时长，为 0 时不删除
+	WebhookRetention time.Duration
+}
+
+// ExpireStale 将创建时间早于 now-ttl 的未支付订单标记为已过期
+func (s *Service) ExpireStale(ttl time.Duration) (int64, error) {
+	now := time.Now()
+	res, err := s.exec("UPDATE afdian_pay SET status = ?, expired_at = ? WHERE status = ? AND created_at < ?",
+		StatusExpired, now.Unix(), StatusPending, now.Add(-ttl).Unix())
+	if err != nil {
+		log.Printf("[DB] expire stale error: %v", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeExpired 删除过期时间早于 now-retention 的订单
+func (s *Service) PurgeExpired(retention time.Duration) (int64, error) {
+	res, err := s.exec("DELETE FROM afdian_pay WHERE status = ? AND expired_at < ?",
+		StatusExpired, time.Now().Add(-retention).Unix())
+	if err != nil {
+		log.Printf("[DB] purge expired error: %v", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunSweeper 周期性过期与清理订单，直到 ctx 结束；多实例部署时只有租约持有者执行
+func (s *Service) RunSweeper(ctx context.Context, cfg SweeperConfig) {
+	if cfg.TTL <= 0 || cfg.Interval <= 0 {
+		log.Printf("[Sweeper] disabled ttl=%s interval=%s", cfg.TTL, cfg.Interval)
+		return
+	}
+	log.Printf("[Sweeper] started ttl=%s interval=%s retention=%s", cfg.TTL, cfg.Interval, cfg.Retention)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		s.sweepOnce(cfg)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) sweepOnce(cfg SweeperConfig) {
+	if !s.isLeader() {
+		return
+	}
+	if n, err := s.ExpireStale(cfg.TTL); err != nil {
+		log.Printf("[Sweeper] expire error: %v", err)
+	} else if n > 0 {
+		log.Printf("[Sweeper] expired %d orders", n)
+		s.publishWatched()
+	}
+	if cfg.Retention > 0 {
+		if n, err := s.PurgeExpired(cfg.Retention); err != nil {
+			log.Printf("[Sweeper] purge error: %v", err)
+		} else if n > 0 {
+			log.Printf("[Sweeper] purged %d expired orders", n)
+		}
+	}
+	if cfg.JournalRetention > 0 {
+		if n, err := s.PurgeJournal(cfg.JournalRetention); err != nil {
+			log.Printf("[Sweeper] purge journal error: %v", err)
+		} else if n > 0 {
+			log.Printf("[Sweeper] purged %d journal entries", n)
+		}
+	}
+	if cfg.WebhookRetention > 0 {
+		if n, err := s.PurgeWebhookDeliveries(cfg.WebhookRetention); err != nil {
+			log.Printf("[Sweeper] purge webhooks error: %v", err)
+		} else if n > 0 {
+			log.Printf("[Sweeper] purged %d webhook deliveries", n)
+		}
+	}
+}
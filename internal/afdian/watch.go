@@ -0,0 +1,82 @@
+package afdian
+
+import (
+	"log"
+	"time"
+)
+
+// OrderUpdate 订单状态，状态变化时推送给 WatchOrder 的订阅者
+type OrderUpdate struct {
+	OrderNo string `json:"order_no"`
+	Status  string `json:"status"`
+	// Notified 已成功通知 Cloudreve
+	Notified bool  `json:"notified"`
+	At       int64 `json:"at"`
+}
+
+// NewOrderUpdate 由订单当前状态生成推送内容
+func NewOrderUpdate(o *Order) OrderUpdate {
+	return OrderUpdate{OrderNo: o.OrderNo, Status: o.Status, Notified: !o.NotifiedAt.IsZero(), At: time.Now().Unix()}
+}
+
+// WatchOrder 订阅订单状态变化，返回的通道只保留最新一次状态；调用 cancel 取消订阅。
+// 订阅在进程内，多实例部署时其他实例处理的回调不会推送到这里，调用方应定期重新查询
+func (s *Service) WatchOrder(orderNo string) (<-chan OrderUpdate, func()) {
+	ch := make(chan OrderUpdate, 1)
+	s.watchMu.Lock()
+	if s.watchers == nil {
+		s.watchers = make(map[string]map[chan OrderUpdate]struct{})
+	}
+	if s.watchers[orderNo] == nil {
+		s.watchers[orderNo] = make(map[chan OrderUpdate]struct{})
+	}
+	s.watchers[orderNo][ch] = struct{}{}
+	s.watchMu.Unlock()
+	return ch, func() {
+		s.watchMu.Lock()
+		delete(s.watchers[orderNo], ch)
+		if len(s.watchers[orderNo]) == 0 {
+			delete(s.watchers, orderNo)
+		}
+		s.watchMu.Unlock()
+	}
+}
+
+// publishOrder 订单状态变化后重新读取并推送给订阅者，没有订阅者时不查询
+func (s *Service) publishOrder(orderNo string) {
+	s.watchMu.Lock()
+	n := len(s.watchers[orderNo])
+	s.watchMu.Unlock()
+	if n == 0 {
+		return
+	}
+	o, err := s.GetOrder(orderNo)
+	if err != nil || o == nil {
+		log.Printf("[Watch] load order %s error: %v", orderNo, err)
+		return
+	}
+	u := NewOrderUpdate(o)
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for ch := range s.watchers[orderNo] {
+		// 订阅者来不及读取时用最新状态替换旧状态
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- u
+	}
+}
+
+// publishWatched 批量更新（如过期清理）后推送所有被订阅的订单
+func (s *Service) publishWatched() {
+	s.watchMu.Lock()
+	orders := make([]string, 0, len(s.watchers))
+	for orderNo := range s.watchers {
+		orders = append(orders, orderNo)
+	}
+	s.watchMu.Unlock()
+	for _, orderNo := range orders {
+		s.publishOrder(orderNo)
+	}
+}
@@ -0,0 +1,614 @@
+package afdian
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudreve-afdianpay/internal/alert"
+)
+
+// 对外推送的事件类型
+const (
+	EventOrderCreated      = "order.created"
+	EventOrderPaid         = "order.paid"
+	EventOrderNotified     = "order.notified"
+	EventOrderNotifyFailed = "order.notify_failed"
+	EventPaymentUnmatched  = "payment.unmatched"
+)
+
+// EventTypes 全部事件类型
+var EventTypes = []string{EventOrderCreated, EventOrderPaid, EventOrderNotified, EventOrderNotifyFailed, EventPaymentUnmatched}
+
+// 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// webhookMaxAttempts 超过后投递标记为 failed，可通过管理接口或命令行重试
+	webhookMaxAttempts = 10
+	// webhookBackoff 首次重试间隔，之后逐次翻倍，最长 webhookMaxBackoff
+	webhookBackoff    = 30 * time.Second
+	webhookMaxBackoff = time.Hour
+	webhookClaimTTL   = time.Minute
+	webhookBatch      = 50
+	// webhookWorkers 同时投递的订阅者数上限，同一订阅者的事件按顺序逐条发送
+	webhookWorkers = 4
+)
+
+var ErrDeliveryNotFound = errors.New("投递记录不存在")
+
+// Subscriber 事件订阅者，每个订阅者独立投递与重试
+type Subscriber struct {
+	// Name 订阅者标识，投递记录按此关联，修改后未完成的投递会失败
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret 签名密钥，见 SignWebhook
+	Secret string `json:"secret"`
+	// Events 订阅的事件类型，为空表示全部
+	Events []string `json:"events"`
+}
+
+func (sub *Subscriber) wants(typ string) bool {
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, e := range sub.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSubscribers 读取事件订阅 JSON 文件，path 为空时返回空列表
+func LoadSubscribers(path string) ([]Subscriber, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subscriber
+	if err := json.Unmarshal(b, &subs); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	known := make(map[string]bool, len(EventTypes))
+	for _, t := range EventTypes {
+		known[t] = true
+	}
+	names := make(map[string]bool, len(subs))
+	for i := range subs {
+		sub := &subs[i]
+		if sub.Name == "" || sub.URL == "" {
+			return nil, fmt.Errorf("第 %d 个订阅者缺少 name 或 url", i+1)
+		}
+		if names[sub.Name] {
+			return nil, fmt.Errorf("订阅者 %s 重复", sub.Name)
+		}
+		names[sub.Name] = true
+		for _, e := range sub.Events {
+			if !known[e] {
+				return nil, fmt.Errorf("订阅者 %s 的事件类型 %q 无效", sub.Name, e)
+			}
+		}
+	}
+	return subs, nil
+}
+
+// Event 推送给订阅者的事件，同一事件发给各订阅者时 ID 相同，可用于去重
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Site      string      `json:"site"`
+	CreatedAt int64       `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// OrderEventData 订单事件数据
+type OrderEventData struct {
+	OrderNo          string `json:"order_no"`
+	Status           string `json:"status"`
+	Amount           string `json:"amount"`
+	Currency         string `json:"currency"`
+	OriginalAmount   string `json:"original_amount"`
+	OriginalCurrency string `json:"original_currency"`
+	PlanID           string `json:"plan_id,omitempty"`
+	OutTradeNo       string `json:"out_trade_no,omitempty"`
+	SponsorUserID    string `json:"sponsor_user_id,omitempty"`
+	SponsorName      string `json:"sponsor_name,omitempty"`
+	CreatedAt        int64  `json:"created_at"`
+	PaidAt           int64  `json:"paid_at,omitempty"`
+	NotifiedAt       int64  `json:"notified_at,omitempty"`
+	// Error 通知 Cloudreve 失败的原因，仅 order.notify_failed
+	Error string `json:"error,omitempty"`
+}
+
+func orderEventData(o *Order) *OrderEventData {
+	d := &OrderEventData{
+		OrderNo:          o.OrderNo,
+		Status:           o.Status,
+		Amount:           o.Amount.String(),
+		Currency:         string(o.Amount.Currency),
+		OriginalAmount:   o.Original.String(),
+		OriginalCurrency: string(o.Original.Currency),
+		PlanID:           o.PlanID,
+		OutTradeNo:       o.OutTradeNo,
+		SponsorUserID:    o.SponsorUserID,
+		SponsorName:      o.SponsorName,
+		CreatedAt:        o.CreatedAt.Unix(),
+	}
+	if !o.PaidAt.IsZero() {
+		d.PaidAt = o.PaidAt.Unix()
+	}
+	if !o.NotifiedAt.IsZero() {
+		d.NotifiedAt = o.NotifiedAt.Unix()
+	}
+	return d
+}
+
+// PaymentEventData payment.unmatched 事件数据
+type PaymentEventData struct {
+	ReviewID   int64  `json:"review_id"`
+	OutTradeNo string `json:"out_trade_no"`
+	OrderNo    string `json:"order_no,omitempty"`
+	Amount     string `json:"amount"`
+	Currency   string `json:"currency"`
+	Remark     string `json:"remark"`
+	Reason     string `json:"reason"`
+}
+
+// emitOrder 重新读取订单后推送事件，保证数据为最新状态
+func (s *Service) emitOrder(typ, orderNo string, errMsg string) {
+	if len(s.Subscribers) == 0 {
+		return
+	}
+	o, err := s.GetOrder(orderNo)
+	if err != nil || o == nil {
+		log.Printf("[Webhook] emit %s order_no=%s: load order error: %v", typ, orderNo, err)
+		return
+	}
+	d := orderEventData(o)
+	d.Error = errMsg
+	s.emit(typ, d)
+}
+
+// emit 为每个订阅该事件的订阅者写入一条待投递记录，由 RunWebhooks 发送；写入失败只记录日志
+func (s *Service) emit(typ string, data interface{}) {
+	if len(s.Subscribers) == 0 {
+		return
+	}
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	ev := Event{ID: "evt_" + hex.EncodeToString(id), Type: typ, Site: siteURL(), CreatedAt: time.Now().Unix(), Data: data}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[Webhook] marshal %s error: %v", typ, err)
+		return
+	}
+	queued := 0
+	for i := range s.Subscribers {
+		sub := &s.Subscribers[i]
+		if !sub.wants(typ) {
+			continue
+		}
+		_, err := s.exec(`INSERT INTO afdian_webhook (subscriber, event_id, event_type, payload, status, attempts, next_attempt_at, last_error, created_at)
+			VALUES (?,?,?,?,?,0,?,'',?)`, sub.Name, ev.ID, typ, string(payload), DeliveryPending, ev.CreatedAt, ev.CreatedAt)
+		if err != nil {
+			log.Printf("[Webhook] queue %s for %s error: %v", typ, sub.Name, err)
+			continue
+		}
+		queued++
+	}
+	if queued > 0 {
+		s.kickWebhooks()
+	}
+}
+
+func (s *Service) kickWebhooks() {
+	s.mu.Lock()
+	if s.webhookKick == nil {
+		s.webhookKick = make(chan struct{}, 1)
+	}
+	ch := s.webhookKick
+	s.mu.Unlock()
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// RunWebhooks 投递待发送的事件，新事件写入后立即触发，否则每 interval 扫描一次到期的重试。
+// 各订阅者在后台并发投递，不等待慢的订阅者完成
+func (s *Service) RunWebhooks(ctx context.Context, interval time.Duration) {
+	if len(s.Subscribers) == 0 || interval <= 0 {
+		log.Printf("[Webhook] disabled")
+		return
+	}
+	s.mu.Lock()
+	if s.webhookKick == nil {
+		s.webhookKick = make(chan struct{}, 1)
+	}
+	kick := s.webhookKick
+	s.mu.Unlock()
+	log.Printf("[Webhook] started: subscribers=%d interval=%s", len(s.Subscribers), interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.dispatchWebhooks(ctx, false)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-kick:
+		}
+	}
+}
+
+// DispatchWebhooks 发送所有到期的待投递记录并等待完成，多实例时逐条认领，返回本次发送的条数
+func (s *Service) DispatchWebhooks(ctx context.Context) int {
+	return s.dispatchWebhooks(ctx, true)
+}
+
+// dispatchWebhooks 为每个有到期记录、且没有正在投递的订阅者启动一个投递协程，
+// 最多 webhookWorkers 个同时运行；wait 为 true 时等待全部完成并返回发送条数
+func (s *Service) dispatchWebhooks(ctx context.Context, wait bool) int {
+	now := time.Now().Unix()
+	rows, err := s.query("SELECT DISTINCT subscriber FROM afdian_webhook WHERE status = ? AND next_attempt_at <= ? AND (claim_until IS NULL OR claim_until < ?)",
+		DeliveryPending, now, now)
+	if err != nil {
+		log.Printf("[Webhook] query pending error: %v", err)
+		return 0
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Printf("[Webhook] scan error: %v", err)
+			break
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	s.mu.Lock()
+	if s.webhookBusy == nil {
+		s.webhookBusy = make(map[string]bool)
+		s.webhookSem = make(chan struct{}, webhookWorkers)
+	}
+	sem := s.webhookSem
+	s.mu.Unlock()
+	var sent int64
+	var wg sync.WaitGroup
+	for _, name := range names {
+		s.mu.Lock()
+		busy := s.webhookBusy[name]
+		s.webhookBusy[name] = true
+		s.mu.Unlock()
+		if busy {
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.webhookBusy, name)
+				s.mu.Unlock()
+			}()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			atomic.AddInt64(&sent, int64(s.dispatchSubscriber(ctx, name)))
+		}(name)
+	}
+	if !wait {
+		return 0
+	}
+	wg.Wait()
+	return int(sent)
+}
+
+// dispatchSubscriber 按顺序发送一个订阅者到期的记录，直到没有可认领的记录
+func (s *Service) dispatchSubscriber(ctx context.Context, name string) int {
+	sent := 0
+	for ctx.Err() == nil {
+		now := time.Now().Unix()
+		rows, err := s.query("SELECT "+deliveryColumns+" FROM afdian_webhook WHERE subscriber = ? AND status = ? AND next_attempt_at <= ? AND (claim_until IS NULL OR claim_until < ?) ORDER BY id LIMIT ?",
+			name, DeliveryPending, now, now, webhookBatch)
+		if err != nil {
+			log.Printf("[Webhook] query pending error: %v", err)
+			return sent
+		}
+		var due []*WebhookDelivery
+		for rows.Next() {
+			d, err := scanDelivery(rows)
+			if err != nil {
+				log.Printf("[Webhook] scan error: %v", err)
+				break
+			}
+			due = append(due, d)
+		}
+		rows.Close()
+		claimed := 0
+		for _, d := range due {
+			if ctx.Err() != nil {
+				return sent
+			}
+			until, ok, err := s.claimDelivery(d.ID)
+			if err != nil {
+				// 数据库异常时结束本轮，等待下一次扫描，避免对同一批记录反复认领
+				return sent
+			}
+			if !ok {
+				continue
+			}
+			claimed++
+			s.deliver(ctx, d, until)
+			sent++
+		}
+		// 本批记录都已被其他实例认领时结束，避免空转
+		if claimed == 0 {
+			return sent
+		}
+	}
+	return sent
+}
+
+// PurgeWebhookDeliveries 删除创建时间早于 now-retention 的已送达与已失败投递记录，待投递的记录保留
+func (s *Service) PurgeWebhookDeliveries(retention time.Duration) (int64, error) {
+	res, err := s.exec("DELETE FROM afdian_webhook WHERE status IN (?, ?) AND created_at < ?",
+		DeliveryDelivered, DeliveryFailed, time.Now().Add(-retention).Unix())
+	if err != nil {
+		log.Printf("[DB] purge webhook deliveries error: %v", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// claimDelivery 认领一条待投递记录，返回认领到期时间，记录结果时以它和实例名确认认领仍然有效
+func (s *Service) claimDelivery(id int64) (int64, bool, error) {
+	now := time.Now()
+	until := now.Add(webhookClaimTTL).Unix()
+	res, err := s.exec("UPDATE afdian_webhook SET claimed_by = ?, claim_until = ? WHERE id = ? AND status = ? AND (claim_until IS NULL OR claim_until < ?)",
+		s.Instance, until, id, DeliveryPending, now.Unix())
+	if err != nil {
+		log.Printf("[DB] claim webhook error: %v", err)
+		return 0, false, err
+	}
+	n, _ := res.RowsAffected()
+	return until, n > 0, nil
+}
+
+func (s *Service) subscriber(name string) *Subscriber {
+	for i := range s.Subscribers {
+		if s.Subscribers[i].Name == name {
+			return &s.Subscribers[i]
+		}
+	}
+	return nil
+}
+
+// deliver 发送一次并记录结果：成功为 delivered，失败按退避安排重试，超过次数为 failed。
+// 认领已过期并被其他实例接手时不写入结果，避免重复计数
+func (s *Service) deliver(ctx context.Context, d *WebhookDelivery, claimUntil int64) {
+	d.Attempts++
+	var status int
+	var err error
+	sub := s.subscriber(d.Subscriber)
+	if sub == nil {
+		err = errors.New("订阅者已移除")
+		d.Attempts = webhookMaxAttempts
+	} else {
+		status, err = postWebhook(ctx, sub, d)
+	}
+	now := time.Now()
+	if err == nil {
+		res, err := s.exec("UPDATE afdian_webhook SET status = ?, attempts = ?, last_status = ?, last_error = '', delivered_at = ?, claim_until = NULL WHERE id = ? AND claimed_by = ? AND claim_until = ?",
+			DeliveryDelivered, d.Attempts, status, now.Unix(), d.ID, s.Instance, claimUntil)
+		if err != nil {
+			log.Printf("[DB] mark webhook delivered error: %v", err)
+		} else if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("[Webhook] claim lost id=%d, result not recorded", d.ID)
+		}
+		log.Printf("[Webhook] delivered id=%d %s to %s", d.ID, d.EventType, d.Subscriber)
+		return
+	}
+	next := d.NextAttemptAt
+	state := DeliveryPending
+	if d.Attempts >= webhookMaxAttempts {
+		state = DeliveryFailed
+		s.Alerts.Record(alert.KindWebhookFailed, fmt.Sprintf("%s 推送到 %s 失败 %d 次: %v", d.EventType, d.Subscriber, d.Attempts, err))
+	} else {
+		next = now.Add(webhookRetryDelay(d.Attempts))
+	}
+	log.Printf("[Webhook] id=%d %s to %s attempt #%d failed: %v", d.ID, d.EventType, d.Subscriber, d.Attempts, err)
+	res, uerr := s.exec("UPDATE afdian_webhook SET status = ?, attempts = ?, last_status = ?, last_error = ?, next_attempt_at = ?, claim_until = NULL WHERE id = ? AND claimed_by = ? AND claim_until = ?",
+		state, d.Attempts, status, truncateRunes(err.Error(), maxReviewRemark), next.Unix(), d.ID, s.Instance, claimUntil)
+	if uerr != nil {
+		log.Printf("[DB] update webhook error: %v", uerr)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("[Webhook] claim lost id=%d, result not recorded", d.ID)
+	}
+}
+
+func webhookRetryDelay(attempts int) time.Duration {
+	d := webhookBackoff
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+// SignWebhook 计算事件签名：hex(HMAC-SHA256(secret, 时间戳 + "." + 请求体))，
+// 以 X-Webhook-Signature: t=时间戳,v1=签名 发送，订阅者应校验并拒绝过旧的时间戳
+func SignWebhook(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(ctx context.Context, sub *Subscriber, d *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Cloudreve-AfdianPay-Webhook")
+	req.Header.Set("X-Webhook-Event", d.EventType)
+	req.Header.Set("X-Webhook-Id", d.EventID)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	if sub.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", fmt.Sprintf("t=%d,v1=%s", ts, SignWebhook(sub.Secret, ts, body)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status=%d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// WebhookDelivery 一个事件对一个订阅者的投递状态
+type WebhookDelivery struct {
+	ID         int64
+	Subscriber string
+	EventID    string
+	EventType  string
+	Payload    string
+	Status     string
+	Attempts   int
+	// LastStatus 最近一次响应的 HTTP 状态码，网络错误时为 0
+	LastStatus    int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	DeliveredAt   time.Time
+}
+
+const deliveryColumns = "id, subscriber, event_id, event_type, payload, status, attempts, last_status, last_error, next_attempt_at, created_at, delivered_at"
+
+func scanDelivery(row rowScanner) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var next, created int64
+	var delivered sql.NullInt64
+	if err := row.Scan(&d.ID, &d.Subscriber, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.LastStatus, &d.LastError, &next, &created, &delivered); err != nil {
+		return nil, err
+	}
+	d.NextAttemptAt = time.Unix(next, 0)
+	d.CreatedAt = time.Unix(created, 0)
+	d.DeliveredAt = unixOrZero(delivered)
+	return &d, nil
+}
+
+// ListWebhookDeliveries 按订阅者与状态查询投递记录（空字符串不过滤），按时间倒序
+func (s *Service) ListWebhookDeliveries(subscriber, status string, limit int) ([]WebhookDelivery, error) {
+	query := "SELECT " + deliveryColumns + " FROM afdian_webhook WHERE 1 = 1"
+	var args []interface{}
+	if subscriber != "" {
+		query += " AND subscriber = ?"
+		args = append(args, subscriber)
+	}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *d)
+	}
+	return list, rows.Err()
+}
+
+// RetryWebhook 将投递记录重置为待发送并立即触发，attempts 清零
+func (s *Service) RetryWebhook(id int64) error {
+	res, err := s.exec("UPDATE afdian_webhook SET status = ?, attempts = 0, next_attempt_at = ?, claim_until = NULL WHERE id = ? AND status <> ?",
+		DeliveryPending, time.Now().Unix(), id, DeliveryDelivered)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var status string
+		if err := s.queryRow("SELECT status FROM afdian_webhook WHERE id = ?", id).Scan(&status); errors.Is(err, sql.ErrNoRows) {
+			return ErrDeliveryNotFound
+		} else if err != nil {
+			return err
+		}
+		if status == DeliveryDelivered {
+			return fmt.Errorf("投递记录 %d 已送达", id)
+		}
+	}
+	s.kickWebhooks()
+	return nil
+}
+
+func createWebhookTable(m *migrator) error {
+	return m.execAll(
+		`CREATE TABLE IF NOT EXISTS afdian_webhook (
+			id {id},
+			subscriber {key} NOT NULL,
+			event_id {key} NOT NULL,
+			event_type {key} NOT NULL,
+			payload {text},
+			status {key} NOT NULL,
+			attempts {int} NOT NULL DEFAULT 0,
+			last_status {int} NOT NULL DEFAULT 0,
+			last_error {text},
+			next_attempt_at {int} NOT NULL,
+			claimed_by {key} NOT NULL DEFAULT '',
+			claim_until {int},
+			created_at {int} NOT NULL,
+			delivered_at {int}
+		)`,
+		m.d.createIndex("idx_afdian_webhook_due", "afdian_webhook", "status, next_attempt_at", false),
+		m.d.createIndex("idx_afdian_webhook_subscriber", "afdian_webhook", "subscriber", false),
+	)
+}
@@ -0,0 +1,50 @@
+package afdian
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSubscribers(t *testing.T) {
+	cases := []struct {
+		json string
+		err  string
+	}{
+		{`[{"name":"bot","url":"http://bot","events":["order.paid","payment.unmatched"]},{"name":"crm","url":"http://crm"}]`, ""},
+		{`[{"name":"bot"}]`, "缺少 name 或 url"},
+		{`[{"name":"bot","url":"http://a"},{"name":"bot","url":"http://b"}]`, "重复"},
+		{`[{"name":"bot","url":"http://a","events":["order.refunded"]}]`, "无效"},
+		{`{}`, "解析"},
+	}
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "webhooks.json")
+		if err := os.WriteFile(path, []byte(c.json), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		subs, err := LoadSubscribers(path)
+		if c.err == "" {
+			if err != nil || len(subs) != 2 || !subs[0].wants(EventOrderPaid) || subs[0].wants(EventOrderCreated) || !subs[1].wants(EventOrderCreated) {
+				t.Fatalf("LoadSubscribers(%s) = %+v, %v", c.json, subs, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("LoadSubscribers(%s) err = %v, want %q", c.json, err, c.err)
+		}
+	}
+	if subs, err := LoadSubscribers(""); err != nil || subs != nil {
+		t.Fatalf("empty path = %v, %v", subs, err)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour}
+	for i, w := range want {
+		if got := webhookRetryDelay(i + 1); got != w {
+			t.Errorf("webhookRetryDelay(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
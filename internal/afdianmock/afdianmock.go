@@ -0,0 +1,524 @@
+// Package afdianmock 模拟爱发电开放平台接口与下单页，用于离线开发与测试。
+//
+// 支持 order/create 下单页、api/open/ping、api/open/query-order、api/open/query-sponsor，
+// 按爱发电规则校验 MD5 签名；测试者可在下单页或 /mock/pay 接口“支付”订单，
+// 支付后以爱发电的回调格式请求 WebhookURL。
+package afdianmock
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudreve-afdianpay/internal/money"
+)
+
+// StatusPaid 爱发电订单状态：交易成功
+const StatusPaid = 2
+
+// 开放平台错误码
+const (
+	ecOK             = 200
+	ecParamsMissing  = 400001
+	ecTimeExpired    = 400002
+	ecParamsNotJSON  = 400003
+	ecTokenNotFound  = 400004
+	ecSignInvalid    = 400005
+	defaultSignTTL   = time.Hour
+	maxOrdersPerPage = 100
+)
+
+// Sku 售卖商品明细
+type Sku struct {
+	SkuID   string `json:"sku_id"`
+	Count   int    `json:"count"`
+	Name    string `json:"name"`
+	AlbumID string `json:"album_id"`
+	Pic     string `json:"pic"`
+}
+
+// Order 爱发电订单，字段与 query-order 及回调中的 order 一致
+type Order struct {
+	OutTradeNo    string `json:"out_trade_no"`
+	CustomOrderID string `json:"custom_order_id"`
+	UserID        string `json:"user_id"`
+	UserPrivateID string `json:"user_private_id"`
+	PlanID        string `json:"plan_id"`
+	Month         int    `json:"month"`
+	TotalAmount   string `json:"total_amount"`
+	ShowAmount    string `json:"show_amount"`
+	Status        int    `json:"status"`
+	Remark        string `json:"remark"`
+	RedeemID      string `json:"redeem_id"`
+	ProductType   int    `json:"product_type"`
+	Discount      string `json:"discount"`
+	SkuDetail     []Sku  `json:"sku_detail"`
+	CreateTime    int64  `json:"create_time"`
+}
+
+// Sponsor 赞助者
+type Sponsor struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+}
+
+// PayRequest 模拟一次支付
+type PayRequest struct {
+	Remark string `json:"remark"`
+	// TotalAmount 实付金额（元），如 "5.00"
+	TotalAmount string `json:"total_amount"`
+	PlanID      string `json:"plan_id"`
+	ProductType int    `json:"product_type"`
+	Month       int    `json:"month"`
+	Sku         []Sku  `json:"sku_detail"`
+	// SponsorID / SponsorName 赞助者，留空时生成
+	SponsorID   string `json:"sponsor_id"`
+	SponsorName string `json:"sponsor_name"`
+	// NoWebhook 只记录订单不回调，模拟回调丢失（由对账或轮询发现）
+	NoWebhook bool `json:"no_webhook"`
+}
+
+// Delivery 一次回调记录
+type Delivery struct {
+	OutTradeNo string    `json:"out_trade_no"`
+	StatusCode int       `json:"status_code"`
+	Body       string    `json:"body"`
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// Server 模拟的爱发电服务
+type Server struct {
+	UserID string
+	Token  string
+	// WebhookURL 支付后回调的地址，如 http://127.0.0.1:9800/afdian；留空不回调
+	WebhookURL string
+	// SignTTL 签名时间戳允许的偏差，默认 1 小时
+	SignTTL time.Duration
+	// Client 发送回调使用的 HTTP 客户端
+	Client *http.Client
+
+	mu         sync.Mutex
+	seq        int
+	orders     []*Order
+	sponsors   map[string]*Sponsor
+	deliveries []Delivery
+}
+
+// New 创建模拟服务，userID/token 需与网关的 USER_ID/TOKEN 一致
+func New(userID, token string) *Server {
+	return &Server{
+		UserID:   userID,
+		Token:    token,
+		SignTTL:  defaultSignTTL,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		sponsors: make(map[string]*Sponsor),
+	}
+}
+
+// Handler 返回模拟服务的路由
+func (m *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/order/create", m.checkout)
+	mux.HandleFunc("/api/open/ping", m.api(m.ping))
+	mux.HandleFunc("/api/open/query-order", m.api(m.queryOrder))
+	mux.HandleFunc("/api/open/query-sponsor", m.api(m.querySponsor))
+	mux.HandleFunc("/mock/pay", m.payAPI)
+	mux.HandleFunc("/mock/orders", m.ordersAPI)
+	mux.HandleFunc("/mock/webhook", m.webhookAPI)
+	return mux
+}
+
+// Pay 记录一笔已支付订单并回调 WebhookURL，回调失败不影响订单
+func (m *Server) Pay(req PayRequest) (*Order, error) {
+	total, err := money.ParseAny(req.TotalAmount, money.CNY)
+	if err != nil || total.Minor <= 0 {
+		return nil, fmt.Errorf("无效的金额 %q", req.TotalAmount)
+	}
+	m.mu.Lock()
+	m.seq++
+	now := time.Now()
+	if req.SponsorID == "" {
+		req.SponsorID = fmt.Sprintf("mock-user-%d", m.seq)
+	}
+	if req.SponsorName == "" {
+		req.SponsorName = "测试赞助者" + req.SponsorID
+	}
+	if _, ok := m.sponsors[req.SponsorID]; !ok {
+		m.sponsors[req.SponsorID] = &Sponsor{UserID: req.SponsorID, Name: req.SponsorName}
+	}
+	month := req.Month
+	if month == 0 && req.PlanID != "" && req.ProductType == 0 {
+		month = 1
+	}
+	o := &Order{
+		OutTradeNo:    fmt.Sprintf("%s%04d", now.Format("20060102150405"), m.seq),
+		UserID:        req.SponsorID,
+		UserPrivateID: "private-" + req.SponsorID,
+		PlanID:        req.PlanID,
+		Month:         month,
+		TotalAmount:   total.String(),
+		ShowAmount:    total.String(),
+		Status:        StatusPaid,
+		Remark:        req.Remark,
+		ProductType:   req.ProductType,
+		Discount:      "0.00",
+		SkuDetail:     req.Sku,
+		CreateTime:    now.Unix(),
+	}
+	if o.SkuDetail == nil {
+		o.SkuDetail = []Sku{}
+	}
+	m.orders = append(m.orders, o)
+	cp := *o
+	m.mu.Unlock()
+
+	log.Printf("[AfdianMock] paid out_trade_no=%s amount=%s remark=%q", cp.OutTradeNo, cp.TotalAmount, cp.Remark)
+	if !req.NoWebhook {
+		_ = m.SendWebhook(cp.OutTradeNo)
+	}
+	return &cp, nil
+}
+
+// Orders 返回所有订单，按创建顺序
+func (m *Server) Orders() []Order {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Order, len(m.orders))
+	for i, o := range m.orders {
+		list[i] = *o
+	}
+	return list
+}
+
+// SetStatus 修改订单状态，用于模拟退款等非交易成功状态
+func (m *Server) SetStatus(outTradeNo string, status int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := m.find(outTradeNo)
+	if o == nil {
+		return fmt.Errorf("订单 %s 不存在", outTradeNo)
+	}
+	o.Status = status
+	return nil
+}
+
+// Deliveries 返回所有回调记录
+func (m *Server) Deliveries() []Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Delivery(nil), m.deliveries...)
+}
+
+// SendWebhook 以爱发电回调格式将订单发送到 WebhookURL，网关需返回 ec=200
+func (m *Server) SendWebhook(outTradeNo string) error {
+	m.mu.Lock()
+	o := m.find(outTradeNo)
+	var cp Order
+	if o != nil {
+		cp = *o
+	}
+	m.mu.Unlock()
+	if o == nil {
+		return fmt.Errorf("订单 %s 不存在", outTradeNo)
+	}
+	if m.WebhookURL == "" {
+		return errors.New("未设置回调地址")
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"ec": ecOK,
+		"em": "ok",
+		"data": map[string]interface{}{
+			"type":  "order",
+			"order": cp,
+		},
+	})
+	d := Delivery{OutTradeNo: outTradeNo, At: time.Now()}
+	err := func() error {
+		resp, err := m.Client.Post(m.WebhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		d.StatusCode, d.Body = resp.StatusCode, string(body)
+		var r struct {
+			Ec int `json:"ec"`
+		}
+		if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &r) != nil || r.Ec != ecOK {
+			return fmt.Errorf("回调响应异常: status=%d body=%s", resp.StatusCode, body)
+		}
+		return nil
+	}()
+	if err != nil {
+		d.Error = err.Error()
+		log.Printf("[AfdianMock] webhook out_trade_no=%s error: %v", outTradeNo, err)
+	} else {
+		log.Printf("[AfdianMock] webhook out_trade_no=%s ok", outTradeNo)
+	}
+	m.mu.Lock()
+	m.deliveries = append(m.deliveries, d)
+	m.mu.Unlock()
+	return err
+}
+
+func (m *Server) find(outTradeNo string) *Order {
+	for _, o := range m.orders {
+		if o.OutTradeNo == outTradeNo {
+			return o
+		}
+	}
+	return nil
+}
+
+// Sign 按爱发电规则计算签名：md5(token + "params" + params + "ts" + ts + "user_id" + user_id)
+func Sign(token, userID, params, ts string) string {
+	h := md5.Sum([]byte(token + "params" + params + "ts" + ts + "user_id" + userID))
+	return hex.EncodeToString(h[:])
+}
+
+// apiRequest 开放平台请求，支持表单与 JSON 两种提交方式
+type apiRequest struct {
+	UserID string          `json:"user_id"`
+	Params string          `json:"params"`
+	TS     json.RawMessage `json:"ts"`
+	Sign   string          `json:"sign"`
+
+	ts     string
+	params map[string]interface{}
+}
+
+type apiHandler func(req *apiRequest) (interface{}, int, string)
+
+// api 解析并校验开放平台请求，校验失败时按爱发电错误码返回
+func (m *Server) api(h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ec, em := m.parseAPI(r)
+		var data interface{}
+		if ec == ecOK {
+			data, ec, em = h(req)
+		}
+		if ec != ecOK {
+			log.Printf("[AfdianMock] %s ec=%d em=%s", r.URL.Path, ec, em)
+		}
+		writeJSON(w, map[string]interface{}{"ec": ec, "em": em, "data": data})
+	}
+}
+
+func (m *Server) parseAPI(r *http.Request) (*apiRequest, int, string) {
+	if r.Method != http.MethodPost {
+		return nil, ecParamsMissing, "method not allowed"
+	}
+	var req apiRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			return nil, ecParamsNotJSON, "body is not valid json"
+		}
+		req.ts = strings.Trim(string(req.TS), `"`)
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, ecParamsMissing, "params incomplete"
+		}
+		req.UserID, req.Params, req.ts, req.Sign = r.PostForm.Get("user_id"), r.PostForm.Get("params"), r.PostForm.Get("ts"), r.PostForm.Get("sign")
+	}
+	if req.UserID == "" || req.Params == "" || req.ts == "" || req.Sign == "" {
+		return nil, ecParamsMissing, "params incomplete"
+	}
+	var ts int64
+	if _, err := fmt.Sscan(req.ts, &ts); err != nil {
+		return nil, ecParamsMissing, "params incomplete"
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > m.SignTTL || d < -m.SignTTL {
+		return nil, ecTimeExpired, "time was expired"
+	}
+	if err := json.Unmarshal([]byte(req.Params), &req.params); err != nil {
+		return nil, ecParamsNotJSON, "params is not valid json"
+	}
+	if req.UserID != m.UserID {
+		return nil, ecTokenNotFound, "no valid token found"
+	}
+	if req.Sign != Sign(m.Token, req.UserID, req.Params, req.ts) {
+		return nil, ecSignInvalid, "sign validation failed"
+	}
+	return &req, ecOK, ""
+}
+
+func (m *Server) ping(req *apiRequest) (interface{}, int, string) {
+	return map[string]interface{}{
+		"uid": m.UserID,
+		"request": map[string]interface{}{
+			"user_id": req.UserID,
+			"params":  req.Params,
+			"ts":      req.ts,
+			"sign":    req.Sign,
+		},
+	}, ecOK, "pong"
+}
+
+func (m *Server) queryOrder(req *apiRequest) (interface{}, int, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []Order
+	if v := paramString(req.params["out_trade_no"]); v != "" {
+		for _, no := range strings.Split(v, ",") {
+			if o := m.find(strings.TrimSpace(no)); o != nil {
+				list = append(list, *o)
+			}
+		}
+		return pageResult(list, 1, maxOrdersPerPage), ecOK, ""
+	}
+	for i := len(m.orders) - 1; i >= 0; i-- {
+		list = append(list, *m.orders[i])
+	}
+	perPage := paramInt(req.params["per_page"], 50)
+	if perPage > maxOrdersPerPage {
+		perPage = maxOrdersPerPage
+	}
+	return pageResult(list, paramInt(req.params["page"], 1), perPage), ecOK, ""
+}
+
+func (m *Server) querySponsor(req *apiRequest) (interface{}, int, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	if v := paramString(req.params["user_id"]); v != "" {
+		ids = strings.Split(v, ",")
+	} else {
+		for id := range m.sponsors {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	type sponsorItem struct {
+		AllSumAmount string  `json:"all_sum_amount"`
+		CreateTime   int64   `json:"create_time"`
+		LastPayTime  int64   `json:"last_pay_time"`
+		User         Sponsor `json:"user"`
+	}
+	var list []sponsorItem
+	for _, id := range ids {
+		sp := m.sponsors[strings.TrimSpace(id)]
+		if sp == nil {
+			continue
+		}
+		it := sponsorItem{User: *sp}
+		var sum money.Amount
+		for _, o := range m.orders {
+			if o.UserID != sp.UserID || o.Status != StatusPaid {
+				continue
+			}
+			if a, err := money.ParseAny(o.TotalAmount, money.CNY); err == nil {
+				sum.Minor += a.Minor
+			}
+			if it.CreateTime == 0 {
+				it.CreateTime = o.CreateTime
+			}
+			it.LastPayTime = o.CreateTime
+		}
+		sum.Currency = money.CNY
+		it.AllSumAmount = sum.String()
+		list = append(list, it)
+	}
+	return pageResult(list, paramInt(req.params["page"], 1), paramInt(req.params["per_page"], 20)), ecOK, ""
+}
+
+// pageResult 按爱发电分页格式返回 list/total_count/total_page
+func pageResult[T any](list []T, page, perPage int) map[string]interface{} {
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 1
+	}
+	total := len(list)
+	start, end := (page-1)*perPage, page*perPage
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	items := list[start:end]
+	if items == nil {
+		items = []T{}
+	}
+	return map[string]interface{}{
+		"list":        items,
+		"total_count": total,
+		"total_page":  (total + perPage - 1) / perPage,
+	}
+}
+
+func paramString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return fmt.Sprintf("%.0f", x)
+	}
+	return ""
+}
+
+func paramInt(v interface{}, def int) int {
+	var n int
+	if _, err := fmt.Sscan(paramString(v), &n); err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// payAPI POST /mock/pay，请求体为 PayRequest，返回生成的订单
+func (m *Server) payAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req PayRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]interface{}{"error": "请求体格式错误"})
+		return
+	}
+	o, err := m.Pay(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	writeJSON(w, o)
+}
+
+// ordersAPI GET /mock/orders 返回订单与回调记录
+func (m *Server) ordersAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{"orders": m.Orders(), "deliveries": m.Deliveries()})
+}
+
+// webhookAPI POST /mock/webhook?out_trade_no= 重新发送回调
+func (m *Server) webhookAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := m.SendWebhook(r.URL.Query().Get("out_trade_no")); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		writeJSON(w, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"ok": true})
+}
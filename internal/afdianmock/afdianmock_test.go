@@ -0,0 +1,178 @@
+package afdianmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	m := New("author", "secret")
+	ts := httptest.NewServer(m.Handler())
+	t.Cleanup(ts.Close)
+	return m, ts
+}
+
+type apiResp struct {
+	Ec   int             `json:"ec"`
+	Em   string          `json:"em"`
+	Data json.RawMessage `json:"data"`
+}
+
+func call(t *testing.T, base, path, userID, token, params string, ts int64) apiResp {
+	t.Helper()
+	tss := fmt.Sprint(ts)
+	form := url.Values{"user_id": {userID}, "params": {params}, "ts": {tss}, "sign": {Sign(token, userID, params, tss)}}
+	resp, err := http.PostForm(base+"/api/open/"+path, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r apiResp
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestSignCheck(t *testing.T) {
+	_, ts := newTestServer(t)
+	now := time.Now().Unix()
+	cases := []struct {
+		name, userID, token, params string
+		ts                          int64
+		ec                          int
+	}{
+		{"ok", "author", "secret", `{"a":1}`, now, ecOK},
+		{"bad sign", "author", "wrong", `{"a":1}`, now, ecSignInvalid},
+		{"unknown user", "someone", "secret", `{"a":1}`, now, ecTokenNotFound},
+		{"expired", "author", "secret", `{"a":1}`, now - 7200, ecTimeExpired},
+		{"params not json", "author", "secret", `{a`, now, ecParamsNotJSON},
+		{"missing params", "author", "secret", "", now, ecParamsMissing},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := call(t, ts.URL, "ping", c.userID, c.token, c.params, c.ts)
+			if r.Ec != c.ec {
+				t.Fatalf("ec = %d (%s), want %d", r.Ec, r.Em, c.ec)
+			}
+		})
+	}
+}
+
+func TestSignJSONBody(t *testing.T) {
+	_, ts := newTestServer(t)
+	now := fmt.Sprint(time.Now().Unix())
+	body := fmt.Sprintf(`{"user_id":"author","params":"{}","ts":%s,"sign":%q}`, now, Sign("secret", "author", "{}", now))
+	resp, err := http.Post(ts.URL+"/api/open/ping", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r apiResp
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil || r.Ec != ecOK || r.Em != "pong" {
+		t.Fatalf("ping = %+v, %v", r, err)
+	}
+}
+
+func TestPayQueryAndWebhook(t *testing.T) {
+	m, ts := newTestServer(t)
+	var got []string
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Data struct {
+				Type  string `json:"type"`
+				Order Order  `json:"order"`
+			} `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		got = append(got, payload.Data.Type+":"+payload.Data.Order.Remark+":"+payload.Data.Order.TotalAmount)
+		io.WriteString(w, `{"ec":200,"em":""}`)
+	}))
+	defer gw.Close()
+	m.WebhookURL = gw.URL
+
+	o1, err := m.Pay(PayRequest{Remark: "order-1", TotalAmount: "5", SponsorID: "u1", SponsorName: "甲"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Pay(PayRequest{Remark: "order-2", TotalAmount: "12.30", SponsorID: "u1", NoWebhook: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Pay(PayRequest{TotalAmount: "abc"}); err == nil {
+		t.Fatal("pay with invalid amount succeeded")
+	}
+	if len(got) != 1 || got[0] != "order:order-1:5.00" {
+		t.Fatalf("webhooks = %v", got)
+	}
+	if d := m.Deliveries(); len(d) != 1 || d[0].Error != "" {
+		t.Fatalf("deliveries = %+v", d)
+	}
+
+	now := time.Now().Unix()
+	var page struct {
+		List       []Order `json:"list"`
+		TotalCount int     `json:"total_count"`
+		TotalPage  int     `json:"total_page"`
+	}
+	r := call(t, ts.URL, "query-order", "author", "secret", fmt.Sprintf(`{"out_trade_no":%q}`, o1.OutTradeNo), now)
+	if err := json.Unmarshal(r.Data, &page); err != nil || len(page.List) != 1 || page.List[0].Remark != "order-1" || page.List[0].Status != StatusPaid {
+		t.Fatalf("query by out_trade_no = %s, %v", r.Data, err)
+	}
+	r = call(t, ts.URL, "query-order", "author", "secret", `{"page":2,"per_page":1}`, now)
+	if err := json.Unmarshal(r.Data, &page); err != nil || page.TotalCount != 2 || page.TotalPage != 2 || len(page.List) != 1 || page.List[0].Remark != "order-1" {
+		t.Fatalf("query page 2 = %s, %v", r.Data, err)
+	}
+
+	r = call(t, ts.URL, "query-sponsor", "author", "secret", `{"user_id":"u1","page":1}`, now)
+	var sponsors struct {
+		List []struct {
+			AllSumAmount string  `json:"all_sum_amount"`
+			User         Sponsor `json:"user"`
+		} `json:"list"`
+	}
+	if err := json.Unmarshal(r.Data, &sponsors); err != nil || len(sponsors.List) != 1 || sponsors.List[0].User.Name != "甲" || sponsors.List[0].AllSumAmount != "17.30" {
+		t.Fatalf("query sponsor = %s, %v", r.Data, err)
+	}
+
+	if err := m.SetStatus(o1.OutTradeNo, 3); err != nil {
+		t.Fatal(err)
+	}
+	if o := m.Orders()[0]; o.Status != 3 {
+		t.Fatalf("status = %d", o.Status)
+	}
+}
+
+func TestCheckoutPage(t *testing.T) {
+	m, ts := newTestServer(t)
+	resp, err := http.Get(ts.URL + "/order/create?user_id=author&custom_price=5.00&remark=" + url.QueryEscape("o-1-abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "o-1-abcdef") {
+		t.Fatalf("checkout page status=%d body=%s", resp.StatusCode, body)
+	}
+	if resp, err := http.Get(ts.URL + "/order/create?user_id=other"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown author = %v, %v", resp, err)
+	}
+
+	form := url.Values{"total_amount": {"5.00"}, "remark": {"o-1-abcdef 谢谢"}, "no_webhook": {"1"}}
+	resp, err = http.PostForm(ts.URL+"/order/create?user_id=author", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	list := m.Orders()
+	if len(list) != 1 || list[0].Remark != "o-1-abcdef 谢谢" || list[0].TotalAmount != "5.00" {
+		t.Fatalf("orders = %+v", list)
+	}
+}
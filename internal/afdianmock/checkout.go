@@ -0,0 +1,77 @@
+package afdianmock
+
+import (
+	"embed"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+//go:embed templates/checkout.html
+var templateFS embed.FS
+
+var checkoutTmpl = template.Must(template.ParseFS(templateFS, "templates/checkout.html"))
+
+type checkoutData struct {
+	Req PayRequest
+	// Sku 原样回填的 sku 参数（JSON）
+	Sku      string
+	Paid     *Order
+	Delivery *Delivery
+	Error    string
+}
+
+// checkout 模拟 /order/create 下单页：GET 展示订单，POST 完成支付并回调
+func (m *Server) checkout(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "参数错误", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodGet && r.Form.Get("user_id") != m.UserID {
+		http.Error(w, "作者不存在", http.StatusNotFound)
+		return
+	}
+	data := checkoutData{Sku: r.Form.Get("sku")}
+	data.Req.Remark = r.Form.Get("remark")
+	data.Req.PlanID = r.Form.Get("plan_id")
+	data.Req.ProductType, _ = strconv.Atoi(r.Form.Get("product_type"))
+	data.Req.Month, _ = strconv.Atoi(r.Form.Get("month"))
+	data.Req.TotalAmount = r.Form.Get("custom_price")
+	if data.Sku != "" {
+		if err := json.Unmarshal([]byte(data.Sku), &data.Req.Sku); err != nil {
+			data.Error = "sku 参数不是有效的 JSON"
+		}
+	}
+	if r.Method == http.MethodPost && data.Error == "" {
+		data.Req.TotalAmount = r.PostForm.Get("total_amount")
+		data.Req.SponsorName = r.PostForm.Get("sponsor_name")
+		data.Req.NoWebhook = r.PostForm.Get("no_webhook") != ""
+		o, err := m.Pay(data.Req)
+		if err != nil {
+			data.Error = err.Error()
+		} else {
+			data.Paid = o
+			if !data.Req.NoWebhook {
+				data.Delivery = m.lastDelivery(o.OutTradeNo)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := checkoutTmpl.Execute(w, &data); err != nil {
+		log.Printf("[AfdianMock] render checkout error: %v", err)
+	}
+}
+
+func (m *Server) lastDelivery(outTradeNo string) *Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if m.deliveries[i].OutTradeNo == outTradeNo {
+			d := m.deliveries[i]
+			return &d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+// Package alert 运维告警：按“时间窗口内的次数”阈值聚合故障信号，
+// 同一次故障只告警一次，通过 SMTP 邮件、Telegram 机器人或通用 Webhook 发送。
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 告警类型
+const (
+	// KindCallbackFailed 爱发电回调处理出错（查询接口失败、写库失败等）
+	KindCallbackFailed = "callback_failed"
+	// KindAPIError 爱发电开放平台接口调用失败
+	KindAPIError = "afdian_api_error"
+	// KindNotifyFailed 通知 Cloudreve 重试耗尽
+	KindNotifyFailed = "notify_failed"
+	// KindExchangeRate 汇率接口调用失败
+	KindExchangeRate = "exchange_rate_failed"
+	// KindWebhookFailed 事件推送重试耗尽
+	KindWebhookFailed = "webhook_failed"
+)
+
+// Rule 在 Window 内发生 Threshold 次时触发告警
+type Rule struct {
+	Threshold int
+	Window    time.Duration
+}
+
+// DefaultRules 默认阈值，可通过 ALERT_RULES 覆盖
+var DefaultRules = map[string]Rule{
+	KindCallbackFailed: {5, 10 * time.Minute},
+	KindAPIError:       {5, 10 * time.Minute},
+	KindNotifyFailed:   {1, 30 * time.Minute},
+	KindExchangeRate:   {3, 10 * time.Minute},
+	KindWebhookFailed:  {1, 30 * time.Minute},
+}
+
+var titles = map[string]string{
+	KindCallbackFailed: "爱发电回调处理失败",
+	KindAPIError:       "爱发电接口调用失败",
+	KindNotifyFailed:   "通知 Cloudreve 失败",
+	KindExchangeRate:   "汇率接口调用失败",
+	KindWebhookFailed:  "事件推送失败",
+}
+
+// Alert 一次故障的告警内容
+type Alert struct {
+	Kind  string
+	Title string
+	// Count 触发时窗口内的次数
+	Count  int
+	Window time.Duration
+	// Detail 最近一次故障的说明
+	Detail  string
+	Site    string
+	FirstAt time.Time
+	LastAt  time.Time
+}
+
+// Text 纯文本告警正文
+func (a *Alert) Text() string {
+	return fmt.Sprintf("[Cloudreve-AfdianPay] %s\n站点：%s\n%s 内 %d 次，首次 %s，最近 %s\n最近一次：%s",
+		a.Title, a.Site, a.Window, a.Count, a.FirstAt.Format("2006-01-02 15:04:05"), a.LastAt.Format("2006-01-02 15:04:05"), a.Detail)
+}
+
+// Sink 告警发送渠道
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, a *Alert) error
+}
+
+// Manager 记录故障并在达到阈值时告警。一次故障从首次达到阈值开始，
+// 到连续一个窗口内没有再发生为止，期间只告警一次。零值不发送任何告警，nil 可安全调用
+type Manager struct {
+	Sinks []Sink
+	Rules map[string]Rule
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+	firing map[string]bool
+	now    func() time.Time
+	// wg 等待异步发送完成，用于测试与退出
+	wg sync.WaitGroup
+}
+
+// NewManager 使用默认阈值创建告警管理器
+func NewManager(sinks ...Sink) *Manager {
+	rules := make(map[string]Rule, len(DefaultRules))
+	for k, r := range DefaultRules {
+		rules[k] = r
+	}
+	return &Manager{Sinks: sinks, Rules: rules}
+}
+
+// Enabled 是否配置了发送渠道
+func (m *Manager) Enabled() bool {
+	return m != nil && len(m.Sinks) > 0
+}
+
+// Record 记录一次故障，达到阈值且当前没有进行中的同类故障时异步发送告警
+func (m *Manager) Record(kind, detail string) {
+	if !m.Enabled() {
+		return
+	}
+	rule, ok := m.Rules[kind]
+	if !ok || rule.Threshold <= 0 {
+		return
+	}
+	m.mu.Lock()
+	if m.events == nil {
+		m.events = make(map[string][]time.Time)
+		m.firing = make(map[string]bool)
+	}
+	now := time.Now()
+	if m.now != nil {
+		now = m.now()
+	}
+	events := m.events[kind]
+	i := 0
+	for i < len(events) && now.Sub(events[i]) > rule.Window {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		// 一个窗口内没有再发生，上一次故障结束
+		m.firing[kind] = false
+	}
+	events = append(events, now)
+	// 只保留判断阈值所需的次数
+	if len(events) > rule.Threshold {
+		events = events[len(events)-rule.Threshold:]
+	}
+	m.events[kind] = events
+	if m.firing[kind] || len(events) < rule.Threshold {
+		m.mu.Unlock()
+		return
+	}
+	m.firing[kind] = true
+	a := &Alert{
+		Kind:    kind,
+		Title:   titles[kind],
+		Count:   len(events),
+		Window:  rule.Window,
+		Detail:  detail,
+		Site:    strings.TrimRight(os.Getenv("SITE_URL"), "/"),
+		FirstAt: events[0],
+		LastAt:  now,
+	}
+	if a.Title == "" {
+		a.Title = kind
+	}
+	m.mu.Unlock()
+
+	log.Printf("[Alert] firing %s: %s", kind, detail)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.send(a)
+	}()
+}
+
+func (m *Manager) send(a *Alert) {
+	for _, s := range m.Sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.Send(ctx, a); err != nil {
+			log.Printf("[Alert] send %s via %s error: %v", a.Kind, s.Name(), err)
+		}
+		cancel()
+	}
+}
+
+// Wait 等待已触发的告警发送完成
+func (m *Manager) Wait() {
+	if m != nil {
+		m.wg.Wait()
+	}
+}
+
+// ParseRules 解析 "kind=次数/窗口,..." 格式的阈值，如 "callback_failed=5/10m,notify_failed=1/30m"，
+// 次数为 0 表示关闭该类告警
+func ParseRules(s string, rules map[string]Rule) error {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, spec, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("告警阈值 %q 格式应为 kind=次数/窗口", part)
+		}
+		kind = strings.TrimSpace(kind)
+		if _, known := titles[kind]; !known {
+			return fmt.Errorf("未知的告警类型 %q，可选 %s", kind, strings.Join(Kinds(), ", "))
+		}
+		n, w, ok := strings.Cut(spec, "/")
+		threshold, err := strconv.Atoi(strings.TrimSpace(n))
+		if !ok || err != nil || threshold < 0 {
+			return fmt.Errorf("告警阈值 %q 格式应为 kind=次数/窗口", part)
+		}
+		window, err := time.ParseDuration(strings.TrimSpace(w))
+		if err != nil || window <= 0 {
+			return fmt.Errorf("告警阈值 %q 的窗口无效", part)
+		}
+		rules[kind] = Rule{Threshold: threshold, Window: window}
+	}
+	return nil
+}
+
+// Kinds 全部告警类型
+func Kinds() []string {
+	kinds := make([]string, 0, len(titles))
+	for k := range titles {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// FromEnv 按 .env 配置创建告警管理器，未配置任何渠道时返回的管理器不发送告警
+func FromEnv() (*Manager, error) {
+	var sinks []Sink
+	if addr := os.Getenv("ALERT_SMTP_ADDR"); addr != "" {
+		to := splitList(os.Getenv("ALERT_SMTP_TO"))
+		if len(to) == 0 {
+			return nil, fmt.Errorf("已设置 ALERT_SMTP_ADDR 但 ALERT_SMTP_TO 为空")
+		}
+		from := os.Getenv("ALERT_SMTP_FROM")
+		if from == "" {
+			from = os.Getenv("ALERT_SMTP_USER")
+		}
+		sinks = append(sinks, &SMTPSink{Addr: addr, Username: os.Getenv("ALERT_SMTP_USER"), Password: os.Getenv("ALERT_SMTP_PASSWORD"), From: from, To: to})
+	}
+	if token := os.Getenv("ALERT_TELEGRAM_TOKEN"); token != "" {
+		chat := os.Getenv("ALERT_TELEGRAM_CHAT_ID")
+		if chat == "" {
+			return nil, fmt.Errorf("已设置 ALERT_TELEGRAM_TOKEN 但 ALERT_TELEGRAM_CHAT_ID 为空")
+		}
+		sinks = append(sinks, &TelegramSink{Token: token, ChatID: chat})
+	}
+	if u := os.Getenv("ALERT_WEBHOOK_URL"); u != "" {
+		sinks = append(sinks, &WebhookSink{URL: u})
+	}
+	m := NewManager(sinks...)
+	if err := ParseRules(os.Getenv("ALERT_RULES"), m.Rules); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
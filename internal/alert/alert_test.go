@@ -0,0 +1,293 @@
+package alert
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordSink struct {
+	mu     sync.Mutex
+	alerts []*Alert
+}
+
+func (s *recordSink) Name() string { return "record" }
+
+func (s *recordSink) Send(ctx context.Context, a *Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts = append(s.alerts, a)
+	return nil
+}
+
+func (s *recordSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.alerts)
+}
+
+func TestRecordThresholdAndDedup(t *testing.T) {
+	sink := &recordSink{}
+	m := NewManager(sink)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	record := func(n int, step time.Duration) {
+		for i := 0; i < n; i++ {
+			m.Record(KindCallbackFailed, "boom")
+			now = now.Add(step)
+		}
+		m.Wait()
+	}
+
+	// 4 次未达到 10 分钟内 5 次的阈值
+	record(4, time.Minute)
+	if sink.count() != 0 {
+		t.Fatalf("alerts after 4 failures = %d", sink.count())
+	}
+	// 第 5 次触发
+	record(1, time.Minute)
+	if sink.count() != 1 {
+		t.Fatalf("alerts after 5 failures = %d", sink.count())
+	}
+	a := sink.alerts[0]
+	if a.Kind != KindCallbackFailed || a.Count != 5 || a.Window != 10*time.Minute || a.Detail != "boom" || a.LastAt.Sub(a.FirstAt) != 4*time.Minute {
+		t.Fatalf("alert = %+v", a)
+	}
+	// 同一次故障持续发生不重复告警
+	record(20, time.Minute)
+	if sink.count() != 1 {
+		t.Fatalf("alerts during incident = %d", sink.count())
+	}
+	// 连续一个窗口没有故障后，下一次故障重新告警
+	now = now.Add(11 * time.Minute)
+	record(5, time.Second)
+	if sink.count() != 2 {
+		t.Fatalf("alerts after recovery = %d", sink.count())
+	}
+	// 其他类型独立计数
+	m.Record(KindNotifyFailed, "order_no=1")
+	m.Wait()
+	if sink.count() != 3 || sink.alerts[2].Kind != KindNotifyFailed {
+		t.Fatalf("notify alert missing: %d", sink.count())
+	}
+}
+
+func TestRecordSpreadOutDoesNotFire(t *testing.T) {
+	sink := &recordSink{}
+	m := NewManager(sink)
+	now := time.Unix(0, 0)
+	m.now = func() time.Time { return now }
+	// 每 3 分钟一次，任意 10 分钟内最多 4 次
+	for i := 0; i < 20; i++ {
+		m.Record(KindCallbackFailed, "x")
+		now = now.Add(3 * time.Minute)
+	}
+	m.Wait()
+	if sink.count() != 0 {
+		t.Fatalf("alerts = %d", sink.count())
+	}
+}
+
+func TestRecordDisabled(t *testing.T) {
+	var nilManager *Manager
+	nilManager.Record(KindAPIError, "x")
+	nilManager.Wait()
+
+	sink := &recordSink{}
+	m := NewManager(sink)
+	m.Rules[KindNotifyFailed] = Rule{0, time.Hour}
+	m.Record(KindNotifyFailed, "x")
+	m.Record("unknown", "x")
+	m.Wait()
+	if sink.count() != 0 {
+		t.Fatalf("alerts = %d", sink.count())
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules := map[string]Rule{}
+	if err := ParseRules(" callback_failed=3/5m, notify_failed=0/1h ,", rules); err != nil {
+		t.Fatal(err)
+	}
+	if rules[KindCallbackFailed] != (Rule{3, 5 * time.Minute}) || rules[KindNotifyFailed] != (Rule{0, time.Hour}) || len(rules) != 2 {
+		t.Fatalf("rules = %+v", rules)
+	}
+	for _, bad := range []string{"callback_failed", "callback_failed=3", "callback_failed=x/5m", "callback_failed=-1/5m", "callback_failed=3/0s", "callback_failed=3/abc", "nope=1/1m"} {
+		if err := ParseRules(bad, map[string]Rule{}); err == nil {
+			t.Errorf("ParseRules(%q) = nil", bad)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("ALERT_SMTP_ADDR", "")
+	t.Setenv("ALERT_TELEGRAM_TOKEN", "")
+	t.Setenv("ALERT_WEBHOOK_URL", "")
+	t.Setenv("ALERT_RULES", "")
+	m, err := FromEnv()
+	if err != nil || m.Enabled() {
+		t.Fatalf("FromEnv without sinks = %v, %v", m.Enabled(), err)
+	}
+
+	t.Setenv("ALERT_SMTP_ADDR", "smtp.example.com:587")
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("want error for missing ALERT_SMTP_TO")
+	}
+	t.Setenv("ALERT_SMTP_TO", "a@example.com, b@example.com")
+	t.Setenv("ALERT_SMTP_USER", "gw@example.com")
+	t.Setenv("ALERT_WEBHOOK_URL", "https://hooks.example.com/x")
+	t.Setenv("ALERT_RULES", "exchange_rate_failed=1/1m")
+	m, err = FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sinks) != 2 || m.Sinks[0].Name() != "smtp" || m.Sinks[1].Name() != "webhook" {
+		t.Fatalf("sinks = %+v", m.Sinks)
+	}
+	if s := m.Sinks[0].(*SMTPSink); s.From != "gw@example.com" || len(s.To) != 2 {
+		t.Fatalf("smtp sink = %+v", s)
+	}
+	if m.Rules[KindExchangeRate] != (Rule{1, time.Minute}) || m.Rules[KindCallbackFailed] != DefaultRules[KindCallbackFailed] {
+		t.Fatalf("rules = %+v", m.Rules)
+	}
+}
+
+func testAlert() *Alert {
+	at := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	return &Alert{Kind: KindCallbackFailed, Title: titles[KindCallbackFailed], Count: 5, Window: 10 * time.Minute, Detail: "out_trade_no=1", Site: "https://cr.example.com", FirstAt: at, LastAt: at.Add(time.Minute)}
+}
+
+func TestTelegramSink(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottok/sendMessage" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got["chat_id"] == "bad" {
+			w.Write([]byte(`{"ok":false,"description":"chat not found"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	s := &TelegramSink{Token: "tok", ChatID: "42", APIBase: srv.URL}
+	if err := s.Send(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	if got["chat_id"] != "42" || !strings.Contains(got["text"].(string), "爱发电回调处理失败") {
+		t.Fatalf("request = %+v", got)
+	}
+	s.ChatID = "bad"
+	if err := s.Send(context.Background(), testAlert()); err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got map[string]interface{}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := &WebhookSink{URL: srv.URL}
+	if err := s.Send(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	if got["kind"] != KindCallbackFailed || got["count"] != float64(5) || got["window"] != "10m0s" || got["text"] != got["content"] {
+		t.Fatalf("payload = %+v", got)
+	}
+	status = http.StatusInternalServerError
+	if err := s.Send(context.Background(), testAlert()); err == nil {
+		t.Fatal("want error for 500")
+	}
+}
+
+// fakeSMTP 只实现发送一封邮件所需命令的 SMTP 服务器
+func fakeSMTP(t *testing.T) (addr string, mail <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rd := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 fake ESMTP")
+		var data strings.Builder
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250-fake")
+				reply("250 AUTH PLAIN")
+			case strings.HasPrefix(cmd, "AUTH PLAIN"):
+				reply("235 ok")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				data.WriteString(strings.TrimSpace(line) + "\n")
+				reply("250 ok")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					l, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				ch <- data.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+func TestSMTPSink(t *testing.T) {
+	addr, mail := fakeSMTP(t)
+	s := &SMTPSink{Addr: addr, Username: "gw", Password: "pw", From: "gw@example.com", To: []string{"ops@example.com"}}
+	if err := s.Send(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-mail:
+		for _, want := range []string{"MAIL FROM:<gw@example.com>", "RCPT TO:<ops@example.com>", "Subject: =?UTF-8?b?", "站点：https://cr.example.com"} {
+			if !strings.Contains(m, want) {
+				t.Fatalf("mail missing %q:\n%s", want, m)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+}
@@ -0,0 +1,132 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPSink 通过 SMTP 发送邮件，服务器支持时自动使用 STARTTLS
+type SMTPSink struct {
+	// Addr 服务器地址，如 smtp.example.com:587
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTPSink) Name() string { return "smtp" }
+
+func (s *SMTPSink) Send(ctx context.Context, a *Alert) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", "[告警] "+a.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.LastAt.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(a.Text(), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, s.From, s.To, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TelegramSink 通过 Telegram 机器人发送消息
+type TelegramSink struct {
+	Token  string
+	ChatID string
+	// APIBase 默认 https://api.telegram.org
+	APIBase string
+}
+
+func (s *TelegramSink) Name() string { return "telegram" }
+
+func (s *TelegramSink) Send(ctx context.Context, a *Alert) error {
+	base := s.APIBase
+	if base == "" {
+		base = "https://api.telegram.org"
+	}
+	body, _ := json.Marshal(map[string]interface{}{"chat_id": s.ChatID, "text": a.Text(), "disable_web_page_preview": true})
+	var r struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := postJSON(ctx, base+"/bot"+s.Token+"/sendMessage", body, &r); err != nil {
+		return err
+	}
+	if !r.OK {
+		return fmt.Errorf("telegram: %s", r.Description)
+	}
+	return nil
+}
+
+// WebhookSink 以 JSON POST 到任意地址，text/content 字段可直接用于 Slack、Discord 等的入站 Webhook
+type WebhookSink struct {
+	URL string
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, a *Alert) error {
+	text := a.Text()
+	body, _ := json.Marshal(map[string]interface{}{
+		"kind":     a.Kind,
+		"title":    a.Title,
+		"count":    a.Count,
+		"window":   a.Window.String(),
+		"detail":   a.Detail,
+		"site":     a.Site,
+		"first_at": a.FirstAt.Unix(),
+		"last_at":  a.LastAt.Unix(),
+		"text":     text,
+		"content":  text,
+	})
+	return postJSON(ctx, s.URL, body, nil)
+}
+
+func postJSON(ctx context.Context, url string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("status=%d body=%s", resp.StatusCode, raw)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status=%d body=%s", resp.StatusCode, raw)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+// Package certs 为网关提供 HTTPS 证书：读取静态证书文件（文件更新后自动重新加载），
+// 或通过 ACME 自动申请与续期证书，并提供 HTTP→HTTPS 跳转。
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config HTTPS 配置，静态证书与 ACME 二选一，均未设置时不启用 HTTPS
+type Config struct {
+	// CertFile/KeyFile PEM 格式证书链与私钥
+	CertFile string
+	KeyFile  string
+
+	// Domains 通过 ACME 申请证书的域名，非空时启用 ACME
+	Domains []string
+	// DirectoryURL ACME 目录地址，默认 Let's Encrypt 正式环境
+	DirectoryURL string
+	// Email 账户联系邮箱，可选
+	Email string
+	// CacheDir 保存账户私钥与证书的目录
+	CacheDir string
+	// DirectoryCA 额外信任的根证书 PEM 文件，用于访问自签名的 ACME 服务（如 Pebble、acmemock）
+	DirectoryCA string
+}
+
+// FromEnv 读取 TLS_CERT_FILE、TLS_KEY_FILE 与 ACME_* 配置
+func FromEnv() Config {
+	c := Config{
+		CertFile:     strings.TrimSpace(os.Getenv("TLS_CERT_FILE")),
+		KeyFile:      strings.TrimSpace(os.Getenv("TLS_KEY_FILE")),
+		DirectoryURL: strings.TrimSpace(os.Getenv("ACME_DIRECTORY_URL")),
+		Email:        strings.TrimSpace(os.Getenv("ACME_EMAIL")),
+		CacheDir:     strings.TrimSpace(os.Getenv("ACME_CACHE_DIR")),
+		DirectoryCA:  strings.TrimSpace(os.Getenv("ACME_CA_FILE")),
+	}
+	for _, d := range strings.Split(os.Getenv("ACME_DOMAINS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			c.Domains = append(c.Domains, d)
+		}
+	}
+	return c
+}
+
+// Manager 按配置提供证书；nil 表示不启用 HTTPS
+type Manager struct {
+	static *keyPair
+	acme   *autocert.Manager
+}
+
+// New 校验配置并加载证书，未配置 HTTPS 时返回 nil
+func New(c Config) (*Manager, error) {
+	switch {
+	case len(c.Domains) > 0 && (c.CertFile != "" || c.KeyFile != ""):
+		return nil, errors.New("TLS_CERT_FILE/TLS_KEY_FILE 与 ACME_DOMAINS 不能同时设置")
+	case len(c.Domains) > 0:
+		return newACME(c)
+	case c.CertFile != "" || c.KeyFile != "":
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("TLS_CERT_FILE 与 TLS_KEY_FILE 需同时设置")
+		}
+		k := &keyPair{certFile: c.CertFile, keyFile: c.KeyFile, interval: 10 * time.Second}
+		if err := k.load(); err != nil {
+			return nil, err
+		}
+		return &Manager{static: k}, nil
+	}
+	return nil, nil
+}
+
+func newACME(c Config) (*Manager, error) {
+	client := &acme.Client{DirectoryURL: c.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if c.DirectoryCA != "" {
+		pem, err := os.ReadFile(c.DirectoryCA)
+		if err != nil {
+			return nil, fmt.Errorf("读取 ACME_CA_FILE 失败: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ACME_CA_FILE %s 中没有有效的证书", c.DirectoryCA)
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: tr, Timeout: time.Minute}
+	}
+	dir := c.CacheDir
+	if dir == "" {
+		dir = "./acme-cache"
+	}
+	return &Manager{acme: &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(dir),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Email:      c.Email,
+		Client:     client,
+	}}, nil
+}
+
+// Mode 证书来源，用于启动日志与配置检查
+func (m *Manager) Mode() string {
+	switch {
+	case m == nil:
+		return "未启用"
+	case m.acme != nil:
+		return "ACME " + m.acme.Client.DirectoryURL
+	default:
+		return "证书文件 " + m.static.certFile
+	}
+}
+
+// TLSConfig 用于 http.Server 的 TLS 配置，未启用时返回 nil
+func (m *Manager) TLSConfig() *tls.Config {
+	switch {
+	case m == nil:
+		return nil
+	case m.acme != nil:
+		// 包含 acme-tls/1，用于 tls-alpn-01 验证
+		c := m.acme.TLSConfig()
+		c.MinVersion = tls.VersionTLS12
+		return c
+	default:
+		return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: m.static.GetCertificate}
+	}
+}
+
+// RedirectHandler 将 HTTP 请求跳转到 https 的 httpsPort 端口；启用 ACME 时同时响应 http-01 验证
+func (m *Manager) RedirectHandler(httpsPort string) http.Handler {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if host == "" {
+			http.Error(w, "缺少 Host", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		code := http.StatusMovedPermanently
+		// 308 保留 POST 等方法与请求体，便于误配为 http 的 Cloudreve 继续下单
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+	if m != nil && m.acme != nil {
+		h = m.acme.HTTPHandler(h)
+	}
+	return h
+}
+
+// keyPair 静态证书，证书或私钥文件修改后在下次握手时重新加载
+type keyPair struct {
+	certFile, keyFile string
+	// interval 检查文件修改时间的最短间隔
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func (k *keyPair) load() error {
+	mod, err := k.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %w", err)
+	}
+	k.cert, k.modTime = &cert, mod
+	return nil
+}
+
+// stat 返回证书与私钥文件中较新的修改时间
+func (k *keyPair) stat() (time.Time, error) {
+	var mod time.Time
+	for _, f := range []string{k.certFile, k.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("读取证书文件失败: %w", err)
+		}
+		if fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	return mod, nil
+}
+
+func (k *keyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if now := time.Now(); now.Sub(k.lastCheck) >= k.interval {
+		k.lastCheck = now
+		if mod, err := k.stat(); err == nil && !mod.Equal(k.modTime) {
+			// 证书与私钥可能尚未全部写完，加载失败时继续使用旧证书，下次检查再试
+			if err := k.load(); err != nil {
+				log.Printf("[TLS] reload %s failed, keeping previous certificate: %v", k.certFile, err)
+			} else {
+				log.Printf("[TLS] reloaded %s", k.certFile)
+			}
+		}
+	}
+	return k.cert, nil
+}
@@ -0,0 +1,278 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudreve-afdianpay/internal/acmemock"
+)
+
+const domain = "pay.example.test"
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.WriteString(w, "ok")
+})
+
+// serveTLS 在随机端口上以 m 的 TLS 配置提供 okHandler，返回监听地址
+func serveTLS(t *testing.T, m *Manager) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: okHandler, TLSConfig: m.TLSConfig()}
+	go func() { _ = srv.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln.Addr().String()
+}
+
+// handshake 以 SNI domain 连接 addr，返回服务端证书
+func handshake(t *testing.T, addr string, roots *x509.CertPool) *x509.Certificate {
+	t.Helper()
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: domain, RootCAs: roots},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr, Timeout: 30 * time.Second}
+	res, err := client.Get("https://" + domain + "/")
+	if err != nil {
+		t.Fatalf("GET https://%s: %v", domain, err)
+	}
+	defer res.Body.Close()
+	if b, _ := io.ReadAll(res.Body); string(b) != "ok" {
+		t.Fatalf("body = %q", b)
+	}
+	return res.TLS.PeerCertificates[0]
+}
+
+// writeSelfSigned 生成 domain 的自签名证书并写入 certFile/keyFile
+func writeSelfSigned(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: domain},
+		DNSNames:              []string{domain},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func pool(certs ...*x509.Certificate) *x509.CertPool {
+	p := x509.NewCertPool()
+	for _, c := range certs {
+		p.AddCert(c)
+	}
+	return p
+}
+
+func TestNew(t *testing.T) {
+	if m, err := New(Config{}); m != nil || err != nil {
+		t.Fatalf("empty config = %v, %v", m, err)
+	}
+	var m *Manager
+	if m.TLSConfig() != nil || m.Mode() != "未启用" {
+		t.Fatal("nil manager should disable TLS")
+	}
+	for _, c := range []Config{
+		{CertFile: "a.pem"},
+		{KeyFile: "a.key"},
+		{CertFile: "a.pem", KeyFile: "a.key", Domains: []string{domain}},
+		{CertFile: "missing.pem", KeyFile: "missing.key"},
+		{Domains: []string{domain}, DirectoryCA: "missing.pem"},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) = nil error", c)
+		}
+	}
+}
+
+func TestStaticReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeSelfSigned(t, certFile, keyFile)
+	m, err := New(Config{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.static.interval = 0
+	addr := serveTLS(t, m)
+	if got := handshake(t, addr, pool(first)); got.SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Fatal("unexpected certificate")
+	}
+
+	// 写入一半时（私钥不匹配）继续使用旧证书
+	second := writeSelfSigned(t, certFile, filepath.Join(dir, "new-key.pem"))
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, later, later)
+	if got := handshake(t, addr, pool(first)); got.SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Fatal("broken pair should keep previous certificate")
+	}
+
+	if err := os.Rename(filepath.Join(dir, "new-key.pem"), keyFile); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(keyFile, later, later)
+	if got := handshake(t, addr, pool(second)); got.SerialNumber.Cmp(second.SerialNumber) != 0 {
+		t.Fatal("certificate not reloaded")
+	}
+}
+
+// startCA 启动 HTTPS 的 acmemock，返回 CA 与 ACME 配置
+func startCA(t *testing.T) (*acmemock.CA, Config) {
+	t.Helper()
+	ca, err := acmemock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewTLSServer(ca.Handler())
+	t.Cleanup(srv.Close)
+	caFile := filepath.Join(t.TempDir(), "acme-ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return ca, Config{
+		Domains:      []string{domain},
+		DirectoryURL: srv.URL + "/dir",
+		Email:        "admin@example.test",
+		CacheDir:     t.TempDir(),
+		DirectoryCA:  caFile,
+	}
+}
+
+func TestACMETLSALPN(t *testing.T) {
+	ca, cfg := startCA(t)
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(m.Mode(), "ACME https://127.0.0.1:") {
+		t.Fatalf("Mode = %q", m.Mode())
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.TLSAddr = ln.Addr().String()
+	srv := &http.Server{Handler: okHandler, TLSConfig: m.TLSConfig()}
+	go func() { _ = srv.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	leaf := handshake(t, ca.TLSAddr, pool(ca.Root()))
+	if leaf.DNSNames[0] != domain || len(ca.Issued()) != 1 {
+		t.Fatalf("leaf %v, issued %d", leaf.DNSNames, len(ca.Issued()))
+	}
+	handshake(t, ca.TLSAddr, pool(ca.Root()))
+	if n := len(ca.Issued()); n != 1 {
+		t.Fatalf("issued %d certificates, want 1", n)
+	}
+
+	// 重启后从缓存目录读取证书，不重新申请
+	m2, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := handshake(t, serveTLS(t, m2), pool(ca.Root()))
+	if got.SerialNumber.Cmp(leaf.SerialNumber) != 0 || len(ca.Issued()) != 1 {
+		t.Fatal("cached certificate not reused")
+	}
+
+	// 不在 ACME_DOMAINS 中的域名不申请证书
+	conn, err := tls.Dial("tcp", ca.TLSAddr, &tls.Config{ServerName: "other.example.test", RootCAs: pool(ca.Root())})
+	if err == nil {
+		conn.Close()
+		t.Fatal("handshake for unlisted host succeeded")
+	}
+}
+
+func TestACMEHTTP01(t *testing.T) {
+	ca, cfg := startCA(t)
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tls-alpn-01 无法连通时改用 http-01，由跳转服务响应
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.TLSAddr = closed.Addr().String()
+	closed.Close()
+	redirect := httptest.NewServer(m.RedirectHandler("443"))
+	t.Cleanup(redirect.Close)
+	ca.HTTPAddr = strings.TrimPrefix(redirect.URL, "http://")
+
+	leaf := handshake(t, serveTLS(t, m), pool(ca.Root()))
+	if leaf.DNSNames[0] != domain || len(ca.Issued()) != 1 {
+		t.Fatalf("leaf %v, issued %d", leaf.DNSNames, len(ca.Issued()))
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	cases := []struct {
+		method, host, path, port string
+		code                     int
+		want                     string
+	}{
+		{"GET", "pay.example.com", "/order/1?x=1", "443", 301, "https://pay.example.com/order/1?x=1"},
+		{"GET", "pay.example.com:80", "/", "", 301, "https://pay.example.com/"},
+		{"HEAD", "pay.example.com:8080", "/pay/1", "9443", 301, "https://pay.example.com:9443/pay/1"},
+		{"POST", "pay.example.com", "/order", "443", 308, "https://pay.example.com/order"},
+		{"GET", "[::1]:80", "/", "9443", 301, "https://[::1]:9443/"},
+		{"GET", "[::1]", "/", "443", 301, "https://[::1]/"},
+	}
+	var m *Manager
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, nil)
+		r.Host = c.host
+		m.RedirectHandler(c.port).ServeHTTP(w, r)
+		if w.Code != c.code || w.Header().Get("Location") != c.want {
+			t.Errorf("%s %s%s -> %d %s, want %d %s", c.method, c.host, c.path, w.Code, w.Header().Get("Location"), c.code, c.want)
+		}
+	}
+}
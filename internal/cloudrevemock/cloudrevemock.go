@@ -0,0 +1,208 @@
+// Package cloudrevemock 模拟 Cloudreve 站点，用于网关的端到端测试。
+//
+// Site 按 Cloudreve 通信签名规则向网关发送 POST/GET /order 请求，
+// 并提供 notify 回调地址，记录网关的每次到账通知。
+package cloudrevemock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudreve-afdianpay/internal/signature"
+)
+
+// notifyPath Cloudreve 自定义支付的回调路径前缀
+const notifyPath = "/api/v4/callback/custom/"
+
+// OrderRequest Cloudreve 创建订单请求体
+type OrderRequest struct {
+	Name      string `json:"name"`
+	OrderNo   string `json:"order_no"`
+	NotifyURL string `json:"notify_url"`
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+}
+
+// Response 网关响应，创建订单时 Data 为支付页地址，查询时为 PAID/UNPAID
+type Response struct {
+	Code  int    `json:"code"`
+	Data  string `json:"data"`
+	Error string `json:"error"`
+}
+
+// NotifyCall 一次到账通知
+type NotifyCall struct {
+	OrderNo string
+	Path    string
+	// Failed 该次调用被 FailNotify 设为失败
+	Failed bool
+	At     time.Time
+}
+
+// Site 模拟的 Cloudreve 站点
+type Site struct {
+	// URL 站点地址，作为 X-Cr-Site-Url 发送，需与网关 SITE_URL 一致
+	URL string
+	// Key 通信密钥，需与网关 COMMUNICATION_KEY 一致
+	Key string
+	// Gateway 网关地址，如 http://127.0.0.1:9800
+	Gateway string
+	// NotifyBase notify 回调服务的地址，用于生成 notify_url
+	NotifyBase string
+	// Expires 签名有效期，默认 5 分钟
+	Expires time.Duration
+	Client  *http.Client
+
+	mu       sync.Mutex
+	calls    []NotifyCall
+	failNext int
+}
+
+// New 创建模拟站点
+func New(siteURL, key, gateway string) *Site {
+	return &Site{
+		URL:     siteURL,
+		Key:     key,
+		Gateway: strings.TrimRight(gateway, "/"),
+		Expires: 5 * time.Minute,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Handler 返回 notify 回调路由，需以 NotifyBase 对外提供
+func (s *Site) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(notifyPath, s.notify)
+	return mux
+}
+
+// NotifyURL 返回订单的 notify_url，格式与 Cloudreve 一致
+func (s *Site) NotifyURL(orderNo string) string {
+	return strings.TrimRight(s.NotifyBase, "/") + notifyPath + url.PathEscape(orderNo) + "/1"
+}
+
+// FailNotify 使接下来 n 次通知返回失败，用于测试网关重试
+func (s *Site) FailNotify(n int) {
+	s.mu.Lock()
+	s.failNext = n
+	s.mu.Unlock()
+}
+
+// Calls 返回全部通知记录
+func (s *Site) Calls() []NotifyCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]NotifyCall(nil), s.calls...)
+}
+
+// Notified 返回订单成功通知的次数
+func (s *Site) Notified(orderNo string) int {
+	n := 0
+	for _, c := range s.Calls() {
+		if c.OrderNo == orderNo && !c.Failed {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Site) notify(w http.ResponseWriter, r *http.Request) {
+	orderNo := strings.SplitN(strings.TrimPrefix(r.URL.Path, notifyPath), "/", 2)[0]
+	if v, err := url.PathUnescape(orderNo); err == nil {
+		orderNo = v
+	}
+	s.mu.Lock()
+	call := NotifyCall{OrderNo: orderNo, Path: r.URL.Path, At: time.Now()}
+	if s.failNext > 0 {
+		s.failNext--
+		call.Failed = true
+	}
+	s.calls = append(s.calls, call)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if call.Failed {
+		io.WriteString(w, `{"code":500,"msg":"模拟通知失败"}`)
+		return
+	}
+	log.Printf("[CloudreveMock] notified order_no=%s", orderNo)
+	io.WriteString(w, `{"code":0}`)
+}
+
+// NewCreateRequest 构造已签名的创建订单请求
+func (s *Site) NewCreateRequest(req OrderRequest) (*http.Request, error) {
+	if req.NotifyURL == "" {
+		req.NotifyURL = s.NotifyURL(req.OrderNo)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, s.Gateway+"/order", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Cr-Site-Url", s.URL)
+	r.Header.Set("X-Cr-Version", "4.0.0")
+	signature.Sign(r, s.Key, time.Now().Add(s.Expires))
+	return r, nil
+}
+
+// NewQueryRequest 构造已签名的查询订单请求，签名以 sign 参数携带
+func (s *Site) NewQueryRequest(orderNo string) (*http.Request, error) {
+	r, err := http.NewRequest(http.MethodGet, s.Gateway+"/order", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("X-Cr-Site-Url", s.URL)
+	q := url.Values{}
+	q.Set("order_no", orderNo)
+	q.Set("sign", signature.Token(r, s.Key, time.Now().Add(s.Expires)))
+	r.URL.RawQuery = q.Encode()
+	return r, nil
+}
+
+// CreateOrder 向网关创建订单，notify_url 留空时指向本站点
+func (s *Site) CreateOrder(req OrderRequest) (*Response, error) {
+	r, err := s.NewCreateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return s.Do(r)
+}
+
+// QueryOrder 向网关查询订单状态
+func (s *Site) QueryOrder(orderNo string) (*Response, error) {
+	r, err := s.NewQueryRequest(orderNo)
+	if err != nil {
+		return nil, err
+	}
+	return s.Do(r)
+}
+
+// Do 发送请求并解析网关响应
+func (s *Site) Do(r *http.Request) (*Response, error) {
+	resp, err := s.Client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var out Response
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("网关响应不是 JSON: status=%d body=%s", resp.StatusCode, body)
+	}
+	return &out, nil
+}
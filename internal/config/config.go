@@ -4,6 +4,8 @@ import (
 	"errors"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 // ValidateEnv 检查必须的环境变量
@@ -29,3 +31,17 @@ func ValidateEnv() error {
 	}
 	return nil
 }
+
+// Duration 读取时长类环境变量（如 "30m"、"24h"），未设置或格式错误时返回默认值
+func Duration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[Config] invalid %s=%q, using default %s", key, v, def)
+		return def
+	}
+	return d
+}
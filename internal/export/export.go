@@ -0,0 +1,100 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+)
+
+// 导出格式
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrUnknownFormat = errors.New("未知的导出格式")
+
+// columns 导出字段，CSV 表头与 NDJSON 键名一致
+var columns = []string{
+	"site", "order_no", "status",
+	"original_amount", "original_currency", "cny_amount",
+	"out_trade_no", "sponsor_user_id", "sponsor_name", "plan_id", "sku_id",
+	"created_at", "paid_at", "notified_at",
+}
+
+// Writer 逐条写出订单
+type Writer interface {
+	Write(o *afdian.Order) error
+	Flush() error
+}
+
+// ContentType 返回格式对应的 MIME 类型
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// NewWriter 创建指定格式的导出写入器
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV, "":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+func record(o *afdian.Order) []string {
+	return []string{
+		o.Site, o.OrderNo, o.Status,
+		o.Original.String(), string(o.Original.Currency), o.Amount.String(),
+		o.OutTradeNo, o.SponsorUserID, o.SponsorName, o.PlanID, o.SkuID,
+		formatTime(o.CreatedAt), formatTime(o.PaidAt), formatTime(o.NotifiedAt),
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(o *afdian.Order) error {
+	return c.w.Write(record(o))
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(o *afdian.Order) error {
+	values := record(o)
+	m := make(map[string]string, len(columns))
+	for i, k := range columns {
+		m[k] = values[i]
+	}
+	return n.enc.Encode(m)
+}
+
+func (n *ndjsonWriter) Flush() error { return nil }
@@ -0,0 +1,110 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/money"
+)
+
+var cst = time.FixedZone("CST", 8*3600)
+
+func testOrders() []*afdian.Order {
+	return []*afdian.Order{
+		{
+			Site: "https://cr.example.com", OrderNo: "o-1", Status: afdian.StatusPaid,
+			Original: money.New(1000, "USD"), Amount: money.New(7235, money.CNY),
+			OutTradeNo: "T-1", SponsorUserID: "u-1", SponsorName: "Zhang, \"San\"", PlanID: "p-1", SkuID: "s-1",
+			CreatedAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, cst),
+			PaidAt:     time.Date(2024, 1, 2, 3, 5, 0, 0, cst),
+			NotifiedAt: time.Date(2024, 1, 2, 3, 5, 1, 0, cst),
+		},
+		{
+			// 未支付订单：支付信息与时间为空
+			Site: "https://cr.example.com", OrderNo: "o-2", Status: afdian.StatusPending,
+			Original: money.New(500, money.CNY), Amount: money.New(500, money.CNY),
+			CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, cst),
+		},
+	}
+}
+
+func writeAll(t *testing.T, format string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range testOrders() {
+		if err := w.Write(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCSV(t *testing.T) {
+	rows, err := csv.NewReader(strings.NewReader(writeAll(t, FormatCSV))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("rows = %d, want header + 2", len(rows))
+	}
+	if got := strings.Join(rows[0], ","); got != "site,order_no,status,original_amount,original_currency,cny_amount,out_trade_no,sponsor_user_id,sponsor_name,plan_id,sku_id,created_at,paid_at,notified_at" {
+		t.Fatalf("header = %s", got)
+	}
+	want := []string{
+		"https://cr.example.com", "o-1", "paid", "10.00", "USD", "72.35",
+		"T-1", "u-1", `Zhang, "San"`, "p-1", "s-1",
+		"2024-01-02T03:04:05+08:00", "2024-01-02T03:05:00+08:00", "2024-01-02T03:05:01+08:00",
+	}
+	if strings.Join(rows[1], "|") != strings.Join(want, "|") {
+		t.Fatalf("row = %q\nwant  %q", rows[1], want)
+	}
+	if r := rows[2]; r[3] != "5.00" || r[4] != "CNY" || r[6] != "" || r[11] != "2024-01-03T00:00:00+08:00" || r[12] != "" || r[13] != "" {
+		t.Fatalf("pending row = %q", r)
+	}
+	// 未指定格式时为 CSV
+	if writeAll(t, "") != writeAll(t, FormatCSV) {
+		t.Fatal("default format is not CSV")
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSuffix(writeAll(t, FormatNDJSON), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %d, want 2", len(lines))
+	}
+	var m map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != len(columns) {
+		t.Fatalf("keys = %d, want %d", len(m), len(columns))
+	}
+	if m["order_no"] != "o-1" || m["original_amount"] != "10.00" || m["cny_amount"] != "72.35" || m["sponsor_name"] != `Zhang, "San"` || m["paid_at"] != "2024-01-02T03:05:00+08:00" {
+		t.Fatalf("record = %v", m)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil || m["paid_at"] != "" || m["status"] != "pending" {
+		t.Fatalf("pending record = %v, %v", m, err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if _, err := NewWriter(&bytes.Buffer{}, "xlsx"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("unknown format err = %v", err)
+	}
+	if ContentType(FormatNDJSON) != "application/x-ndjson" || !strings.HasPrefix(ContentType(FormatCSV), "text/csv") {
+		t.Fatal("unexpected content type")
+	}
+}
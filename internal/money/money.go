@@ -0,0 +1,223 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount       = errors.New("无效的金额")
+	ErrPrecision           = errors.New("金额精度超出货币最小单位")
+	ErrUnsupportedCurrency = errors.New("不支持的货币")
+	ErrCurrencyMismatch    = errors.New("货币不一致")
+	ErrInvalidRate         = errors.New("无效的汇率")
+)
+
+// Currency ISO 4217 货币代码（大写）
+type Currency string
+
+const CNY Currency = "CNY"
+
+// ParseCurrency 规范化货币代码并检查是否受支持（见 Configure）
+func ParseCurrency(code string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(code)))
+	r := current.Load()
+	if _, ok := r.exponents[c]; !ok || (r.enabled != nil && !r.enabled[c]) {
+		return "", ErrUnsupportedCurrency
+	}
+	return c, nil
+}
+
+// Exponent 返回最小单位的小数位数（ISO 4217），未知货币按 2 位处理
+func (c Currency) Exponent() int {
+	if e, ok := current.Load().exponents[c]; ok {
+		return e
+	}
+	return 2
+}
+
+// Amount 以最小单位（如分）保存的金额，避免浮点误差
+type Amount struct {
+	Minor    int64
+	Currency Currency
+}
+
+// New 由最小单位数值构造金额（即 Cloudreve 传入的 amount）
+func New(minor int64, cur Currency) Amount {
+	return Amount{Minor: minor, Currency: cur}
+}
+
+// FromFloat 由基础单位浮点数构造金额，按最小单位四舍五入
+func FromFloat(f float64, cur Currency) Amount {
+	return Amount{Minor: int64(math.Round(f * pow10(cur.Exponent()))), Currency: cur}
+}
+
+// Parse 解析爱发电使用的十进制字符串（如 "5.00"）
+func Parse(s string, cur Currency) (Amount, error) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, ErrInvalidAmount
+	}
+	exp := cur.Exponent()
+	if len(fracPart) > exp {
+		if strings.Trim(fracPart[exp:], "0") != "" {
+			return Amount{}, ErrPrecision
+		}
+		fracPart = fracPart[:exp]
+	}
+	fracPart += strings.Repeat("0", exp-len(fracPart))
+	digits := strings.TrimLeft(intPart+fracPart, "0")
+	if len(digits) > 18 {
+		return Amount{}, ErrInvalidAmount
+	}
+	var minor int64
+	for i := 0; i < len(digits); i++ {
+		minor = minor*10 + int64(digits[i]-'0')
+	}
+	if neg {
+		minor = -minor
+	}
+	return Amount{Minor: minor, Currency: cur}, nil
+}
+
+// Convert 按汇率换算为 to 币种，结果按 to 的最小单位四舍五入（0.5 远离零）。
+// rate 为 1 个 a.Currency 基础单位兑换的 to 基础单位数量，使用十进制字符串避免浮点误差；
+// 两种货币的小数位数不同（如 JPY 0 位、KWD 3 位）时按各自的最小单位换算
+func Convert(a Amount, rate string, to Currency) (Amount, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(rate))
+	if !ok || r.Sign() <= 0 {
+		return Amount{}, ErrInvalidRate
+	}
+	v := new(big.Rat).SetInt64(a.Minor)
+	v.Mul(v, r)
+	v.Mul(v, ratPow10(to.Exponent()-a.Currency.Exponent()))
+	return roundRat(v, to)
+}
+
+// Round 将十进制字符串按 cur 的最小单位四舍五入（0.5 远离零），用于汇率接口返回的换算结果
+func Round(s string, cur Currency) (Amount, error) {
+	v, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Amount{}, ErrInvalidAmount
+	}
+	v.Mul(v, ratPow10(cur.Exponent()))
+	return roundRat(v, cur)
+}
+
+func ratPow10(n int) *big.Rat {
+	p := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(n))), nil)
+	if n < 0 {
+		return new(big.Rat).SetFrac(big.NewInt(1), p)
+	}
+	return new(big.Rat).SetInt(p)
+}
+
+func roundRat(v *big.Rat, cur Currency) (Amount, error) {
+	num := new(big.Int).Abs(v.Num())
+	q, m := new(big.Int).QuoRem(num, v.Denom(), new(big.Int))
+	if m.Lsh(m, 1).Cmp(v.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if v.Sign() < 0 {
+		q.Neg(q)
+	}
+	if !q.IsInt64() {
+		return Amount{}, ErrInvalidAmount
+	}
+	return Amount{Minor: q.Int64(), Currency: cur}, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ParseAny 解析 JSON 中的金额字段，兼容字符串与数字两种形式
+func ParseAny(v interface{}, cur Currency) (Amount, error) {
+	switch x := v.(type) {
+	case string:
+		return Parse(x, cur)
+	case json.Number:
+		return Parse(x.String(), cur)
+	case float64:
+		return Parse(strconv.FormatFloat(x, 'f', -1, 64), cur)
+	case int64:
+		return Parse(strconv.FormatInt(x, 10), cur)
+	case int:
+		return Parse(strconv.Itoa(x), cur)
+	case nil:
+		return Amount{}, ErrInvalidAmount
+	}
+	return Parse(fmt.Sprintf("%v", v), cur)
+}
+
+// String 按货币小数位格式化为十进制字符串（如 "5.00"）
+func (a Amount) String() string {
+	exp := a.Currency.Exponent()
+	minor := a.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	digits := strconv.FormatInt(minor, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// Float 返回基础单位的浮点值，仅用于调用外部汇率接口
+func (a Amount) Float() float64 {
+	return float64(a.Minor) / pow10(a.Currency.Exponent())
+}
+
+// Equal 货币与金额均相同
+func (a Amount) Equal(b Amount) bool {
+	return a.Currency == b.Currency && a.Minor == b.Minor
+}
+
+// Cmp 比较两个同币种金额，a<b 返回 -1，相等返回 0，a>b 返回 1
+func (a Amount) Cmp(b Amount) (int, error) {
+	if a.Currency != b.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case a.Minor < b.Minor:
+		return -1, nil
+	case a.Minor > b.Minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(n int) float64 {
+	return math.Pow10(n)
+}
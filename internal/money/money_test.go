@@ -0,0 +1,225 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		s    string
+		cur  Currency
+		want int64
+		err  error
+	}{
+		{"5.00", CNY, 500, nil},
+		{"5", CNY, 500, nil},
+		{"5.1", CNY, 510, nil},
+		{" 0.01 ", CNY, 1, nil},
+		{"+3.20", CNY, 320, nil},
+		{"-3.20", CNY, -320, nil},
+		// 超出最小单位的尾随 0 可以省略
+		{"5.0000", CNY, 500, nil},
+		{"5.001", CNY, 0, ErrPrecision},
+		{"1000", "JPY", 1000, nil},
+		{"1000.5", "JPY", 0, ErrPrecision},
+		{"", CNY, 0, ErrInvalidAmount},
+		{".5", CNY, 0, ErrInvalidAmount},
+		{"1e3", CNY, 0, ErrInvalidAmount},
+		{"1,000", CNY, 0, ErrInvalidAmount},
+		{"99999999999999999999", CNY, 0, ErrInvalidAmount},
+	}
+	for _, c := range cases {
+		got, err := Parse(c.s, c.cur)
+		if !errors.Is(err, c.err) || (err == nil && got != New(c.want, c.cur)) {
+			t.Errorf("Parse(%q, %s) = %v, %v; want %d, %v", c.s, c.cur, got.Minor, err, c.want, c.err)
+		}
+	}
+}
+
+func TestParseAny(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want int64
+	}{
+		{"5.00", 500},
+		{json.Number("12.34"), 1234},
+		// 浮点数按最短十进制表示解析，19.99 不会变成 1998
+		{float64(19.99), 1999},
+		{float64(1.005), 0},
+		{int64(7), 700},
+		{8, 800},
+	}
+	for _, c := range cases {
+		got, err := ParseAny(c.v, CNY)
+		if c.want == 0 {
+			if !errors.Is(err, ErrPrecision) {
+				t.Errorf("ParseAny(%v) = %v, %v; want ErrPrecision", c.v, got, err)
+			}
+			continue
+		}
+		if err != nil || got.Minor != c.want {
+			t.Errorf("ParseAny(%v) = %v, %v; want %d", c.v, got.Minor, err, c.want)
+		}
+	}
+	if _, err := ParseAny(nil, CNY); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("ParseAny(nil) err = %v", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	cases := []struct {
+		a    Amount
+		want string
+	}{
+		{New(500, CNY), "5.00"},
+		{New(1, CNY), "0.01"},
+		{New(0, CNY), "0.00"},
+		{New(-320, CNY), "-3.20"},
+		{New(1000, "JPY"), "1000"},
+		{FromFloat(19.99, CNY), "19.99"},
+	}
+	for _, c := range cases {
+		if got := c.a.String(); got != c.want {
+			t.Errorf("%v.String() = %q, want %q", c.a, got, c.want)
+		}
+	}
+	// String 与 Parse 互逆
+	for _, s := range []string{"0.00", "0.05", "123456.78", "-0.99"} {
+		if a, err := Parse(s, CNY); err != nil || a.String() != s {
+			t.Errorf("round trip %q = %q, %v", s, a.String(), err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	if c, err := New(499, CNY).Cmp(New(500, CNY)); err != nil || c != -1 {
+		t.Errorf("Cmp less = %d, %v", c, err)
+	}
+	if c, err := New(500, CNY).Cmp(New(500, CNY)); err != nil || c != 0 {
+		t.Errorf("Cmp equal = %d, %v", c, err)
+	}
+	if c, err := New(501, CNY).Cmp(New(500, CNY)); err != nil || c != 1 {
+		t.Errorf("Cmp greater = %d, %v", c, err)
+	}
+	if _, err := New(500, CNY).Cmp(New(500, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Cmp currency mismatch err = %v", err)
+	}
+	if New(500, CNY).Equal(New(500, "USD")) || !New(500, CNY).Equal(New(500, CNY)) {
+		t.Error("Equal ignores currency")
+	}
+	if c, err := ParseCurrency(" usd "); err != nil || c != "USD" {
+		t.Errorf("ParseCurrency = %q, %v", c, err)
+	}
+	if _, err := ParseCurrency("XXX"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("ParseCurrency(XXX) err = %v", err)
+	}
+}
+func TestRegistry(t *testing.T) {
+	t.Cleanup(func() { _ = Configure("", "", "") })
+	for code, exp := range map[Currency]int{"CNY": 2, "TWD": 2, "THB": 2, "MYR": 2, "JPY": 0, "KRW": 0, "KWD": 3, "CLF": 4} {
+		if _, err := ParseCurrency(string(code)); err != nil {
+			t.Errorf("ParseCurrency(%s) = %v", code, err)
+		}
+		if got := code.Exponent(); got != exp {
+			t.Errorf("%s.Exponent() = %d, want %d", code, got, exp)
+		}
+	}
+	for _, code := range []string{"XXX", "XAU", "", "US", "usdx"} {
+		if _, err := ParseCurrency(code); !errors.Is(err, ErrUnsupportedCurrency) {
+			t.Errorf("ParseCurrency(%q) = %v", code, err)
+		}
+	}
+	if c, err := ParseCurrency(" thb "); err != nil || c != "THB" {
+		t.Fatalf("ParseCurrency lower case = %q, %v", c, err)
+	}
+
+	if err := Configure("usd, TWD", "", "TWD=0,ABC=1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := Enabled(); len(got) != 3 || got[0] != "CNY" || got[1] != "TWD" || got[2] != "USD" {
+		t.Fatalf("Enabled = %v", got)
+	}
+	if _, err := ParseCurrency("JPY"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Fatalf("disabled JPY: %v", err)
+	}
+	// 禁用的货币仍按 ISO 4217 显示金额
+	if Currency("JPY").Exponent() != 0 || Currency("TWD").Exponent() != 0 || Currency("ABC").Exponent() != 1 {
+		t.Fatal("exponent override not applied")
+	}
+
+	if err := Configure("", "USD,cny", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseCurrency("USD"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Fatalf("disabled USD: %v", err)
+	}
+	// CNY 不可禁用
+	for _, code := range []string{"CNY", "EUR"} {
+		if _, err := ParseCurrency(code); err != nil {
+			t.Fatalf("ParseCurrency(%s) = %v", code, err)
+		}
+	}
+
+	for _, bad := range [][3]string{{"ZZZ", "", ""}, {"", "ZZZ", ""}, {"", "", "TWD"}, {"", "", "TWD=5"}, {"", "", "TW=1"}} {
+		if err := Configure(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("Configure(%q) = nil", bad)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		amount Amount
+		rate   string
+		want   int64
+	}{
+		{New(1000, "USD"), "7.2345", 7235},
+		// 0.5 分远离零进位，浮点计算 0.125*100 会得到 12.499…
+		{New(1, "USD"), "0.125", 0},
+		{New(5, "USD"), "0.125", 1},
+		{New(100, "EUR"), "7.805", 781},
+		// JPY 无小数位：1000 日元 × 0.04835 = 48.35 元
+		{New(1000, "JPY"), "0.04835", 4835},
+		// KWD 3 位小数：1.234 第纳尔 × 23.5 = 28.999 元
+		{New(1234, "KWD"), "23.5", 2900},
+		{New(15000, "TWD"), "0.2245", 3368},
+		{New(2, "CLF"), "255.5", 5},
+	}
+	for _, c := range cases {
+		got, err := Convert(c.amount, c.rate, CNY)
+		if err != nil || got != New(c.want, CNY) {
+			t.Errorf("Convert(%s %s, %s) = %v, %v; want %d", c.amount, c.amount.Currency, c.rate, got, err, c.want)
+		}
+	}
+	for _, rate := range []string{"", "abc", "0", "-1"} {
+		if _, err := Convert(New(100, "USD"), rate, CNY); !errors.Is(err, ErrInvalidRate) {
+			t.Errorf("Convert rate %q err = %v", rate, err)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	cases := []struct {
+		s    string
+		cur  Currency
+		want int64
+	}{
+		{"72.345", CNY, 7235},
+		{"72.344999", CNY, 7234},
+		{"0.005", CNY, 1},
+		{"1.5", "JPY", 2},
+		{"-1.005", CNY, -101},
+		{"7.2e1", CNY, 7200},
+	}
+	for _, c := range cases {
+		got, err := Round(c.s, c.cur)
+		if err != nil || got.Minor != c.want || got.Currency != c.cur {
+			t.Errorf("Round(%q, %s) = %v, %v; want %d", c.s, c.cur, got, err, c.want)
+		}
+	}
+	if _, err := Round("abc", CNY); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Round(abc) err = %v", err)
+	}
+}
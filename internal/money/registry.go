@@ -0,0 +1,132 @@
+package money
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+//go:embed iso4217.txt
+var iso4217 string
+
+// registry 已知货币的小数位数与允许下单的货币
+type registry struct {
+	exponents map[Currency]int
+	// enabled 为 nil 表示全部已知货币均可下单
+	enabled map[Currency]bool
+}
+
+var current atomic.Pointer[registry]
+
+func init() {
+	current.Store(&registry{exponents: loadISO4217()})
+}
+
+func loadISO4217() map[Currency]int {
+	m := make(map[Currency]int, 180)
+	for i, line := range strings.Split(iso4217, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		code, exp, ok := strings.Cut(line, " ")
+		n, err := strconv.Atoi(strings.TrimSpace(exp))
+		if !ok || err != nil || !validCode(code) {
+			panic(fmt.Sprintf("iso4217.txt 第 %d 行格式错误: %q", i+1, line))
+		}
+		m[Currency(code)] = n
+	}
+	return m
+}
+
+func validCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		if code[i] < 'A' || code[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Configure 按配置调整货币表，参数均为逗号分隔的列表，空字符串表示不调整：
+// enable 只允许这些货币下单；disable 禁止这些货币下单；
+// exponents 为 "代码=小数位数"，覆盖或补充 ISO 4217 数据，如 "TWD=0"。
+// 禁用的货币仍保留小数位数，已有订单的金额照常显示
+func Configure(enable, disable, exponents string) error {
+	r := &registry{exponents: loadISO4217()}
+	for _, item := range splitList(exponents) {
+		code, exp, ok := strings.Cut(item, "=")
+		code = strings.ToUpper(strings.TrimSpace(code))
+		n, err := strconv.Atoi(strings.TrimSpace(exp))
+		if !ok || err != nil || n < 0 || n > 4 || !validCode(code) {
+			return fmt.Errorf("货币小数位数 %q 格式应为 代码=0~4", item)
+		}
+		r.exponents[Currency(code)] = n
+	}
+	parse := func(list string) ([]Currency, error) {
+		var out []Currency
+		for _, code := range splitList(list) {
+			c := Currency(strings.ToUpper(code))
+			if _, ok := r.exponents[c]; !ok {
+				return nil, fmt.Errorf("未知的货币 %q", code)
+			}
+			out = append(out, c)
+		}
+		return out, nil
+	}
+	enabled, err := parse(enable)
+	if err != nil {
+		return err
+	}
+	disabled, err := parse(disable)
+	if err != nil {
+		return err
+	}
+	if len(enabled) > 0 || len(disabled) > 0 {
+		r.enabled = make(map[Currency]bool, len(r.exponents))
+		if len(enabled) == 0 {
+			for c := range r.exponents {
+				r.enabled[c] = true
+			}
+		}
+		for _, c := range enabled {
+			r.enabled[c] = true
+		}
+		for _, c := range disabled {
+			delete(r.enabled, c)
+		}
+		// 网关以 CNY 向爱发电下单，不可禁用
+		r.enabled[CNY] = true
+	}
+	current.Store(r)
+	return nil
+}
+
+// Enabled 允许下单的货币，按代码排序
+func Enabled() []Currency {
+	r := current.Load()
+	out := make([]Currency, 0, len(r.exponents))
+	for c := range r.exponents {
+		if r.enabled == nil || r.enabled[c] {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
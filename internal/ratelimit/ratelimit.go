@@ -0,0 +1,133 @@
+// Package ratelimit 按键（站点、客户端 IP）的令牌桶限流，状态保存在进程内
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rule 令牌桶规则：容量 Burst，每 Per 时间补满 Burst 个令牌；零值表示不限流
+type Rule struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseRule 解析 "次数/周期" 格式，如 "60/1m"；空字符串或次数为 0 表示不限流
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Rule{}, nil
+	}
+	n, per, ok := strings.Cut(s, "/")
+	burst, err := strconv.Atoi(strings.TrimSpace(n))
+	if !ok || err != nil || burst < 0 {
+		return Rule{}, fmt.Errorf("限流规则 %q 格式应为 次数/周期，如 60/1m", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(per))
+	if err != nil || d <= 0 {
+		return Rule{}, fmt.Errorf("限流规则 %q 的周期无效", s)
+	}
+	if burst == 0 {
+		return Rule{}, nil
+	}
+	return Rule{Burst: burst, Per: d}, nil
+}
+
+func (r Rule) Enabled() bool {
+	return r.Burst > 0 && r.Per > 0
+}
+
+func (r Rule) String() string {
+	if !r.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Burst, r.Per)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter 按键独立计数的令牌桶，nil 或规则为零值时不限流
+type Limiter struct {
+	Rule Rule
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+	throttled atomic.Int64
+}
+
+func New(rule Rule) *Limiter {
+	return &Limiter{Rule: rule}
+}
+
+// Allow 消耗 key 的一个令牌；令牌不足时返回 false 与可重试的等待时间
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || !l.Rule.Enabled() {
+		return true, 0
+	}
+	rate := float64(l.Rule.Burst) / l.Rule.Per.Seconds()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	l.sweep(now)
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(l.Rule.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	l.throttled.Add(1)
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep 每个周期清理一次已补满的桶，避免大量不同 IP 占用内存
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Rule.Per {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.Rule.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Throttled 被拒绝的请求数
+func (l *Limiter) Throttled() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.throttled.Load()
+}
+
+// Keys 当前跟踪的键数量
+func (l *Limiter) Keys() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Rule
+		err  bool
+	}{
+		{"60/1m", Rule{60, time.Minute}, false},
+		{" 5 / 10s ", Rule{5, 10 * time.Second}, false},
+		{"", Rule{}, false},
+		{"0", Rule{}, false},
+		{"0/1m", Rule{}, false},
+		{"60", Rule{}, true},
+		{"x/1m", Rule{}, true},
+		{"-1/1m", Rule{}, true},
+		{"60/0s", Rule{}, true},
+		{"60/abc", Rule{}, true},
+	}
+	for _, c := range cases {
+		got, err := ParseRule(c.in)
+		if got != c.want || (err != nil) != c.err {
+			t.Errorf("ParseRule(%q) = %v, %v", c.in, got, err)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := New(Rule{Burst: 3, Per: 3 * time.Second})
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d throttled within burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != time.Second {
+		t.Fatalf("over burst = %v, wait %s; want false, 1s", ok, wait)
+	}
+	// 其他键独立计数
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("key b throttled")
+	}
+	// 每秒补充一个令牌
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("refilled token not available")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("only one token should be refilled")
+	}
+	if got := l.Throttled(); got != 2 {
+		t.Fatalf("Throttled = %d, want 2", got)
+	}
+
+	// 空闲超过一个周期的桶已补满，清理后不影响结果
+	now = now.Add(10 * time.Second)
+	if ok, _ := l.Allow("c"); !ok || l.Keys() != 1 {
+		t.Fatalf("after sweep keys = %d", l.Keys())
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	var nilLimiter *Limiter
+	if ok, _ := nilLimiter.Allow("a"); !ok || nilLimiter.Throttled() != 0 || nilLimiter.Keys() != 0 {
+		t.Fatal("nil limiter should allow")
+	}
+	l := New(Rule{})
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatal("disabled limiter throttled")
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/export"
+	"cloudreve-afdianpay/internal/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth 校验管理接口令牌（Authorization: Bearer <ADMIN_TOKEN>），未配置 ADMIN_TOKEN 时管理接口关闭
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"code": 404, "error": "管理接口未启用"})
+			return
+		}
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			log.Printf("[Admin] unauthorized %s %s", c.Request.Method, c.FullPath())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "error": "管理令牌无效"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RefundOrder 人工登记退款，可选通知 Cloudreve 回收
+func (s *Server) RefundOrder(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	var body struct {
+		// Amount 十进制 CNY 金额，留空表示全额
+		Amount string `json:"amount"`
+		Reason string `json:"reason"`
+		Revoke *bool  `json:"revoke"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(200, gin.H{"code": 400, "error": "请求体格式错误"})
+		return
+	}
+	var amount money.Amount
+	if body.Amount != "" {
+		a, err := money.Parse(body.Amount, money.CNY)
+		if err != nil {
+			c.JSON(200, gin.H{"code": 400, "error": "无效的退款金额"})
+			return
+		}
+		amount = a
+	}
+	revoke := body.Revoke == nil || *body.Revoke
+	r, err := s.Svc.RecordRefund(orderNo, amount, body.Reason, afdian.RefundSourceAdmin, revoke)
+	if err != nil {
+		switch {
+		case errors.Is(err, afdian.ErrOrderNotFound):
+			c.JSON(200, gin.H{"code": 404, "error": err.Error()})
+		case errors.Is(err, afdian.ErrOrderNotPaid), errors.Is(err, afdian.ErrAlreadyRefunded), errors.Is(err, afdian.ErrRefundExceedsPaid):
+			c.JSON(200, gin.H{"code": 409, "error": err.Error()})
+		default:
+			log.Printf("[Admin] refund error order_no=%s: %v", orderNo, err)
+			c.JSON(200, gin.H{"code": 500, "error": "退款登记失败"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": refundView(r)})
+}
+
+// ListRefunds 查询订单的退款记录
+func (s *Server) ListRefunds(c *gin.Context) {
+	list, err := s.Svc.ListRefunds(c.Param("order_no"))
+	if err != nil {
+		log.Printf("[Admin] list refunds error: %v", err)
+		c.JSON(200, gin.H{"code": 500, "error": "查询退款记录失败"})
+		return
+	}
+	data := make([]gin.H, 0, len(list))
+	for i := range list {
+		data = append(data, refundView(&list[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": data})
+}
+
+func refundView(r *afdian.Refund) gin.H {
+	return gin.H{
+		"id":            r.ID,
+		"order_no":      r.OrderNo,
+		"amount":        r.Amount.String(),
+		"currency":      string(r.Amount.Currency),
+		"reason":        r.Reason,
+		"source":        r.Source,
+		"revoke_status": r.RevokeStatus,
+		"created_at":    r.CreatedAt.Unix(),
+	}
+}
+
+// ListOrders 按状态或关键字（订单号、爱发电订单号、赞助者 ID/名称）查询订单
+func (s *Server) ListOrders(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	list, err := s.Svc.ListOrders(afdian.OrderFilter{Status: c.Query("status"), Query: c.Query("q"), Limit: limit})
+	if err != nil {
+		log.Printf("[Admin] list orders error: %v", err)
+		c.JSON(200, gin.H{"code": 500, "error": "查询订单失败"})
+		return
+	}
+	data := make([]gin.H, 0, len(list))
+	for i := range list {
+		data = append(data, orderView(&list[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": data})
+}
+
+func orderView(o *afdian.Order) gin.H {
+	return gin.H{
+		"site":              o.Site,
+		"order_no":          o.OrderNo,
+		"status":            o.Status,
+		"amount":            o.Amount.String(),
+		"currency":          string(o.Amount.Currency),
+		"original_amount":   o.Original.String(),
+		"original_currency": string(o.Original.Currency),
+		"refunded_amount":   o.Refunded.String(),
+		"plan_id":           o.PlanID,
+		"sku_id":            o.SkuID,
+		"out_trade_no":      o.OutTradeNo,
+		"sponsor_user_id":   o.SponsorUserID,
+		"sponsor_name":      o.SponsorName,
+		"afdian_plan_id":    o.PaidPlanID,
+		"created_at":        unixOrZero(o.CreatedAt),
+		"paid_at":           unixOrZero(o.PaidAt),
+		"notified_at":       unixOrZero(o.NotifiedAt),
+		"afdian_paid_at":    unixOrZero(o.AfdianPaidAt),
+	}
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// ExportOrders 按日期范围、状态与站点流式导出订单（CSV 或 NDJSON）
+func (s *Server) ExportOrders(c *gin.Context) {
+	f := afdian.OrderFilter{Site: c.Query("site"), Status: c.Query("status"), ByPaidAt: c.DefaultQuery("by", "paid") == "paid"}
+	var err error
+	if f.Since, err = parseDateParam(c.Query("from")); err != nil {
+		c.JSON(200, gin.H{"code": 400, "error": "无效的 from 日期"})
+		return
+	}
+	if f.Until, err = parseDateParam(c.Query("to")); err != nil {
+		c.JSON(200, gin.H{"code": 400, "error": "无效的 to 日期"})
+		return
+	}
+	format := c.DefaultQuery("format", export.FormatCSV)
+	if format != export.FormatCSV && format != export.FormatNDJSON {
+		c.JSON(200, gin.H{"code": 400, "error": "未知的导出格式"})
+		return
+	}
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="orders.%s"`, format))
+	w, err := export.NewWriter(c.Writer, format)
+	if err != nil {
+		log.Printf("[Admin] export error: %v", err)
+		return
+	}
+	if err := s.Svc.EachOrder(f, w.Write); err != nil {
+		// 响应头已发出，只能记录日志
+		log.Printf("[Admin] export error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("[Admin] export flush error: %v", err)
+	}
+}
+
+func parseDateParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// Journal 按订单号或爱发电订单号查询回调原始记录
+func (s *Server) Journal(c *gin.Context) {
+	key := c.Query("order_no")
+	if key == "" {
+		key = c.Query("out_trade_no")
+	}
+	if key == "" {
+		c.JSON(200, gin.H{"code": 400, "error": "缺少 order_no"})
+		return
+	}
+	list, err := s.Svc.JournalByOrder(key)
+	if err != nil {
+		log.Printf("[Admin] journal error: %v", err)
+		c.JSON(200, gin.H{"code": 500, "error": "查询回调记录失败"})
+		return
+	}
+	data := make([]gin.H, 0, len(list))
+	for _, e := range list {
+		data = append(data, gin.H{
+			"id":           e.ID,
+			"order_no":     e.OrderNo,
+			"out_trade_no": e.OutTradeNo,
+			"headers":      e.Headers,
+			"body":         e.Body,
+			"api_response": e.APIResponse,
+			"verdict":      e.Verdict,
+			"received_at":  e.ReceivedAt.Unix(),
+			"processed_at": e.ProcessedAt.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": data})
+}
+
+// ListReviews 查询复核队列，默认只返回待处理的记录（status=all 返回全部）
+func (s *Server) ListReviews(c *gin.Context) {
+	status := c.DefaultQuery("status", afdian.ReviewOpen)
+	if status == "all" {
+		status = ""
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	list, err := s.Svc.ListReviews(status, limit)
+	if err != nil {
+		log.Printf("[Admin] list reviews error: %v", err)
+		c.JSON(200, gin.H{"code": 500, "error": "查询复核队列失败"})
+		return
+	}
+	data := make([]gin.H, 0, len(list))
+	for i := range list {
+		data = append(data, reviewView(&list[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": data})
+}
+
+func reviewView(it *afdian.ReviewItem) gin.H {
+	return gin.H{
+		"id":           it.ID,
+		"order_no":     it.OrderNo,
+		"out_trade_no": it.OutTradeNo,
+		"amount":       it.Amount.String(),
+		"currency":     string(it.Amount.Currency),
+		"remark":       it.Remark,
+		"reason":       it.Reason,
+		"status":       it.Status,
+		"note":         it.Note,
+		"created_at":   it.CreatedAt.Unix(),
+		"resolved_at":  unixOrZero(it.ResolvedAt),
+	}
+}
+
+// AttachReview 将复核中的付款关联到订单并通知 Cloudreve
+func (s *Server) AttachReview(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(200, gin.H{"code": 400, "error": "无效的复核 ID"})
+		return
+	}
+	var body struct {
+		OrderNo string `json:"order_no"`
+		// Force 金额不一致时仍然关联
+		Force bool   `json:"force"`
+		Note  string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.OrderNo == "" {
+		c.JSON(200, gin.H{"code": 400, "error": "缺少 order_no"})
+		return
+	}
+	o, err := s.Svc.AttachReview(id, body.OrderNo, body.Force, body.Note)
+	if o != nil {
+		// 订单已入账，通知失败由重试任务或 notify replay 补发
+		notified := err == nil
+		if err != nil {
+			log.Printf("[Admin] attach review id=%d notify error: %v", id, err)
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 0, "data": gin.H{"order": orderView(o), "notified": notified}})
+		return
+	}
+	s.reviewError(c, id, err)
+}
+
+// DismissReview 忽略复核记录
+func (s *Server) DismissReview(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(200, gin.H{"code": 400, "error": "无效的复核 ID"})
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	_ = c.ShouldBindJSON(&body)
+	if err := s.Svc.DismissReview(id, body.Note); err != nil {
+		s.reviewError(c, id, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0})
+}
+
+func (s *Server) reviewError(c *gin.Context, id int64, err error) {
+	switch {
+	case errors.Is(err, afdian.ErrReviewNotFound), errors.Is(err, afdian.ErrOrderNotFound):
+		c.JSON(200, gin.H{"code": 404, "error": err.Error()})
+	case errors.Is(err, afdian.ErrReviewResolved), errors.Is(err, afdian.ErrReviewAmountMismatch), errors.Is(err, afdian.ErrReviewNotPaid),
+		errors.Is(err, afdian.ErrOrderAlreadyPaid), errors.Is(err, afdian.ErrAlreadyRefunded):
+		c.JSON(200, gin.H{"code": 409, "error": err.Error()})
+	default:
+		log.Printf("[Admin] review id=%d error: %v", id, err)
+		c.JSON(200, gin.H{"code": 500, "error": "处理复核记录失败"})
+	}
+}
+
+// ListWebhooks 查询事件推送记录及各订阅者的重试状态
+func (s *Server) ListWebhooks(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	list, err := s.Svc.ListWebhookDeliveries(c.Query("subscriber"), c.Query("status"), limit)
+	if err != nil {
+		log.Printf("[Admin] list webhooks error: %v", err)
+		c.JSON(200, gin.H{"code": 500, "error": "查询推送记录失败"})
+		return
+	}
+	data := make([]gin.H, 0, len(list))
+	for _, d := range list {
+		data = append(data, gin.H{
+			"id":              d.ID,
+			"subscriber":      d.Subscriber,
+			"event_id":        d.EventID,
+			"event_type":      d.EventType,
+			"status":          d.Status,
+			"attempts":        d.Attempts,
+			"last_status":     d.LastStatus,
+			"last_error":      d.LastError,
+			"next_attempt_at": unixOrZero(d.NextAttemptAt),
+			"created_at":      unixOrZero(d.CreatedAt),
+			"delivered_at":    unixOrZero(d.DeliveredAt),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": data})
+}
+
+// RetryWebhook 立即重新推送一条未送达的事件
+func (s *Server) RetryWebhook(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(200, gin.H{"code": 400, "error": "无效的推送记录 ID"})
+		return
+	}
+	if err := s.Svc.RetryWebhook(id); err != nil {
+		if errors.Is(err, afdian.ErrDeliveryNotFound) {
+			c.JSON(200, gin.H{"code": 404, "error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"code": 409, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0})
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/afdianmock"
+	"cloudreve-afdianpay/internal/cloudrevemock"
+
+	"github.com/gin-gonic/gin"
+)
+
+// e2e 网关与模拟的 Cloudreve、爱发电组成的完整环境
+type e2e struct {
+	svc *afdian.Service
+	afd *afdianmock.Server
+	cr  *cloudrevemock.Site
+	gw  *httptest.Server
+}
+
+func newE2E(t *testing.T) *e2e {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	const site, key = "https://cloudreve.example.com", "communication-key"
+	t.Setenv("SITE_URL", site)
+	t.Setenv("COMMUNICATION_KEY", key)
+	t.Setenv("USER_ID", "author")
+	t.Setenv("TOKEN", "afdian-token")
+	t.Setenv("PUBLIC_URL", "")
+	t.Setenv("PLAN_MAP_FILE", "")
+
+	e := &e2e{svc: afdian.NewService(filepath.Join(t.TempDir(), "e2e.db"))}
+	t.Cleanup(func() { e.svc.Close() })
+
+	e.afd = afdianmock.New("author", "afdian-token")
+	afdSrv := httptest.NewServer(e.afd.Handler())
+	t.Cleanup(afdSrv.Close)
+	t.Setenv("AFDIAN_BASE_URL", afdSrv.URL)
+
+	r := gin.New()
+	NewServer(e.svc).Register(r)
+	e.gw = httptest.NewServer(r)
+	t.Cleanup(e.gw.Close)
+	e.afd.WebhookURL = e.gw.URL + "/afdian"
+
+	e.cr = cloudrevemock.New(site, key, e.gw.URL)
+	crSrv := httptest.NewServer(e.cr.Handler())
+	t.Cleanup(crSrv.Close)
+	e.cr.NotifyBase = crSrv.URL
+	return e
+}
+
+// create 以 Cloudreve 身份创建 CNY 订单，返回支付页地址
+func (e *e2e) create(t *testing.T, orderNo string, fen int64) string {
+	t.Helper()
+	resp, err := e.cr.CreateOrder(cloudrevemock.OrderRequest{Name: "容量包", OrderNo: orderNo, Amount: fen, Currency: "CNY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != 0 || !strings.HasPrefix(resp.Data, e.gw.URL+"/pay/") {
+		t.Fatalf("create order = %+v", resp)
+	}
+	return resp.Data
+}
+
+func (e *e2e) status(t *testing.T, orderNo string) string {
+	t.Helper()
+	resp, err := e.cr.QueryOrder(orderNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != 0 {
+		t.Fatalf("query order = %+v", resp)
+	}
+	return resp.Data
+}
+
+var checkoutHref = regexp.MustCompile(`<a class="btn" href="([^"]+)"`)
+
+// pay 模拟赞助者：打开支付页，跟随链接到爱发电下单页并支付；edit 可修改留言与金额
+func (e *e2e) pay(t *testing.T, payURL string, edit func(form url.Values)) {
+	t.Helper()
+	resp, err := http.Get(payURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	m := checkoutHref.FindStringSubmatch(string(page))
+	if m == nil {
+		t.Fatalf("pay page has no checkout link: %s", page)
+	}
+	checkout, err := url.Parse(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := checkout.Query()
+	form := url.Values{"total_amount": {q.Get("custom_price")}, "remark": {q.Get("remark")}}
+	if edit != nil {
+		edit(form)
+	}
+	resp, err = http.PostForm(checkout.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("checkout status = %d", resp.StatusCode)
+	}
+}
+
+func TestE2EPayFlow(t *testing.T) {
+	e := newE2E(t)
+	payURL := e.create(t, "e2e-1", 1200)
+	if got := e.status(t, "e2e-1"); got != "UNPAID" {
+		t.Fatalf("status before pay = %s", got)
+	}
+	e.pay(t, payURL, nil)
+
+	d := e.afd.Deliveries()
+	if len(d) != 1 || d[0].Error != "" {
+		t.Fatalf("webhook deliveries = %+v", d)
+	}
+	if n := e.cr.Notified("e2e-1"); n != 1 {
+		t.Fatalf("notified %d times", n)
+	}
+	if got := e.status(t, "e2e-1"); got != "PAID" {
+		t.Fatalf("status after pay = %s", got)
+	}
+	o, err := e.svc.GetOrder("e2e-1")
+	if err != nil || o == nil || o.OutTradeNo != d[0].OutTradeNo || o.SponsorName == "" {
+		t.Fatalf("order = %+v, %v", o, err)
+	}
+
+	// 爱发电重复回调不会重复通知
+	if err := e.afd.SendWebhook(d[0].OutTradeNo); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.cr.Notified("e2e-1"); n != 1 {
+		t.Fatalf("notified %d times after duplicate webhook", n)
+	}
+	// 订单号重复创建返回同一支付页
+	if again := e.create(t, "e2e-1", 1200); again != payURL {
+		t.Fatalf("recreate = %s, want %s", again, payURL)
+	}
+}
+
+func TestE2EMangledRemark(t *testing.T) {
+	e := newE2E(t)
+	payURL := e.create(t, "e2e-mangled", 1500)
+	e.pay(t, payURL, func(form url.Values) { form.Set("remark", "谢谢作者") })
+	if got := e.status(t, "e2e-mangled"); got != "PAID" {
+		t.Fatalf("status = %s", got)
+	}
+	if n := e.cr.Notified("e2e-mangled"); n != 1 {
+		t.Fatalf("notified %d times", n)
+	}
+}
+
+func TestE2EAmountMismatch(t *testing.T) {
+	e := newE2E(t)
+	payURL := e.create(t, "e2e-short", 2000)
+	e.pay(t, payURL, func(form url.Values) { form.Set("total_amount", "19.00") })
+	if got := e.status(t, "e2e-short"); got != "UNPAID" {
+		t.Fatalf("status = %s", got)
+	}
+	if calls := e.cr.Calls(); len(calls) != 0 {
+		t.Fatalf("notify calls = %+v", calls)
+	}
+	list, err := e.svc.ListReviews(afdian.ReviewOpen, 0)
+	if err != nil || len(list) != 1 || list[0].Reason != afdian.ReviewAmountMismatch || list[0].OrderNo != "e2e-short" {
+		t.Fatalf("reviews = %+v, %v", list, err)
+	}
+}
+
+// 爱发电订单未交易成功（如已退款）时回调不入账，转入复核且不能关联
+func TestE2ENotPaid(t *testing.T) {
+	e := newE2E(t)
+	payURL := e.create(t, "e2e-unpaid", 1000)
+	e.pay(t, payURL, func(form url.Values) { form.Set("no_webhook", "1") })
+	orders := e.afd.Orders()
+	if len(orders) != 1 {
+		t.Fatalf("afdian orders = %+v", orders)
+	}
+	if err := e.afd.SetStatus(orders[0].OutTradeNo, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.afd.SendWebhook(orders[0].OutTradeNo); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.status(t, "e2e-unpaid"); got != "UNPAID" {
+		t.Fatalf("status = %s", got)
+	}
+	if calls := e.cr.Calls(); len(calls) != 0 {
+		t.Fatalf("notify calls = %+v", calls)
+	}
+	list, err := e.svc.ListReviews(afdian.ReviewOpen, 0)
+	if err != nil || len(list) != 1 || list[0].Reason != afdian.ReviewNotPaid || list[0].OrderNo != "e2e-unpaid" {
+		t.Fatalf("reviews = %+v, %v", list, err)
+	}
+	if _, err := e.svc.AttachReview(list[0].ID, "e2e-unpaid", true, ""); !errors.Is(err, afdian.ErrReviewNotPaid) {
+		t.Fatalf("attach not paid review: err = %v", err)
+	}
+}
+
+func TestE2ENotifyRetry(t *testing.T) {
+	e := newE2E(t)
+	payURL := e.create(t, "e2e-retry", 800)
+	e.cr.FailNotify(1)
+	e.pay(t, payURL, nil)
+	if calls := e.cr.Calls(); len(calls) != 2 || !calls[0].Failed || calls[1].Failed {
+		t.Fatalf("notify calls = %+v", calls)
+	}
+	if got := e.status(t, "e2e-retry"); got != "PAID" {
+		t.Fatalf("status = %s", got)
+	}
+}
+
+func TestE2ERejectsBadRequests(t *testing.T) {
+	e := newE2E(t)
+	tamper := func(r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		body = []byte(strings.Replace(string(body), `"amount":1000`, `"amount":1`, 1))
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		r.ContentLength = int64(len(body))
+	}
+	cases := []struct {
+		name string
+		site *cloudrevemock.Site
+		edit func(r *http.Request)
+	}{
+		{"tampered body", e.cr, tamper},
+		{"wrong key", cloudrevemock.New(e.cr.URL, "other-key", e.gw.URL), nil},
+		{"wrong site", cloudrevemock.New("https://other.example.com", e.cr.Key, e.gw.URL), nil},
+		{"missing authorization", e.cr, func(r *http.Request) { r.Header.Del("Authorization") }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := c.site.NewCreateRequest(cloudrevemock.OrderRequest{OrderNo: "e2e-bad", Amount: 1000, Currency: "CNY", NotifyURL: "http://cr/notify"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.edit != nil {
+				c.edit(r)
+			}
+			resp, err := e.cr.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != 412 {
+				t.Fatalf("response = %+v, want code 412", resp)
+			}
+		})
+	}
+	if o, err := e.svc.GetOrder("e2e-bad"); err != nil || o != nil {
+		t.Fatalf("rejected order stored: %+v, %v", o, err)
+	}
+
+	// GET 签名只对路径签名，其他站点的签名同样无效
+	r, err := cloudrevemock.New(e.cr.URL, "other-key", e.gw.URL).NewQueryRequest("e2e-bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := e.cr.Do(r); err != nil || resp.Code != 412 {
+		t.Fatalf("query with wrong key = %+v, %v", resp, err)
+	}
+}
+
+// readEvents 读取 SSE 流中的 status 事件，直到服务端关闭连接
+func readEvents(t *testing.T, body io.Reader, got chan<- afdian.OrderUpdate) {
+	defer close(got)
+	sc := bufio.NewScanner(body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var u afdian.OrderUpdate
+		if err := json.Unmarshal([]byte(data), &u); err != nil {
+			t.Errorf("event %q: %v", data, err)
+			return
+		}
+		got <- u
+	}
+}
+
+func TestE2EOrderEvents(t *testing.T) {
+	e := newE2E(t)
+	payURL := e.create(t, "e2e-sse", 1000)
+
+	req, _ := http.NewRequest(http.MethodGet, e.gw.URL+"/order/e2e-sse/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	events := make(chan afdian.OrderUpdate, 8)
+	go readEvents(t, resp.Body, events)
+	next := func() (afdian.OrderUpdate, bool) {
+		select {
+		case u, ok := <-events:
+			return u, ok
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event within 5s")
+		}
+		return afdian.OrderUpdate{}, false
+	}
+	if u, _ := next(); u.OrderNo != "e2e-sse" || u.Status != afdian.StatusPending || u.Notified {
+		t.Fatalf("initial event = %+v", u)
+	}
+
+	e.pay(t, payURL, nil)
+	// 支付与通知可能合并为一次推送，最后一次为已支付且已通知，随后服务端结束推送
+	var last afdian.OrderUpdate
+	for u, ok := next(); ok; u, ok = next() {
+		last = u
+	}
+	if last.Status != afdian.StatusPaid || !last.Notified {
+		t.Fatalf("last event = %+v", last)
+	}
+}
+
+func TestE2EOrderLongPoll(t *testing.T) {
+	e := newE2E(t)
+	payURL := e.create(t, "e2e-poll", 1000)
+	poll := func(query string) (int, afdian.OrderUpdate) {
+		resp, err := http.Get(e.gw.URL + "/order/e2e-poll/events?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Code int
+			Data afdian.OrderUpdate
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Code, out.Data
+	}
+
+	// 状态与 since 不同时立即返回
+	if code, u := poll("since=paid"); code != 0 || u.Status != afdian.StatusPending {
+		t.Fatalf("poll since=paid = %d %+v", code, u)
+	}
+	// 超时返回当前状态
+	if _, u := poll("since=pending&timeout=0"); u.Status != afdian.StatusPending {
+		t.Fatalf("poll timeout = %+v", u)
+	}
+
+	done := make(chan afdian.OrderUpdate, 1)
+	go func() {
+		_, u := poll("since=pending&timeout=10")
+		done <- u
+	}()
+	// 尽量在长轮询订阅后再支付；先支付时长轮询立即返回 paid，结果相同
+	time.Sleep(100 * time.Millisecond)
+	e.pay(t, payURL, nil)
+	select {
+	case u := <-done:
+		if u.Status != afdian.StatusPaid {
+			t.Fatalf("long poll = %+v", u)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll did not return after payment")
+	}
+
+	resp, err := http.Get(e.gw.URL + "/order/missing/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out orderResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || out.Code != 404 {
+		t.Fatalf("missing order = %+v, %v", out, err)
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxLongPoll 长轮询最长等待时间
+	maxLongPoll = 60 * time.Second
+	// sseRetry 断线后浏览器重连前等待的毫秒数
+	sseRetry = 3000
+)
+
+// watcherLimit 每个客户端 IP 同时打开的订单事件连接（SSE 与长轮询）数上限，每个连接占用一个订阅
+type watcherLimit struct {
+	mu  sync.Mutex
+	max int
+	n   map[string]int
+}
+
+// loadWatcherLimit 读取 ORDER_EVENTS_MAX_PER_IP，0 表示不限制
+func loadWatcherLimit() *watcherLimit {
+	max := 10
+	if v := os.Getenv("ORDER_EVENTS_MAX_PER_IP"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			max = n
+		} else {
+			log.Printf("[OrderEvents] invalid ORDER_EVENTS_MAX_PER_IP=%q, using default %d", v, max)
+		}
+	}
+	return &watcherLimit{max: max, n: make(map[string]int)}
+}
+
+// acquire 占用 ip 的一个连接名额，已达上限时返回 false
+func (w *watcherLimit) acquire(ip string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.max > 0 && w.n[ip] >= w.max {
+		return false
+	}
+	w.n[ip]++
+	return true
+}
+
+func (w *watcherLimit) release(ip string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n[ip]--; w.n[ip] <= 0 {
+		delete(w.n, ip)
+	}
+}
+
+// orderFinal 订单不会再变化：已过期、已退款，或已支付且已通知 Cloudreve
+func orderFinal(u afdian.OrderUpdate) bool {
+	switch u.Status {
+	case afdian.StatusPending:
+		return false
+	case afdian.StatusPaid:
+		return u.Notified
+	}
+	return true
+}
+
+// OrderEvents 推送订单状态变化。请求头 Accept 含 text/event-stream 时（EventSource）以 SSE 持续推送，
+// 订单进入最终状态后结束；否则为长轮询：状态与 since 不同时立即返回，否则最多等待 timeout 秒后返回当前状态。
+// 订阅在进程内，另每 ORDER_EVENTS_POLL 重新查询一次，多实例部署时也能收到其他实例处理的回调
+func (s *Server) OrderEvents(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	o, err := s.Svc.GetOrder(orderNo)
+	if err != nil {
+		log.Printf("[OrderEvents] get order %s error: %v", orderNo, err)
+		c.JSON(200, gin.H{"code": 500, "error": "订单查询失败"})
+		return
+	}
+	if o == nil {
+		c.JSON(200, gin.H{"code": 404, "error": "订单不存在"})
+		return
+	}
+	ip := c.ClientIP()
+	if !s.watchers.acquire(ip) {
+		rejectEvents(c, sseRetry*time.Millisecond)
+		return
+	}
+	defer s.watchers.release(ip)
+	ch, cancel := s.Svc.WatchOrder(orderNo)
+	defer cancel()
+	// 订阅后重新读取，避免错过读取与订阅之间的变化
+	if fresh, err := s.Svc.GetOrder(orderNo); err == nil && fresh != nil {
+		o = fresh
+	}
+	cur := afdian.NewOrderUpdate(o)
+	interval := config.Duration("ORDER_EVENTS_POLL", 5*time.Second)
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	poll := time.NewTicker(interval)
+	defer poll.Stop()
+	// reload 定期查询，状态变化时返回新状态
+	reload := func() (afdian.OrderUpdate, bool) {
+		o, err := s.Svc.GetOrder(orderNo)
+		if err != nil || o == nil {
+			return cur, false
+		}
+		u := afdian.NewOrderUpdate(o)
+		return u, u.Status != cur.Status || u.Notified != cur.Notified
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		s.streamOrderEvents(c, cur, ch, poll.C, reload)
+		return
+	}
+
+	since := c.Query("since")
+	timeout := 30 * time.Second
+	if v, err := strconv.Atoi(c.Query("timeout")); err == nil && v >= 0 {
+		timeout = time.Duration(v) * time.Second
+	}
+	if timeout > maxLongPoll {
+		timeout = maxLongPoll
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for since != "" && cur.Status == since {
+		select {
+		case u := <-ch:
+			cur = u
+		case <-poll.C:
+			cur, _ = reload()
+		case <-deadline.C:
+			c.JSON(http.StatusOK, gin.H{"code": 0, "data": cur})
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": cur})
+}
+
+func (s *Server) streamOrderEvents(c *gin.Context, cur afdian.OrderUpdate, ch <-chan afdian.OrderUpdate, poll <-chan time.Time, reload func() (afdian.OrderUpdate, bool)) {
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Connection", "keep-alive")
+	// 关闭 nginx 等反向代理的缓冲
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(u afdian.OrderUpdate) bool {
+		b, _ := json.Marshal(u)
+		_, err := fmt.Fprintf(c.Writer, "event: status\ndata: %s\n\n", b)
+		c.Writer.Flush()
+		return err == nil
+	}
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetry)
+	if !send(cur) || orderFinal(cur) {
+		return
+	}
+	age := config.Duration("ORDER_EVENTS_MAX_AGE", 10*time.Minute)
+	if age <= 0 {
+		age = 10 * time.Minute
+	}
+	maxAge := time.NewTimer(age)
+	defer maxAge.Stop()
+	for {
+		select {
+		case u := <-ch:
+			if u.Status == cur.Status && u.Notified == cur.Notified {
+				continue
+			}
+			cur = u
+		case <-poll:
+			u, changed := reload()
+			if !changed {
+				// 心跳，保持连接并尽快发现客户端已断开
+				if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+				continue
+			}
+			cur = u
+		case <-maxAge.C:
+			// 浏览器会按 retry 自动重连
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		if !send(cur) || orderFinal(cur) {
+			return
+		}
+	}
+}
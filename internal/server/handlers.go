@@ -3,15 +3,20 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/alert"
+	"cloudreve-afdianpay/internal/money"
 	"cloudreve-afdianpay/internal/signature"
 
 	"github.com/gin-gonic/gin"
@@ -19,12 +24,15 @@ import (
 
 type Server struct {
 	Svc *afdian.Service
-}
 
-func NewServer(svc *afdian.Service) *Server { return &Server{Svc: svc} }
+	payTmpl  *template.Template
+	rate     *rateLimits
+	proxies  []*net.IPNet
+	watchers *watcherLimit
+}
 
-var currencyUnit = map[string]int64{
-	"USD": 100, "EUR": 100, "GBP": 100, "JPY": 1, "CNY": 100, "HKD": 100, "SGD": 100, "KRW": 1, "INR": 100, "RUB": 100, "BRL": 100, "AUD": 100, "CAD": 100, "CHF": 100,
+func NewServer(svc *afdian.Service) *Server {
+	return &Server{Svc: svc, payTmpl: loadPayTemplate(), rate: loadRateLimits(), proxies: loadTrustedProxies(), watchers: loadWatcherLimit()}
 }
 
 func (s *Server) Order(c *gin.Context) {
@@ -97,9 +105,35 @@ func (s *Server) Order(c *gin.Context) {
 	s.checkOrder(c)
 }
 
+// limitCodes 各类金额限制的错误码，Cloudreve 按错误信息提示用户；417 为不支持的货币，不与之共用
+var limitCodes = map[string]int{
+	afdian.LimitBelowMin:         419,
+	afdian.LimitAboveMax:         418,
+	afdian.LimitOriginalBelowMin: 421,
+	afdian.LimitOriginalAboveMax: 422,
+	afdian.LimitDailyCap:         423,
+}
+
+// limitError 金额超出限制时返回对应错误码，其他错误返回 500
+func limitError(c *gin.Context, err error) {
+	var le *afdian.LimitError
+	if errors.As(err, &le) {
+		c.JSON(200, gin.H{"code": limitCodes[le.Kind], "error": le.Error()})
+		return
+	}
+	log.Printf("[createOrder] check amount error: %v", err)
+	c.JSON(200, gin.H{"code": 500, "error": "创建订单失败"})
+}
+
 func (s *Server) createOrder(c *gin.Context) {
+	// Order 已校验签名且 X-Cr-Site-Url 与 SITE_URL 一致，限流与金额限制均按该站点计算
+	site := c.GetHeader("X-Cr-Site-Url")
+	if !allow(c, s.rate.orderSite, site, rejectOrder) {
+		return
+	}
 	var body struct {
 		OrderNo   string `json:"order_no"`
+		Name      string `json:"name"`
 		Amount    int64  `json:"amount"`
 		Currency  string `json:"currency"`
 		NotifyURL string `json:"notify_url"`
@@ -114,40 +148,62 @@ func (s *Server) createOrder(c *gin.Context) {
 		return
 	}
 
-	amountFen := body.Amount
-	currency := body.Currency
-	if strings.ToUpper(currency) != "CNY" {
-		unit, ok := currencyUnit[strings.ToUpper(currency)]
-		if !ok {
-			c.JSON(200, gin.H{"code": 417, "error": "不支持的货币"})
-			return
-		}
-		cnFen, err := convertToCNY(amountFen, unit, strings.ToUpper(currency))
+	currency, err := money.ParseCurrency(body.Currency)
+	if err != nil {
+		c.JSON(200, gin.H{"code": 417, "error": "不支持的货币"})
+		return
+	}
+	original := money.New(body.Amount, currency)
+	// 先检查原始金额，超出范围时不必请求汇率接口
+	if err := s.Svc.CheckOriginal(site, original); err != nil {
+		limitError(c, err)
+		return
+	}
+	amount := original
+	if currency != money.CNY {
+		cny, err := convertToCNY(amount)
 		if err != nil {
+			log.Printf("[createOrder] convert %s error: %v", amount, err)
+			s.Svc.Alerts.Record(alert.KindExchangeRate, fmt.Sprintf("%s 转换 CNY 失败: %v", amount, err))
 			c.JSON(200, gin.H{"code": 502, "error": "汇率转换失败"})
 			return
 		}
-		amountFen = cnFen
+		amount = cny
 	}
 
-	if amountFen < 500 {
-		c.JSON(200, gin.H{"code": 417, "error": "CNY金额需要大于等于5元"})
+	if err := s.Svc.CheckAmount(site, body.OrderNo, amount); err != nil {
+		limitError(c, err)
 		return
 	}
 
 	orderInfo := map[string]interface{}{
-		"order_no":   body.OrderNo,
-		"amount":     amountFen,
-		"currency":   "CNY",
-		"notify_url": body.NotifyURL,
+		"order_no": body.OrderNo,
+		"name":     body.Name,
+		"amount":   amount.Minor,
+		"currency": string(amount.Currency),
+		// 保留原始币种金额，供对账导出
+		"original_amount":   original.Minor,
+		"original_currency": string(original.Currency),
+		"notify_url":        body.NotifyURL,
 	}
 	orderInfoJSON, _ := json.Marshal(orderInfo)
-	orderURL, err := s.Svc.NewOrder(string(orderInfoJSON), amountFen)
-	if err != nil {
-		c.JSON(200, gin.H{"code": 500, "error": "创建订单失败"})
+	if _, err := s.Svc.NewOrder(string(orderInfoJSON), amount); err != nil {
+		var le *afdian.LimitError
+		switch {
+		case errors.As(err, &le):
+			// 并发下单时写入前重新检查当日总额
+			limitError(c, err)
+		case errors.Is(err, afdian.ErrOrderConflict):
+			c.JSON(200, gin.H{"code": 409, "error": "订单号已存在且金额不一致"})
+		case errors.Is(err, afdian.ErrOrderExpired):
+			c.JSON(200, gin.H{"code": 410, "error": "订单已过期"})
+		default:
+			c.JSON(200, gin.H{"code": 500, "error": "创建订单失败"})
+		}
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "data": orderURL})
+	// 返回网关支付页而非爱发电链接，链接规则变更不受 Cloudreve 缓存影响
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": s.payURL(c, body.OrderNo)})
 }
 
 func (s *Server) checkOrder(c *gin.Context) {
@@ -171,76 +227,146 @@ func (s *Server) checkOrder(c *gin.Context) {
 	}
 }
 
+// maxCallbackBody 爱发电回调请求体上限
+const maxCallbackBody = 1 << 20
+
 func (s *Server) AfdianCallback(c *gin.Context) {
+	// 原始请求体与处理结论写入回调日志，便于支付纠纷时还原
+	entry := &afdian.JournalEntry{ReceivedAt: time.Now(), Verdict: afdian.VerdictError}
+	headers, _ := json.Marshal(c.Request.Header)
+	entry.Headers = string(headers)
+	defer func() {
+		entry.ProcessedAt = time.Now()
+		if err := s.Svc.AppendJournal(entry); err != nil {
+			log.Printf("[AfdianCallback] journal error: %v", err)
+		}
+		if entry.Verdict == afdian.VerdictError {
+			s.Svc.Alerts.Record(alert.KindCallbackFailed, fmt.Sprintf("out_trade_no=%s order_no=%s", entry.OutTradeNo, entry.OrderNo))
+		}
+		c.Data(http.StatusOK, "application/json", []byte(`{"ec":200,"em":""}`))
+	}()
+
+	raw, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCallbackBody))
+	entry.Body = string(raw)
 	// 解析返回的 json 值
 	var payload struct {
 		Data struct {
 			Order map[string]interface{} `json:"order"`
 		} `json:"data"`
 	}
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	if err == nil {
+		err = json.Unmarshal(raw, &payload)
+	}
+	if err != nil {
 		// 与 Python 行为一致，若解析失败则仍返回成功（避免回调方重试风暴）
 		log.Printf("[AfdianCallback] bind JSON error: %v", err)
-		c.Data(http.StatusOK, "application/json", []byte(`{"ec":200,"em":""}`))
+		entry.Verdict = afdian.VerdictBadPayload
 		return
 	}
 
 	order := payload.Data.Order
 	outTradeNo, _ := asString(order["out_trade_no"])
 	orderNo, _ := asString(order["remark"])
-	afdAmountStr := fmt.Sprintf("%v", order["total_amount"])
-	log.Printf("[AfdianCallback] out_trade_no=%s order_no=%s total_amount=%s", outTradeNo, orderNo, afdAmountStr)
+	if n, ok := afdian.ParseRemark(orderNo); ok {
+		orderNo = n
+	}
+	entry.OrderNo, entry.OutTradeNo = orderNo, outTradeNo
+	afdAmount, amountErr := money.ParseAny(order["total_amount"], money.CNY)
+	log.Printf("[AfdianCallback] out_trade_no=%s order_no=%s total_amount=%v", outTradeNo, orderNo, order["total_amount"])
+	if amountErr != nil {
+		log.Printf("[AfdianCallback] invalid total_amount: %v", amountErr)
+	}
 
-	// 查询订单
-	_, amountStr, notifyURL, ok, err := s.Svc.CheckOrder(orderNo, outTradeNo)
+	// 查询订单，以爱发电 API 返回的留言为准定位本地订单
+	res, err := s.Svc.CheckOrder(outTradeNo)
+	entry.APIResponse = string(res.APIResponse)
 	if err != nil {
 		log.Printf("[AfdianCallback] CheckOrder error: %v", err)
+		return
 	}
-	if ok && amountStr != "" && afdAmountStr == amountStr {
-		_ = s.Svc.MarkOrderPaid(orderNo)
-		// 通知网站
-		url := notifyURL
-		for attempt := 0; attempt < 3; attempt++ {
-			resp, err := http.Get(url)
-			if err == nil && resp.StatusCode == 200 {
-				var r struct {
-					Code int `json:"code"`
-				}
-				_ = json.NewDecoder(resp.Body).Decode(&r)
-				resp.Body.Close()
-				if r.Code == 0 {
-					log.Printf("[AfdianCallback] notify ok")
-					break
-				}
-			}
-			log.Printf("[AfdianCallback] notify retry #%d", attempt+1)
-			time.Sleep(time.Duration(1<<attempt) * time.Second)
+	local := res.Order
+	if local != nil {
+		orderNo = local.OrderNo
+		entry.OrderNo = orderNo
+	}
+	reason := res.ReviewReason
+	if res.Matched && (amountErr != nil || !local.Amount.Equal(afdAmount)) {
+		reason = afdian.ReviewAmountMismatch
+	}
+	if reason != "" {
+		// 无法自动入账（含爱发电侧未交易成功），转人工复核，避免付款无人处理
+		log.Printf("[AfdianCallback] order not matched out_trade_no=%s order_no=%q remark=%q reason=%s", outTradeNo, orderNo, res.Payment.Remark, reason)
+		reviewOrderNo := ""
+		if local != nil {
+			reviewOrderNo = local.OrderNo
 		}
-	} else {
-		log.Printf("[AfdianCallback] order not matched ok=%v dbAmount=%q", ok, amountStr)
+		_ = s.Svc.AddReview(afdian.ReviewItem{OrderNo: reviewOrderNo, OutTradeNo: outTradeNo, Amount: res.Payment.Amount, Remark: res.Payment.Remark, Reason: reason})
+		entry.Verdict = afdian.VerdictNotMatched
+		return
+	}
+	if !res.Matched {
+		log.Printf("[AfdianCallback] order not matched: payment %s not found", outTradeNo)
+		entry.Verdict = afdian.VerdictNotMatched
+		return
 	}
 
-	c.Data(http.StatusOK, "application/json", []byte(`{"ec":200,"em":""}`))
+	if err := s.Svc.MarkOrderPaid(orderNo, res.Payment); err != nil {
+		switch {
+		case errors.Is(err, afdian.ErrOrderExpired):
+			// 订单已过期，不再自动入账，转人工复核
+			log.Printf("[AfdianCallback] late payment for expired order: %s", orderNo)
+			_ = s.Svc.AddReview(afdian.ReviewItem{OrderNo: orderNo, OutTradeNo: outTradeNo, Amount: afdAmount, Reason: afdian.ReviewOrderExpired})
+			entry.Verdict = afdian.VerdictOrderExpired
+		case errors.Is(err, afdian.ErrOrderNotFound):
+			// 查询后订单被清理等情况，付款无人处理，转人工复核
+			log.Printf("[AfdianCallback] order not found when marking paid: %s", orderNo)
+			_ = s.Svc.AddReview(afdian.ReviewItem{OutTradeNo: outTradeNo, Amount: afdAmount, Remark: res.Payment.Remark, Reason: afdian.ReviewRemarkUnmatched})
+			entry.Verdict = afdian.VerdictNotMatched
+		case errors.Is(err, afdian.ErrAlreadyRefunded):
+			log.Printf("[AfdianCallback] callback for refunded order: %s", orderNo)
+			entry.Verdict = afdian.VerdictAlreadyRefunded
+		default:
+			log.Printf("[AfdianCallback] mark paid error: %v", err)
+		}
+		return
+	}
+	entry.Verdict = afdian.VerdictPaid
+	// 通知网站
+	if err := s.Svc.NotifyOrder(local); errors.Is(err, afdian.ErrNotifyClaimed) {
+		log.Printf("[AfdianCallback] notify skipped: %s already notified or in progress", orderNo)
+	} else if err != nil {
+		log.Printf("[AfdianCallback] notify failed: %v", err)
+	}
 }
 
-func convertToCNY(amountFen int64, unit int64, from string) (int64, error) {
-	// 将最小单位转换为该货币的基础单位数量
-	baseAmount := float64(amountFen) / float64(unit)
-	// 拉取汇率
-	url := fmt.Sprintf("https://api.exchangerate.host/convert?from=%s&to=CNY&amount=%f", from, baseAmount)
+func convertToCNY(amount money.Amount) (money.Amount, error) {
+	// 拉取汇率，接口使用基础单位数量，按货币小数位数传入精确值
+	url := fmt.Sprintf("https://api.exchangerate.host/convert?from=%s&to=CNY&amount=%s", amount.Currency, amount)
 	resp, err := http.Get(url)
 	if err != nil {
-		return 0, err
+		return money.Amount{}, err
 	}
 	defer resp.Body.Close()
 	var payload struct {
-		Result float64 `json:"result"`
+		Info struct {
+			Rate json.Number `json:"rate"`
+		} `json:"info"`
+		Result json.Number `json:"result"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return 0, err
+		return money.Amount{}, err
+	}
+	// 优先按汇率由最小单位精确换算到分，接口未返回汇率时对换算结果按分四舍五入
+	var cny money.Amount
+	if payload.Info.Rate != "" {
+		cny, err = money.Convert(amount, payload.Info.Rate.String(), money.CNY)
+	} else {
+		cny, err = money.Round(payload.Result.String(), money.CNY)
+	}
+	if err != nil || cny.Minor <= 0 {
+		return money.Amount{}, fmt.Errorf("汇率接口返回无效结果 status=%d rate=%q result=%q", resp.StatusCode, payload.Info.Rate, payload.Result)
 	}
-	// 转换后为 CNY 元，转为分
-	return int64(payload.Result*100 + 0.5), nil
+	return cny, nil
 }
 
 func urlDecode(s string) (string, error) {
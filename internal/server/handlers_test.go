@@ -0,0 +1,410 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/money"
+	"cloudreve-afdianpay/internal/signature"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSite, testKey = "https://cloudreve.example.com", "communication-key"
+
+func newTestRouter(t *testing.T) (*gin.Engine, *afdian.Service) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("SITE_URL", testSite)
+	t.Setenv("COMMUNICATION_KEY", testKey)
+	t.Setenv("USER_ID", "author")
+	t.Setenv("PUBLIC_URL", "https://gw.example.com")
+	svc := afdian.NewService(filepath.Join(t.TempDir(), "handlers.db"))
+	t.Cleanup(func() { svc.Close() })
+	r := gin.New()
+	NewServer(svc).Register(r)
+	return r, svc
+}
+
+type orderResp struct {
+	Code  int    `json:"code"`
+	Data  string `json:"data"`
+	Error string `json:"error"`
+}
+
+func serve(t *testing.T, r http.Handler, req *http.Request) orderResp {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var out orderResp
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("response %q: %v", w.Body.String(), err)
+	}
+	return out
+}
+
+func TestOrderGET(t *testing.T) {
+	r, svc := newTestRouter(t)
+	if _, err := svc.NewOrder(`{"order_no":"g-1","notify_url":"http://cr/notify"}`, money.New(500, money.CNY)); err != nil {
+		t.Fatal(err)
+	}
+	req := func(query string, site string) *http.Request {
+		rq := httptest.NewRequest(http.MethodGet, "/order?"+query, nil)
+		rq.Header.Set("X-Cr-Site-Url", site)
+		return rq
+	}
+	tok := signature.Token(httptest.NewRequest(http.MethodGet, "/order", nil), testKey, time.Now().Add(time.Minute))
+	cases := []struct {
+		name  string
+		query string
+		site  string
+		code  int
+		data  string
+		err   string
+	}{
+		{"encoded sign", "order_no=g-1&sign=" + url.QueryEscape(tok), testSite, 0, "UNPAID", ""},
+		// Cloudreve 有时对 sign 二次编码，网关再解码一次
+		{"double encoded sign", "order_no=g-1&sign=" + url.QueryEscape(url.QueryEscape(tok)), testSite, 0, "UNPAID", ""},
+		{"raw colon", "order_no=g-1&sign=" + strings.Replace(url.QueryEscape(tok), "%3A", ":", 1), testSite, 0, "UNPAID", ""},
+		{"unknown order", "order_no=missing&sign=" + url.QueryEscape(tok), testSite, 0, "UNPAID", ""},
+		{"missing order_no", "sign=" + url.QueryEscape(tok), testSite, 400, "", "缺少 order_no"},
+		{"missing sign", "order_no=g-1", testSite, 412, "", "未获取到签名信息"},
+		{"no timestamp", "order_no=g-1&sign=abc", testSite, 412, "", "URL中无效的签名格式"},
+		{"bad sign", "order_no=g-1&sign=abc:4102444800", testSite, 412, "", "签名无效"},
+		{"site mismatch", "order_no=g-1&sign=" + url.QueryEscape(tok), "https://other.example.com", 412, "", "验证失败，请检查.env文件"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := serve(t, r, req(c.query, c.site))
+			if got.Code != c.code || got.Data != c.data || got.Error != c.err {
+				t.Fatalf("GET /order?%s = %+v; want code=%d data=%q error=%q", c.query, got, c.code, c.data, c.err)
+			}
+		})
+	}
+}
+
+// newOrderPOST 以 Cloudreve 身份签名的下单请求
+func newOrderPOST(body string) *http.Request {
+	rq := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+	rq.Header.Set("X-Cr-Site-Url", testSite)
+	sr, _ := http.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+	sr.Header.Set("X-Cr-Site-Url", testSite)
+	signature.Sign(sr, testKey, time.Now().Add(time.Minute))
+	rq.Header.Set("Authorization", sr.Header.Get("Authorization"))
+	return rq
+}
+
+func TestOrderPOST(t *testing.T) {
+	r, svc := newTestRouter(t)
+	cases := []struct {
+		name string
+		body string
+		auth string
+		code int
+		err  string
+	}{
+		{"ok", `{"order_no":"p-1","name":"容量包","amount":500,"currency":"CNY","notify_url":"http://cr/notify"}`, "sign", 0, ""},
+		{"idempotent", `{"order_no":"p-1","name":"容量包","amount":500,"currency":"CNY","notify_url":"http://cr/notify"}`, "sign", 0, ""},
+		{"amount conflict", `{"order_no":"p-1","name":"容量包","amount":600,"currency":"CNY","notify_url":"http://cr/notify"}`, "sign", 409, "订单号已存在且金额不一致"},
+		{"below minimum", `{"order_no":"p-2","amount":499,"currency":"CNY"}`, "sign", 419, "CNY金额需要大于等于5元"},
+		{"unknown currency", `{"order_no":"p-3","amount":500,"currency":"XXX"}`, "sign", 417, "不支持的货币"},
+		{"bad json", `{"order_no":`, "sign", 400, "请求体格式错误"},
+		{"no bearer", `{}`, "Basic abc", 412, "无效的Authorization头格式"},
+		{"no timestamp", `{}`, "Bearer Cr abc", 412, "无效的签名格式"},
+		{"bad sign", `{}`, "Bearer Cr abc:4102444800", 412, "签名无效"},
+		{"expired", `{}`, "Bearer Cr abc:1700000000", 412, "时间戳验证失败"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rq := newOrderPOST(c.body)
+			if c.auth != "sign" {
+				rq.Header.Set("Authorization", c.auth)
+			}
+			got := serve(t, r, rq)
+			if got.Code != c.code || got.Error != c.err {
+				t.Fatalf("POST /order %s = %+v; want code=%d error=%q", c.body, got, c.code, c.err)
+			}
+			if c.code == 0 && got.Data != "https://gw.example.com/pay/p-1" {
+				t.Fatalf("pay url = %q", got.Data)
+			}
+		})
+	}
+	if o, err := svc.GetOrder("p-1"); err != nil || o == nil || o.Amount.Minor != 500 {
+		t.Fatalf("order p-1 = %+v, %v", o, err)
+	}
+}
+
+func TestOrderLimits(t *testing.T) {
+	r, svc := newTestRouter(t)
+	path := filepath.Join(t.TempDir(), "limits.json")
+	limits := `{"*":{"amounts":{"USD":{"min":"1.00","max":"100.00"}},"daily_cap":"30"},"` + testSite + `":{"amounts":{"CNY":{"max":"20"}}}}`
+	if err := os.WriteFile(path, []byte(limits), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := afdian.LoadLimits(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Limits = l
+	// USD 超出范围时在请求汇率接口之前拒绝
+	cases := []struct {
+		name string
+		body string
+		code int
+		err  string
+	}{
+		{"original below min", `{"order_no":"l-1","amount":99,"currency":"USD"}`, 421, "USD金额需要大于等于1.00"},
+		{"original above max", `{"order_no":"l-2","amount":10001,"currency":"USD"}`, 422, "USD金额需要小于等于100.00"},
+		{"below min", `{"order_no":"l-3","amount":499,"currency":"CNY"}`, 419, "CNY金额需要大于等于5元"},
+		{"above max", `{"order_no":"l-4","amount":2001,"currency":"CNY"}`, 418, "CNY金额需要小于等于20元"},
+		{"first", `{"order_no":"l-5","amount":2000,"currency":"CNY"}`, 0, ""},
+		{"retry within cap", `{"order_no":"l-5","amount":2000,"currency":"CNY"}`, 0, ""},
+		{"second", `{"order_no":"l-6","amount":1000,"currency":"CNY"}`, 0, ""},
+		{"daily cap", `{"order_no":"l-7","amount":500,"currency":"CNY"}`, 423, "今日订单金额已达上限30元"},
+	}
+	for _, c := range cases {
+		if got := serve(t, r, newOrderPOST(c.body)); got.Code != c.code || got.Error != c.err {
+			t.Fatalf("%s: POST /order %s = %+v; want code=%d error=%q", c.name, c.body, got, c.code, c.err)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ORDER_IP", "2/1m")
+	t.Setenv("RATE_LIMIT_ORDER_SITE", "3/1m")
+	t.Setenv("RATE_LIMIT_AFDIAN_IP", "1/1m")
+	t.Setenv("RATE_LIMIT_PAY_IP", "1/1m")
+	t.Setenv("ADMIN_TOKEN", "admin")
+	r, _ := newTestRouter(t)
+	post := func(ip, orderNo string) orderResp {
+		rq := newOrderPOST(`{"order_no":"` + orderNo + `","amount":500,"currency":"CNY"}`)
+		rq.RemoteAddr = ip + ":1234"
+		return serve(t, r, rq)
+	}
+	for i, want := range []int{0, 0, 429} {
+		if got := post("192.0.2.1", "rl-"+strconv.Itoa(i)); got.Code != want {
+			t.Fatalf("request %d from same ip = %+v, want code %d", i+1, got, want)
+		}
+	}
+	// 换 IP 后仍受站点维度限制：站点已消耗 2 次
+	if got := post("192.0.2.2", "rl-3"); got.Code != 0 {
+		t.Fatalf("other ip = %+v", got)
+	}
+	if got := post("192.0.2.3", "rl-4"); got.Code != 429 || got.Error != "请求过于频繁，请稍后再试" {
+		t.Fatalf("site limit = %+v", got)
+	}
+
+	callback := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPost, "/afdian", strings.NewReader(`{}`))
+		rq.RemoteAddr = "198.51.100.1:443"
+		r.ServeHTTP(w, rq)
+		return w
+	}
+	if w := callback(); w.Code != http.StatusOK {
+		t.Fatalf("first callback status = %d", w.Code)
+	}
+	if w := callback(); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("throttled callback = %d %s", w.Code, w.Body)
+	}
+
+	// 支付页与订单事件共用 IP 维度的限流
+	page := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, path, nil)
+		rq.RemoteAddr = "203.0.113.7:5555"
+		r.ServeHTTP(w, rq)
+		return w
+	}
+	if w := page("/pay/rl-0"); w.Code != http.StatusOK {
+		t.Fatalf("first pay page = %d", w.Code)
+	}
+	if w := page("/pay/rl-0"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("throttled pay page = %d", w.Code)
+	}
+	if w := page("/order/rl-0/events?timeout=0"); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"code":429`) {
+		t.Fatalf("throttled events = %d %s", w.Code, w.Body)
+	}
+
+	w := httptest.NewRecorder()
+	rq := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+	rq.Header.Set("Authorization", "Bearer admin")
+	r.ServeHTTP(w, rq)
+	for _, line := range []string{
+		`afdianpay_ratelimit_throttled_total{route="order",scope="ip"} 1`,
+		`afdianpay_ratelimit_throttled_total{route="order",scope="site"} 1`,
+		`afdianpay_ratelimit_throttled_total{route="afdian",scope="ip"} 1`,
+		`afdianpay_ratelimit_throttled_total{route="pay",scope="ip"} 2`,
+		`afdianpay_ratelimit_throttled_total{route="query",scope="ip"} 0`,
+		`afdianpay_ratelimit_keys{route="order",scope="ip"} 3`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Fatalf("metrics missing %q:\n%s", line, w.Body)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin")
+	r, _ := newTestRouter(t)
+	for auth, want := range map[string]int{
+		"Bearer admin":  http.StatusOK,
+		"admin":         http.StatusUnauthorized,
+		"bearer admin":  http.StatusUnauthorized,
+		"Bearer admin2": http.StatusUnauthorized,
+		"Bearer ":       http.StatusUnauthorized,
+		"":              http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+		rq.Header.Set("Authorization", auth)
+		r.ServeHTTP(w, rq)
+		if w.Code != want {
+			t.Errorf("Authorization %q = %d, want %d", auth, w.Code, want)
+		}
+	}
+}
+
+func TestWatcherLimit(t *testing.T) {
+	t.Setenv("ORDER_EVENTS_MAX_PER_IP", "2")
+	w := loadWatcherLimit()
+	if !w.acquire("a") || !w.acquire("a") || w.acquire("a") {
+		t.Fatal("third watcher from the same ip accepted")
+	}
+	if !w.acquire("b") {
+		t.Fatal("other ip rejected")
+	}
+	w.release("a")
+	if !w.acquire("a") {
+		t.Fatal("released slot not reusable")
+	}
+	w.release("b")
+	if len(w.n) != 1 {
+		t.Fatalf("tracked ips = %v", w.n)
+	}
+	t.Setenv("ORDER_EVENTS_MAX_PER_IP", "0")
+	if w := loadWatcherLimit(); !w.acquire("a") || !w.acquire("a") || !w.acquire("a") {
+		t.Fatal("0 should not limit")
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clientIP := func(remote string) string {
+		r := gin.New()
+		if err := r.SetTrustedProxies(TrustedProxies()); err != nil {
+			t.Fatal(err)
+		}
+		r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+		w := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		rq.RemoteAddr = remote
+		rq.Header.Set("X-Forwarded-For", "203.0.113.9")
+		r.ServeHTTP(w, rq)
+		return w.Body.String()
+	}
+	// 未设置时不采信任何 X-Forwarded-For
+	t.Setenv("TRUSTED_PROXIES", "")
+	if got := clientIP("192.0.2.1:1234"); got != "192.0.2.1" {
+		t.Fatalf("no trusted proxies: ClientIP = %s", got)
+	}
+	t.Setenv("TRUSTED_PROXIES", " 10.0.0.0/8 ,")
+	if got := clientIP("10.1.1.1:1234"); got != "203.0.113.9" {
+		t.Fatalf("trusted proxy: ClientIP = %s", got)
+	}
+	if got := clientIP("192.0.2.1:1234"); got != "192.0.2.1" {
+		t.Fatalf("untrusted peer: ClientIP = %s", got)
+	}
+}
+
+func TestPayURL(t *testing.T) {
+	t.Setenv("PUBLIC_URL", "")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 127.0.0.1")
+	s := &Server{proxies: loadTrustedProxies()}
+	cases := []struct {
+		remote, proto, want string
+	}{
+		{"192.0.2.1:1234", "", "http://gw.example.com/pay/p%2F1"},
+		// 直连客户端伪造的 X-Forwarded-Proto 不采信
+		{"192.0.2.1:1234", "https", "http://gw.example.com/pay/p%2F1"},
+		{"10.1.2.3:1234", "https", "https://gw.example.com/pay/p%2F1"},
+		{"127.0.0.1:1234", "https", "https://gw.example.com/pay/p%2F1"},
+		{"127.0.0.1:1234", "javascript", "http://gw.example.com/pay/p%2F1"},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/order", nil)
+		c.Request.Host = "gw.example.com"
+		c.Request.RemoteAddr = tc.remote
+		if tc.proto != "" {
+			c.Request.Header.Set("X-Forwarded-Proto", tc.proto)
+		}
+		if got := s.payURL(c, "p/1"); got != tc.want {
+			t.Errorf("%s proto=%q: %s, want %s", tc.remote, tc.proto, got, tc.want)
+		}
+	}
+	t.Setenv("PUBLIC_URL", "https://pay.example.com/")
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/order", nil)
+	if got := s.payURL(c, "p-1"); got != "https://pay.example.com/pay/p-1" {
+		t.Fatalf("PUBLIC_URL: %s", got)
+	}
+}
+
+func FuzzURLDecode(f *testing.F) {
+	for _, s := range []string{"", "abc", "a%3Ab", "%3a", "%", "%4", "%zz", "a+b", "%2B", "%%41", "abc%3A1700000000", "%E4%B8%AD"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		got, err := urlDecode(s)
+		if err != nil {
+			t.Fatalf("urlDecode(%q) err = %v", s, err)
+		}
+		if len(got) > len(s) {
+			t.Fatalf("urlDecode(%q) = %q longer than input", s, got)
+		}
+		// 合法编码时与标准库一致；非法的 % 序列原样保留而不是报错
+		if want, err := url.QueryUnescape(s); err == nil && got != want {
+			t.Fatalf("urlDecode(%q) = %q, want %q", s, got, want)
+		}
+		if !strings.ContainsAny(s, "%+") && got != s {
+			t.Fatalf("urlDecode(%q) = %q, want unchanged", s, got)
+		}
+		// 编码后再解码得到原文
+		if back, _ := urlDecode(url.QueryEscape(s)); back != s {
+			t.Fatalf("urlDecode(QueryEscape(%q)) = %q", s, back)
+		}
+	})
+}
+
+func TestPayPageRedirectDelay(t *testing.T) {
+	r, svc := newTestRouter(t)
+	if _, err := svc.NewOrder(`{"order_no":"p-1","notify_url":"http://cr/notify"}`, money.New(500, money.CNY)); err != nil {
+		t.Fatal(err)
+	}
+	page := func() string {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pay/p-1", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("pay page = %d", w.Code)
+		}
+		return w.Body.String()
+	}
+	// 未设置时默认 5 秒后跳转
+	if body := page(); !strings.Contains(body, `http-equiv="refresh" content="5;url=`) {
+		t.Fatalf("default delay missing refresh: %s", body)
+	}
+	t.Setenv("PAY_REDIRECT_DELAY", "0")
+	if body := page(); strings.Contains(body, "http-equiv") || strings.Contains(body, "秒后自动跳转") {
+		t.Fatalf("PAY_REDIRECT_DELAY=0 still redirects: %s", body)
+	}
+}
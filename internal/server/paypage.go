@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloudreve-afdianpay/internal/afdian"
+	"cloudreve-afdianpay/internal/config"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+//go:embed templates/pay.html
+var templateFS embed.FS
+
+// defaultPayRedirectDelay 未设置 PAY_REDIRECT_DELAY 时自动跳转前的等待时间
+const defaultPayRedirectDelay = 5 * time.Second
+
+// payPageData 支付页模板数据，自定义模板（PAY_PAGE_TEMPLATE）可使用以下字段
+type payPageData struct {
+	Title   string
+	OrderNo string
+	Status  string
+	// Amount 实际支付的 CNY 金额；Original* 为 Cloudreve 下单时的币种与金额
+	Amount           string
+	OriginalAmount   string
+	OriginalCurrency string
+	// Rate 换算汇率，未换算时为空
+	Rate string
+	// CheckoutURL 爱发电下单链接，订单不可支付时为空
+	CheckoutURL string
+	// QRCode 下单链接二维码（PNG data URI）
+	QRCode template.URL
+	// RedirectDelay 自动跳转前等待的秒数，0 表示不自动跳转
+	RedirectDelay int
+	// Message 订单不可支付时的提示
+	Message string
+}
+
+// loadPayTemplate 优先加载 PAY_PAGE_TEMPLATE 指定的模板，失败时回退到内置模板
+func loadPayTemplate() *template.Template {
+	if path := os.Getenv("PAY_PAGE_TEMPLATE"); path != "" {
+		t, err := template.ParseFiles(path)
+		if err == nil {
+			return t
+		}
+		log.Printf("[PayPage] load template %s error, using default: %v", path, err)
+	}
+	return template.Must(template.ParseFS(templateFS, "templates/pay.html"))
+}
+
+// payURL 返回订单的网关支付页地址；未配置 PUBLIC_URL 时按请求的 Host 推断，
+// 只有来自 TRUSTED_PROXIES 的请求才采信 X-Forwarded-Proto
+func (s *Server) payURL(c *gin.Context, orderNo string) string {
+	base := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		if p := c.GetHeader("X-Forwarded-Proto"); (p == "http" || p == "https") && s.fromTrustedProxy(c) {
+			scheme = p
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	return base + "/pay/" + url.PathEscape(orderNo)
+}
+
+// PayPage 支付中间页：展示金额、换算与注意事项，提供二维码并跳转到爱发电
+func (s *Server) PayPage(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	data := payPageData{Title: os.Getenv("PAY_PAGE_TITLE"), OrderNo: orderNo}
+	if data.Title == "" {
+		data.Title = "订单支付"
+	}
+	o, err := s.Svc.GetOrder(orderNo)
+	if err != nil {
+		log.Printf("[PayPage] get order %s error: %v", orderNo, err)
+		data.Message = "订单查询失败，请稍后刷新重试"
+		s.renderPayPage(c, http.StatusInternalServerError, &data)
+		return
+	}
+	if o == nil {
+		data.Message = "订单不存在"
+		s.renderPayPage(c, http.StatusNotFound, &data)
+		return
+	}
+	data.Status = o.Status
+	switch o.Status {
+	case afdian.StatusPending:
+	case afdian.StatusPaid:
+		data.Message = "订单已支付，可关闭本页面"
+	case afdian.StatusExpired:
+		data.Message = "订单已过期，请返回网站重新下单"
+	case afdian.StatusRefunded:
+		data.Message = "订单已退款"
+	default:
+		data.Message = "订单状态异常：" + o.Status
+	}
+	if data.Message != "" {
+		s.renderPayPage(c, http.StatusOK, &data)
+		return
+	}
+
+	checkout, err := s.Svc.CheckoutURL(o)
+	if err != nil {
+		log.Printf("[PayPage] checkout url error: %v", err)
+		data.Message = "暂时无法生成支付链接"
+		s.renderPayPage(c, http.StatusInternalServerError, &data)
+		return
+	}
+	data.CheckoutURL = checkout
+	data.Amount = o.Amount.String()
+	data.OriginalAmount = o.Original.String()
+	data.OriginalCurrency = string(o.Original.Currency)
+	if o.Original.Currency != o.Amount.Currency && o.Original.Minor > 0 {
+		data.Rate = fmt.Sprintf("%.4f", o.Amount.Float()/o.Original.Float())
+	}
+	if png, err := qrcode.Encode(checkout, qrcode.Medium, 256); err != nil {
+		log.Printf("[PayPage] qrcode error: %v", err)
+	} else {
+		data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+	data.RedirectDelay = int(config.Duration("PAY_REDIRECT_DELAY", defaultPayRedirectDelay) / time.Second)
+	s.renderPayPage(c, http.StatusOK, &data)
+}
+
+func (s *Server) renderPayPage(c *gin.Context, status int, data *payPageData) {
+	var buf bytes.Buffer
+	if err := s.payTmpl.Execute(&buf, data); err != nil {
+		log.Printf("[PayPage] render error: %v", err)
+		c.String(http.StatusInternalServerError, "页面渲染失败")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrustedProxies 读取 TRUSTED_PROXIES（逗号分隔的 IP 或 CIDR），未设置时返回 nil，即不信任任何代理
+func TrustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// loadTrustedProxies 将 TrustedProxies 解析为网段，单个 IP 视为 /32 或 /128；无效项忽略
+// （serve 启动时已通过 gin 校验）
+func loadTrustedProxies() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, p := range TrustedProxies() {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Printf("[Proxy] invalid trusted proxy %q: %v", p, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// fromTrustedProxy 请求是否直接来自 TRUSTED_PROXIES 中的代理，只有此时才采信 X-Forwarded-* 头
+func (s *Server) fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, n := range s.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
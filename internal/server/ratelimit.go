@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudreve-afdianpay/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limiter 一个路由在一个维度（站点或客户端 IP）上的限流器
+type limiter struct {
+	route string
+	scope string
+	*ratelimit.Limiter
+}
+
+// loadLimiter 读取 env 中的限流规则，格式错误时使用默认值
+func loadLimiter(route, scope, env, def string) *limiter {
+	v, ok := os.LookupEnv(env)
+	if !ok {
+		v = def
+	}
+	rule, err := ratelimit.ParseRule(v)
+	if err != nil {
+		log.Printf("[RateLimit] invalid %s=%q, using default %s: %v", env, v, def, err)
+		rule, _ = ratelimit.ParseRule(def)
+	}
+	return &limiter{route: route, scope: scope, Limiter: ratelimit.New(rule)}
+}
+
+// rateLimits 各路由的限流器：下单、爱发电回调、订单状态查询（GET /order）、支付页与订单事件
+type rateLimits struct {
+	orderSite, orderIP *limiter
+	afdianIP           *limiter
+	queryIP            *limiter
+	payIP              *limiter
+}
+
+func loadRateLimits() *rateLimits {
+	// 回调验签前无法区分真假，全局桶会被伪造的回调耗尽而拒绝真实回调，已移除
+	if _, ok := os.LookupEnv("RATE_LIMIT_AFDIAN_SITE"); ok {
+		log.Printf("[RateLimit] RATE_LIMIT_AFDIAN_SITE is no longer supported, ignoring")
+	}
+	return &rateLimits{
+		orderSite: loadLimiter("order", "site", "RATE_LIMIT_ORDER_SITE", "120/1m"),
+		orderIP:   loadLimiter("order", "ip", "RATE_LIMIT_ORDER_IP", "60/1m"),
+		afdianIP:  loadLimiter("afdian", "ip", "RATE_LIMIT_AFDIAN_IP", "300/1m"),
+		queryIP:   loadLimiter("query", "ip", "RATE_LIMIT_QUERY_IP", "300/1m"),
+		payIP:     loadLimiter("pay", "ip", "RATE_LIMIT_PAY_IP", "60/1m"),
+	}
+}
+
+func (r *rateLimits) all() []*limiter {
+	return []*limiter{r.orderSite, r.orderIP, r.afdianIP, r.queryIP, r.payIP}
+}
+
+// rejectOrder 以 Cloudreve 的 {code,error} 格式拒绝下单
+func rejectOrder(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", retryAfter(wait))
+	c.AbortWithStatusJSON(200, gin.H{"code": 429, "error": "请求过于频繁，请稍后再试"})
+}
+
+// rejectCallback 返回非 200 的 ec，爱发电稍后会重新推送
+func rejectCallback(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", retryAfter(wait))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"ec": 429, "em": "请求过于频繁"})
+}
+
+// rejectPage 支付页以纯文本返回 HTTP 429
+func rejectPage(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", retryAfter(wait))
+	c.Abort()
+	c.String(http.StatusTooManyRequests, "请求过于频繁，请稍后再试")
+}
+
+// rejectEvents 订单事件返回 HTTP 429，EventSource 收到非 200 响应后不再自动重连
+func rejectEvents(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", retryAfter(wait))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"code": 429, "error": "请求过于频繁，请稍后再试"})
+}
+
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// allow 消耗 key 的令牌，限流时调用 reject 并返回 false；被拒绝的请求计入 /admin/metrics，不逐条记录日志
+func allow(c *gin.Context, l *limiter, key string, reject func(*gin.Context, time.Duration)) bool {
+	ok, wait := l.Allow(key)
+	if !ok {
+		reject(c, wait)
+	}
+	return ok
+}
+
+// limitOrderIP 按客户端 IP 限制下单；站点维度在验签后检查（见 createOrder），避免伪造站点头绕过
+func (s *Server) limitOrderIP(c *gin.Context) {
+	if allow(c, s.rate.orderIP, c.ClientIP(), rejectOrder) {
+		c.Next()
+	}
+}
+
+// limitCallback 按客户端 IP 限制爱发电回调
+func (s *Server) limitCallback(c *gin.Context) {
+	if allow(c, s.rate.afdianIP, c.ClientIP(), rejectCallback) {
+		c.Next()
+	}
+}
+
+// limitQueryIP 按客户端 IP 限制订单状态查询（GET /order），验签前计数
+func (s *Server) limitQueryIP(c *gin.Context) {
+	if allow(c, s.rate.queryIP, c.ClientIP(), rejectOrder) {
+		c.Next()
+	}
+}
+
+// limitPayIP 按客户端 IP 限制支付页
+func (s *Server) limitPayIP(c *gin.Context) {
+	if allow(c, s.rate.payIP, c.ClientIP(), rejectPage) {
+		c.Next()
+	}
+}
+
+// limitEventsIP 订单事件与支付页共用 IP 维度的限流器
+func (s *Server) limitEventsIP(c *gin.Context) {
+	if allow(c, s.rate.payIP, c.ClientIP(), rejectEvents) {
+		c.Next()
+	}
+}
+
+// Metrics 以 Prometheus 文本格式输出限流指标
+func (s *Server) Metrics(c *gin.Context) {
+	var b strings.Builder
+	b.WriteString("# HELP afdianpay_ratelimit_throttled_total 被限流拒绝的请求数\n")
+	b.WriteString("# TYPE afdianpay_ratelimit_throttled_total counter\n")
+	for _, l := range s.rate.all() {
+		fmt.Fprintf(&b, "afdianpay_ratelimit_throttled_total{route=%q,scope=%q} %d\n", l.route, l.scope, l.Throttled())
+	}
+	b.WriteString("# HELP afdianpay_ratelimit_keys 当前跟踪的站点或 IP 数\n")
+	b.WriteString("# TYPE afdianpay_ratelimit_keys gauge\n")
+	for _, l := range s.rate.all() {
+		fmt.Fprintf(&b, "afdianpay_ratelimit_keys{route=%q,scope=%q} %d\n", l.route, l.scope, l.Keys())
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
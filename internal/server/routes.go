@@ -0,0 +1,25 @@
+package server
+
+import "github.com/gin-gonic/gin"
+
+// Register 注册网关的全部路由，/admin 接口需配置 ADMIN_TOKEN
+func (s *Server) Register(r gin.IRouter) {
+	r.POST("/afdian", s.limitCallback, s.AfdianCallback)
+	r.POST("/order", s.limitOrderIP, s.Order)
+	r.GET("/order", s.limitQueryIP, s.Order)
+	r.GET("/order/:order_no/events", s.limitEventsIP, s.OrderEvents)
+	r.GET("/pay/:order_no", s.limitPayIP, s.PayPage)
+
+	admin := r.Group("/admin", AdminAuth())
+	admin.GET("/orders", s.ListOrders)
+	admin.POST("/orders/:order_no/refund", s.RefundOrder)
+	admin.GET("/orders/:order_no/refunds", s.ListRefunds)
+	admin.GET("/orders/export", s.ExportOrders)
+	admin.GET("/journal", s.Journal)
+	admin.GET("/reviews", s.ListReviews)
+	admin.POST("/reviews/:id/attach", s.AttachReview)
+	admin.POST("/reviews/:id/dismiss", s.DismissReview)
+	admin.GET("/webhooks", s.ListWebhooks)
+	admin.POST("/webhooks/:id/retry", s.RetryWebhook)
+	admin.GET("/metrics", s.Metrics)
+}
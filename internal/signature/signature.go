@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -33,6 +34,27 @@ func Verify(r *http.Request, signature string, timestamp string) (bool, string)
 		return false, "时间戳验证失败"
 	}
 
+	computedSignature := sign(communicationKey, content(r), timestamp)
+	if computedSignature != signature {
+		log.Printf("[Sign] signature mismatch: got=%q want=%q", signature, computedSignature)
+		return false, "签名无效"
+	}
+	return true, ""
+}
+
+// Sign 按 Cloudreve 通信签名规则为请求设置 Authorization 头，POST 请求体需可通过 GetBody 重复读取
+func Sign(r *http.Request, key string, expires time.Time) {
+	r.Header.Set("Authorization", "Bearer Cr "+Token(r, key, expires))
+}
+
+// Token 返回请求的签名与过期时间 "签名:时间戳"，GET 请求以 sign 参数携带
+func Token(r *http.Request, key string, expires time.Time) string {
+	timestamp := strconv.FormatInt(expires.Unix(), 10)
+	return sign(key, content(r), timestamp) + ":" + timestamp
+}
+
+// content 构造待签名内容（与 Python 版一致）
+func content(r *http.Request) string {
 	var signContent string
 	if r.Method == http.MethodPost {
 		// 收集以 X-Cr- 开头的请求头
@@ -72,17 +94,15 @@ func Verify(r *http.Request, signature string, timestamp string) (bool, string)
 		signContent = path
 		log.Printf("[Sign] GET path=%s", path)
 	}
+	return signContent
+}
 
+func sign(key, signContent, timestamp string) string {
 	signContentFinal := signContent + ":" + timestamp
-	mac := hmac.New(sha256.New, []byte(communicationKey))
+	mac := hmac.New(sha256.New, []byte(key))
 	mac.Write([]byte(signContentFinal))
 	computed := mac.Sum(nil)
-	computedSignature := base64.URLEncoding.EncodeToString(computed)
-	if computedSignature != signature {
-		log.Printf("[Sign] signature mismatch: got=%q want=%q", signature, computedSignature)
-		return false, "签名无效"
-	}
-	return true, ""
+	return base64.URLEncoding.EncodeToString(computed)
 }
 
 func parseInt64(s string) (int64, error) {
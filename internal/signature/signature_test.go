@@ -0,0 +1,213 @@
+package signature
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goldenTS 2100-01-01，保证向量长期有效
+const goldenTS = "4102444800"
+
+// 合成向量：按 Cloudreve 源码 pkg/auth 中的签名规则（HMAC-SHA256 + URL-safe base64，
+// POST 为 json.Marshal 后的 {Path, Header, Body}，& < > 转义为 \u0026 \u003c \u003e）
+// 由 testdata/golden.py 独立计算，不依赖本包代码，但并非从运行中的 Cloudreve 抓取。
+// 增加向量时同时修改该脚本并以其输出为准。content 为待签名内容，用于定位差异
+var golden = []struct {
+	name    string
+	key     string
+	method  string
+	url     string
+	headers [][2]string
+	body    string
+	content string
+	sign    string
+}{
+	{
+		name: "get", key: "key1", method: http.MethodGet, url: "http://gw/order?order_no=1",
+		content: "/order",
+		sign:    "5qeiat7JQBzfE551gZMLJBy-1KsOBMqI3nQyE3lrJZI=",
+	},
+	{
+		name: "get empty path", key: "key1", method: http.MethodGet, url: "http://gw",
+		content: "/",
+		sign:    "KvPHLQWMQ8m2Srpa6b7qabLqUwQwp4prgRR6M1tXbjg=",
+	},
+	{
+		name: "post", key: "communication-key", method: http.MethodPost, url: "http://gw/order",
+		// 乱序与非 X-Cr- 头：签名时按名称排序且只取 X-Cr- 头
+		headers: [][2]string{{"X-Cr-Version", "4.0.0"}, {"Content-Type", "application/json"}, {"x-cr-site-url", "https://cloudreve.example.com"}},
+		body:    `{"name":"容量包","order_no":"20240101-1","notify_url":"https://cloudreve.example.com/api/v4/callback/custom/20240101-1/1","amount":500,"currency":"CNY"}`,
+		content: `{"Path":"/order","Header":"X-Cr-Site-Url=https://cloudreve.example.com\u0026X-Cr-Version=4.0.0","Body":"{\"name\":\"容量包\",\"order_no\":\"20240101-1\",\"notify_url\":\"https://cloudreve.example.com/api/v4/callback/custom/20240101-1/1\",\"amount\":500,\"currency\":\"CNY\"}"}`,
+		sign:    "piQO6lHnWZnj6cj0C1iDZa3rpLeHtQ0vC-ZjI_98Kpw=",
+	},
+	{
+		name: "post html escape", key: "communication-key", method: http.MethodPost, url: "http://gw/order",
+		headers: [][2]string{{"X-Cr-Site-Url", "https://a.example.com/?x=1&y=2"}},
+		body:    `{"name":"a&b<c>"}`,
+		content: `{"Path":"/order","Header":"X-Cr-Site-Url=https://a.example.com/?x=1\u0026y=2","Body":"{\"name\":\"a\u0026b\u003cc\u003e\"}"}`,
+		sign:    "ZTIPSaMP7IMRl-k4qF4loMWaSP_GyejGn9qY3dlTvCM=",
+	},
+	{
+		name: "post empty body", key: "k", method: http.MethodPost, url: "http://gw/order",
+		content: `{"Path":"/order","Header":"","Body":""}`,
+		sign:    "WyssdGeZX1J8XKP93bHHIW8iDPftyXy0s4mKBKIm9Ic=",
+	},
+}
+
+// newRequest 构造可重复读取请求体的请求，与网关缓存请求体后的状态一致
+func newRequest(t testing.TB, method, url string, headers [][2]string, body string) *http.Request {
+	t.Helper()
+	var rd io.Reader
+	if method == http.MethodPost {
+		rd = bytes.NewReader([]byte(body))
+	}
+	r, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range headers {
+		r.Header.Add(h[0], h[1])
+	}
+	return r
+}
+
+func TestVerifyGolden(t *testing.T) {
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			t.Setenv("COMMUNICATION_KEY", g.key)
+			r := newRequest(t, g.method, g.url, g.headers, g.body)
+			if got := content(r); got != g.content {
+				t.Fatalf("content:\n got %s\nwant %s", got, g.content)
+			}
+			if ok, msg := Verify(r, g.sign, goldenTS); !ok {
+				t.Fatalf("Verify = false, %s", msg)
+			}
+			if got := Token(r, g.key, time.Unix(4102444800, 0)); got != g.sign+":"+goldenTS {
+				t.Fatalf("Token = %s", got)
+			}
+			// 请求体可被再次读取
+			if g.method == http.MethodPost {
+				if b, _ := io.ReadAll(r.Body); string(b) != g.body {
+					t.Fatalf("body consumed: %q", b)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyGET(t *testing.T) {
+	const key = "key1"
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	signPath := func(path, ts string) string {
+		return sign(key, path, ts)
+	}
+	cases := []struct {
+		name string
+		url  string
+		sig  string
+		ts   string
+		ok   bool
+		msg  string
+	}{
+		{"ok", "http://gw/order?order_no=1", signPath("/order", future), future, true, ""},
+		// GET 只对路径签名，查询参数不参与
+		{"query ignored", "http://gw/order?order_no=2&x=y", signPath("/order", future), future, true, ""},
+		{"other path", "http://gw/orders", signPath("/order", future), future, false, "签名无效"},
+		{"wrong sign", "http://gw/order", "AAAA", future, false, "签名无效"},
+		{"expired", "http://gw/order", signPath("/order", "1700000000"), "1700000000", false, "时间戳验证失败"},
+		{"non numeric ts", "http://gw/order", signPath("/order", "12a"), "12a", false, "无效的时间戳"},
+		{"negative ts", "http://gw/order", signPath("/order", "-1"), "-1", false, "无效的时间戳"},
+		{"empty ts", "http://gw/order", signPath("/order", ""), "", false, "时间戳验证失败"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("COMMUNICATION_KEY", key)
+			r := newRequest(t, http.MethodGet, c.url, nil, "")
+			ok, msg := Verify(r, c.sig, c.ts)
+			if ok != c.ok || msg != c.msg {
+				t.Fatalf("Verify = %v, %q; want %v, %q", ok, msg, c.ok, c.msg)
+			}
+		})
+	}
+}
+
+func TestVerifyPOST(t *testing.T) {
+	const key = "communication-key"
+	expires := time.Now().Add(time.Hour)
+	base := [][2]string{{"X-Cr-Site-Url", "https://cloudreve.example.com"}, {"X-Cr-Version", "4.0.0"}}
+	body := `{"order_no":"1","amount":500}`
+	cases := []struct {
+		name    string
+		headers [][2]string
+		body    string
+		ok      bool
+	}{
+		{"ok", base, body, true},
+		{"non X-Cr header ignored", append([][2]string{{"User-Agent", "other"}}, base...), body, true},
+		{"header order ignored", [][2]string{base[1], base[0]}, body, true},
+		{"body changed", base, `{"order_no":"1","amount":1}`, false},
+		{"body whitespace", base, body + "\n", false},
+		{"X-Cr header changed", [][2]string{base[0], {"X-Cr-Version", "4.0.1"}}, body, false},
+		{"X-Cr header added", append([][2]string{{"X-Cr-Extra", "1"}}, base...), body, false},
+		{"X-Cr header removed", base[:1], body, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("COMMUNICATION_KEY", key)
+			signed := newRequest(t, http.MethodPost, "http://gw/order", base, body)
+			tok := strings.SplitN(Token(signed, key, expires), ":", 2)
+			r := newRequest(t, http.MethodPost, "http://gw/order", c.headers, c.body)
+			if ok, msg := Verify(r, tok[0], tok[1]); ok != c.ok {
+				t.Fatalf("Verify = %v, %q; want %v", ok, msg, c.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutKey(t *testing.T) {
+	t.Setenv("COMMUNICATION_KEY", "")
+	r := newRequest(t, http.MethodGet, "http://gw/order", nil, "")
+	if ok, msg := Verify(r, "x", goldenTS); ok || msg != "服务端配置错误" {
+		t.Fatalf("Verify = %v, %q", ok, msg)
+	}
+}
+
+func TestSignHeader(t *testing.T) {
+	t.Setenv("COMMUNICATION_KEY", "k")
+	r := newRequest(t, http.MethodPost, "http://gw/order", [][2]string{{"X-Cr-Site-Url", "https://a"}}, `{}`)
+	Sign(r, "k", time.Now().Add(time.Minute))
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer Cr ") {
+		t.Fatalf("Authorization = %q", auth)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(auth, "Bearer Cr "), ":", 2)
+	if ok, msg := Verify(r, parts[0], parts[1]); !ok {
+		t.Fatalf("Verify signed request = false, %s", msg)
+	}
+}
+
+func FuzzParseInt64(f *testing.F) {
+	for _, s := range []string{"", "0", "4102444800", "-1", "+1", "1e9", " 1", "9223372036854775807", "12a"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		n, err := parseInt64(s)
+		digits := strings.Trim(s, "0123456789") == ""
+		if digits != (err == nil) {
+			t.Fatalf("parseInt64(%q) err = %v", s, err)
+		}
+		if !digits || s == "" || len(s) > 18 {
+			return
+		}
+		// 18 位以内不会溢出，结果与 strconv 一致
+		want, _ := strconv.ParseInt(s, 10, 64)
+		if n != want {
+			t.Fatalf("parseInt64(%q) = %d, want %d", s, n, want)
+		}
+	})
+}